@@ -96,10 +96,12 @@ func (ctrs counters) union(o counters) {
 
 func (ctrs counters) termCountChildren(term parser.Term, parent counter) {
 	switch t := term.(type) {
-	case parser.S, parser.RE:
+	case parser.S, parser.SI, parser.RE, parser.Position:
 		ctrs.count("", parent)
 	case parser.Rule:
 		ctrs.count(string(t), parent)
+	case parser.Token:
+		ctrs.count(string(t.Rule), parent)
 	case parser.Seq:
 		for _, child := range t {
 			ctrs.termCountChildren(child, parent)
@@ -125,6 +127,10 @@ func (ctrs counters) termCountChildren(term parser.Term, parent counter) {
 		ctrs.termCountChildren(t.Term, parent)
 	case parser.LookAhead:
 		ctrs.termCountChildren(t.Term, parent)
+	case parser.LookBehind:
+		ctrs.termCountChildren(t.Term, parent)
+	case parser.NotLookAhead, parser.NotLookBehind:
+		// Negative lookarounds match nothing.
 	case parser.ExtRef:
 		ctrs.count(string(t), parent)
 	default:
@@ -117,9 +117,13 @@ func (b Branch) many(name string, node Node) {
 
 func (b Branch) fromParserNode(g parser.Grammar, term parser.Term, ctrs counters, e parser.TreeElement) {
 	var tag string
-	// defer enterf("fromParserNode(term=%T(%[1]v), ctrs=%v, v=%v)", term, ctrs, e).exitf("tag=%q, n=%v", &tag, &n)
+	if e, ok := e.(parser.ErrorNode); ok {
+		// Input skipped by error recovery is kept as a leaf under @error.
+		b.many(ErrorTag, Leaf(e.Skipped))
+		return
+	}
 	switch t := term.(type) {
-	case parser.S, parser.RE:
+	case parser.S, parser.SI, parser.RE, parser.Position:
 		b.add("", Leaf(e.(parser.Scanner)), ctrs[""])
 	case parser.Rule:
 		rule := g[t]
@@ -134,6 +138,12 @@ func (b Branch) fromParserNode(g parser.Grammar, term parser.Term, ctrs counters
 		// }
 		node = node.collapse(level)
 		b.add(unleveled, node, ctrs[string(t)])
+	case parser.Token:
+		node := e.(parser.Node)
+		b2 := Branch{}
+		b2.one(LabelTag, Extra{Data: node.Extra.(parser.Label)})
+		b2.one("", Leaf(node.Children[0].(parser.Scanner)))
+		b.add(string(t.Rule), b2, ctrs[string(t.Rule)])
 	case parser.ScopedGrammar:
 		gcopy := g
 		for rule, terms := range t.Grammar {
@@ -213,6 +223,12 @@ func (b Branch) fromParserNode(g parser.Grammar, term parser.Term, ctrs counters
 		for _, child := range node.Children {
 			b.fromParserNode(g, t.Term, ctrs, child)
 		}
+	case parser.LookBehind:
+		node := e.(parser.Node)
+		for _, child := range node.Children {
+			b.fromParserNode(g, t.Term, ctrs, child)
+		}
+	case parser.NotLookAhead, parser.NotLookBehind:
 	default:
 		panic(fmt.Errorf("branch.fromParserNode: unexpected term type: %v %[1]T", t))
 	}
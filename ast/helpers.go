@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"sort"
+
 	"github.com/arr-ai/wbnf/parser"
 )
 
@@ -29,6 +31,18 @@ func Choice(n Node) int {
 	return -1
 }
 
+// Label returns the label of the alternative of a token rule that a token
+// matched, or "" if n isn't one.
+func Label(n Node) string {
+	if n == nil {
+		return ""
+	}
+	if label := First(n, LabelTag); label != nil {
+		return string(label.(Extra).Data.(parser.Label))
+	}
+	return ""
+}
+
 // First finds the first child of the given node with the named tag. nil if the named node does not exist
 func First(n Node, name string) Node {
 	if n == nil {
@@ -56,3 +70,33 @@ func All(n Node, name string) []Node {
 	}
 	return nil
 }
+
+// Errors returns the input skipped by error recovery anywhere under n, in input
+// order.
+func Errors(n Node) []parser.Scanner {
+	var result []parser.Scanner
+	var walk func(n Node)
+	walk = func(n Node) {
+		b, ok := n.(Branch)
+		if !ok {
+			return
+		}
+		for name, children := range b {
+			switch children := children.(type) {
+			case One:
+				walk(children.Node)
+			case Many:
+				for _, child := range children {
+					if name == ErrorTag {
+						result = append(result, child.Scanner())
+					} else {
+						walk(child)
+					}
+				}
+			}
+		}
+	}
+	walk(n)
+	sort.Slice(result, func(i, j int) bool { return result[i].Offset() < result[j].Offset() })
+	return result
+}
@@ -15,10 +15,16 @@ const (
 	oneofTag = "|"
 	delimTag = ":"
 	quantTag = "?"
+	tokenTag = "::"
+
+	notAheadTag  = "?!"
+	notBehindTag = "?<!"
 
 	RuleTag   = "@rule"
 	ChoiceTag = "@choice"
+	LabelTag  = "@label"
 	SkipTag   = "@skip"
+	ErrorTag  = "@error"
 )
 
 type Children interface {
@@ -139,6 +139,16 @@ func (l Leaf) Scanner() parser.Scanner {
 	return parser.Scanner(l)
 }
 
+// Leading returns the trivia before the leaf, if recorded by parser.WithTrivia.
+func (l Leaf) Leading() parser.Scanner {
+	return parser.Scanner(l).Leading()
+}
+
+// Trailing returns the trivia after the leaf, if recorded by parser.WithTrivia.
+func (l Leaf) Trailing() parser.Scanner {
+	return parser.Scanner(l).Trailing()
+}
+
 func (b Branch) Scanner() parser.Scanner {
 	if len(b) == 1 && b.oneChild() != nil {
 		return b.oneChild().Scanner()
@@ -76,3 +76,22 @@ func TestBranchScanner(t *testing.T) {
 func assertBranchScanner(t *testing.T, s *parser.Scanner, b Branch) {
 	assert.Equal(t, *s, b.Scanner())
 }
+
+func TestLeafTrivia(t *testing.T) {
+	g := parser.Grammar{
+		"stmt":    parser.Seq{parser.Eq("key", parser.RE(`\w+`)), parser.S("="), parser.Eq("value", parser.RE(`\w+`))},
+		".wrapRE": parser.RE(`(?:\s|#.*)*()(?:\s|#.*)*`),
+	}
+	tree, err := g.Compile(nil).Parse("stmt", parser.NewScanner("# key\na = b # value\n"), parser.WithTrivia())
+	if !assert.NoError(t, err) {
+		return
+	}
+	b := FromParserNode(g, tree)
+	key := First(b, "key").One("").(Leaf)
+	assert.Equal(t, "# key\n", key.Leading().String())
+	assert.Equal(t, " ", key.Trailing().String())
+	value := First(b, "value").One("").(Leaf)
+	assert.Equal(t, "", value.Leading().String())
+	assert.Equal(t, " # value\n", value.Trailing().String())
+	assert.Equal(t, "# key\n", b.Scanner().Leading().String())
+}
@@ -75,9 +75,8 @@ func (b Branch) pullFromMany(name string) Node {
 }
 
 func (b Branch) toParserNode(g parser.Grammar, term parser.Term, ctrs counters) (out parser.TreeElement) {
-	// defer enterf("%v.toParserNode(g, term=%T(%[2]v), ctrs=%v)", n, term, ctrs).exitf("%v", &out)
 	switch t := term.(type) {
-	case parser.S, parser.RE:
+	case parser.S, parser.SI, parser.RE, parser.Position:
 		if node := b.pull("", ctrs[""]); node != nil {
 			return parser.Scanner(node.(Leaf))
 		}
@@ -104,6 +103,16 @@ func (b Branch) toParserNode(g parser.Grammar, term parser.Term, ctrs counters)
 			}
 		}
 		return nil
+	case parser.Token:
+		if node := b.pull(string(t.Rule), ctrs[string(t.Rule)]); node != nil {
+			token := node.(Branch)
+			return parser.Node{
+				Tag:      tokenTag,
+				Extra:    token.One(LabelTag).(Extra).Data.(parser.Label),
+				Children: []parser.TreeElement{parser.Scanner(token.One("").(Leaf))},
+			}
+		}
+		return nil
 	case parser.ScopedGrammar:
 		gcopy := g
 		for rule, terms := range t.Grammar {
@@ -181,6 +190,12 @@ func (b Branch) toParserNode(g parser.Grammar, term parser.Term, ctrs counters)
 		return b.toParserNode(g, t.Term, ctrs)
 	case parser.LookAhead:
 		return b.toParserNode(g, t.Term, ctrs)
+	case parser.LookBehind:
+		return b.toParserNode(g, t.Term, ctrs)
+	case parser.NotLookAhead:
+		return parser.Node{Tag: notAheadTag}
+	case parser.NotLookBehind:
+		return parser.Node{Tag: notBehindTag}
 	default:
 		panic(fmt.Errorf("branch.toParserNode: unexpected term type: %v %[1]T", t))
 	}
@@ -83,6 +83,10 @@ func walkTerm(term parser.Term) GoNode {
 		}
 	case parser.S:
 		node.name = fmt.Sprintf("parser.S(`%s`)", safeString(string(t)))
+	case parser.SI:
+		node.name = fmt.Sprintf("parser.SI(`%s`)", safeString(string(t)))
+	case parser.Position, parser.Token:
+		node = stringNode("%#v", t)
 	case parser.Delim:
 		node.name = "parser.Delim"
 		node.scope = squigglyScope
@@ -158,6 +162,18 @@ func walkTerm(term parser.Term) GoNode {
 		node.name = "parser.LookAhead"
 		node.scope = squigglyScope
 		node.Add(walkTerm(t.Term))
+	case parser.NotLookAhead:
+		node.name = "parser.NotLookAhead"
+		node.scope = squigglyScope
+		node.Add(walkTerm(t.Term))
+	case parser.LookBehind:
+		node.name = "parser.LookBehind"
+		node.scope = squigglyScope
+		node.Add(walkTerm(t.Term))
+	case parser.NotLookBehind:
+		node.name = "parser.NotLookBehind"
+		node.scope = squigglyScope
+		node.Add(walkTerm(t.Term))
 	default:
 		panic(fmt.Errorf("walkTerm: unexpected term type: %v %[1]T", t))
 	}
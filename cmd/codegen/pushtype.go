@@ -50,6 +50,8 @@ func getNewCount(old countManager, new GrammarType) countManager {
 		return old.merge(t.count)
 	case namedToken:
 		return old.merge(t.count)
+	case labeledToken:
+		return old.merge(t.count)
 	case namedRule:
 		return old.merge(t.count)
 	case stackBackRef, backRef:
@@ -82,6 +84,10 @@ func checkForDupes(children []GrammarType, next GrammarType) []GrammarType {
 			child.count = getNewCount(child.count, next)
 			c = child
 			appendNext = false
+		case labeledToken:
+			child.count = getNewCount(child.count, next)
+			c = child
+			appendNext = false
 		case stackBackRef:
 			if _, ok := next.(stackBackRef); ok {
 				return children
@@ -100,7 +100,7 @@ func (tm *TypeMap) makeLeafType(
 				count:      quant,
 			}
 		}
-	case parser.S, parser.RE:
+	case parser.S, parser.SI, parser.RE, parser.Position:
 		val = unnamedToken{parentName, quant}
 	default:
 		panic("Should not have got here")
@@ -116,8 +116,14 @@ func (tm *TypeMap) walkTerm(
 	termID int,
 ) {
 	switch t := term.(type) {
-	case parser.S, parser.RE, parser.Rule:
+	case parser.S, parser.SI, parser.RE, parser.Position, parser.Rule:
 		tm.makeLeafType(term, parentName, quant.pushSingleNode(termID), knownRules)
+	case parser.Token:
+		tm.pushType("", parentName, labeledToken{
+			name:   string(t.Rule),
+			parent: parentName,
+			count:  quant.pushSingleNode(termID),
+		})
 	case parser.REF:
 		tm.pushType("", parentName, backRef{
 			name:   t.Ident,
@@ -143,7 +149,7 @@ func (tm *TypeMap) walkTerm(
 		case parser.Named:
 			childName := parentName + GoName(delim.Name)
 			switch delim.Term.(type) {
-			case parser.S, parser.CutPoint: //fixme: This will only work as long as cutpoints are s() only
+			case parser.S, parser.SI, parser.CutPoint: //fixme: This will only work as long as cutpoints are s() only
 				tm.pushType(childName, parentName, namedToken{
 					name:   delim.Name,
 					parent: parentName,
@@ -155,7 +161,7 @@ func (tm *TypeMap) walkTerm(
 		case parser.Rule:
 			childName := parentName + GoName(delim.String())
 			tm.walkTerm(t.Sep, childName, setWantAllGetter(), knownRules, termID)
-		case parser.CutPoint, parser.S: // ignore the delim
+		case parser.CutPoint, parser.S, parser.SI: // ignore the delim
 		default:
 			childName := parentName + "Delim"
 			tm.walkTerm(t.Sep, childName, setWantAllGetter(), knownRules, termID)
@@ -204,6 +210,10 @@ func (tm *TypeMap) walkTerm(
 		tm.walkTerm(t.Term, parentName, quant, knownRules, termID)
 	case parser.LookAhead:
 		tm.walkTerm(t.Term, parentName, quant, knownRules, termID)
+	case parser.LookBehind:
+		tm.walkTerm(t.Term, parentName, quant, knownRules, termID)
+	case parser.NotLookAhead, parser.NotLookBehind:
+		// nothing to access
 	case parser.ExtRef:
 		// nothing yet
 	default:
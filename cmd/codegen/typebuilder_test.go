@@ -190,6 +190,19 @@ func TestTypeBuilder_RuleWithOnlyNamedTokenVal(t *testing.T) {
 	})
 }
 
+func TestTypeBuilder_RuleWithTokenRefs(t *testing.T) {
+	types := initTypeBuilderTest(t, "a -> t::x t::y?; b -> t::x; t -> x='x' | y='y';")
+	assert.NotEmpty(t, types)
+	testChildren(t, types["ANode"].Children(), childrenTestData{
+		"t": {t: labeledToken{}},
+	})
+	assert.True(t, types["ANode"].Children()[0].(labeledToken).count.wantAll())
+	testChildren(t, types["BNode"].Children(), childrenTestData{
+		"t": {t: labeledToken{}},
+	})
+	assert.Contains(t, types["BNode"].String(), "func (c BNode) OneTLabel() string {")
+}
+
 func TestTypeBuilder_RuleWithUnnamedRuleVal(t *testing.T) {
 	types := initTypeBuilderTest(t, "a -> x; x -> 'a''b';")
 	assert.NotEmpty(t, types)
@@ -283,3 +296,15 @@ func TestDropCaps(t *testing.T) {
 		})
 	}
 }
+
+func TestGoName(t *testing.T) {
+	for in, expected := range map[string]string{
+		"json.value": "JsonValue",
+		"json.NUM":   "JsonNum",
+		"json#STR":   "Json_Str",
+		"json.STR":   "JsonStr",
+		"a.json#STR": "AJson_Str",
+	} {
+		assert.Equal(t, expected, GoName(in), in)
+	}
+}
@@ -28,7 +28,13 @@ func GoName(rule string) string {
 		return val
 	}
 
-	res := strcase.ToCamel(DropCaps(rule))
+	// Rules hidden by an import are named file#rule. CamelCase never keeps an
+	// underscore, so joining the parts with one keeps json#STR from json.STR.
+	parts := strings.Split(rule, "#")
+	for i, part := range parts {
+		parts[i] = strcase.ToCamel(DropCaps(part))
+	}
+	res := strings.Join(parts, "_")
 	gotypemap[rule] = res
 	return res
 }
@@ -79,6 +85,10 @@ type (
 		parent string
 		count  countManager
 	}
+	labeledToken struct { // a token matched by tok::label
+		name, parent string
+		count        countManager
+	}
 	namedRule struct {
 		name, parent, returnType string
 		count                    countManager
@@ -294,6 +304,52 @@ func (c {{parent}}) AllToken() []string {
 }
 func (t unnamedToken) CallbackData() *callbackData { return nil }
 
+func (t labeledToken) TypeName() string        { return "" /* not exported */ }
+func (t labeledToken) Ident() string           { return t.name }
+func (t labeledToken) Children() []GrammarType { return nil }
+func (t labeledToken) String() string {
+	replacer := strings.NewReplacer("{{parent}}", GoTypeName(t.parent),
+		"{{childtype}}", GoName(t.name),
+		"{{name}}", IdentName(t.name),
+	)
+	out := ""
+	if t.count.wantOne() {
+		out += replacer.Replace(`
+func (c {{parent}}) One{{childtype}}() string {
+	if child := ast.First(c.Node, {{name}}); child != nil {
+		return ast.First(child, "").Scanner().String()
+	}
+	return ""
+}
+
+func (c {{parent}}) One{{childtype}}Label() string {
+	return ast.Label(ast.First(c.Node, {{name}}))
+}
+`)
+	}
+	if t.count.wantAll() {
+		out += replacer.Replace(`
+func (c {{parent}}) All{{childtype}}() []string {
+	var out []string
+	for _, child := range ast.All(c.Node, {{name}}) {
+		out = append(out, ast.First(child, "").Scanner().String())
+	}
+	return out
+}
+
+func (c {{parent}}) All{{childtype}}Label() []string {
+	var out []string
+	for _, child := range ast.All(c.Node, {{name}}) {
+		out = append(out, ast.Label(child))
+	}
+	return out
+}
+`)
+	}
+	return out
+}
+func (t labeledToken) CallbackData() *callbackData { return nil }
+
 func (t namedRule) TypeName() string        { return "" /* not exported */ }
 func (t namedRule) Ident() string           { return t.name }
 func (t namedRule) Children() []GrammarType { return nil }
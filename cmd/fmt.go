@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/urfave/cli"
+
+	"github.com/arr-ai/wbnf/wbnf"
+)
+
+var listFiles bool
+var writeFiles bool
+var showDiff bool
+var fmtCommand = cli.Command{
+	Name:      "fmt",
+	Usage:     "Format grammars",
+	ArgsUsage: "[files...]",
+	Action:    formatFiles,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:        "l",
+			Usage:       "list files whose formatting differs",
+			Destination: &listFiles,
+		},
+		cli.BoolFlag{
+			Name:        "w",
+			Usage:       "write the result to the source file instead of stdout",
+			Destination: &writeFiles,
+		},
+		cli.BoolFlag{
+			Name:        "d",
+			Usage:       "display diffs instead of rewriting files",
+			Destination: &showDiff,
+		},
+	},
+}
+
+func formatFiles(c *cli.Context) error {
+	if c.NArg() == 0 {
+		if writeFiles {
+			return fmt.Errorf("cannot use -w with standard input")
+		}
+		return formatFile("<standard input>", os.Stdin, os.Stdout)
+	}
+	for _, filename := range c.Args() {
+		if err := formatFile(filename, nil, os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFile formats the named file, read from in if not nil, and reports the
+// result to out according to the -l, -w and -d flags.
+func formatFile(filename string, in io.Reader, out io.Writer) error {
+	var src []byte
+	var err error
+	if in != nil {
+		src, err = io.ReadAll(in)
+	} else {
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := wbnf.Format(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if string(src) != res {
+		if listFiles {
+			fmt.Fprintln(out, filename)
+		}
+		if writeFiles {
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filename, []byte(res), info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		if showDiff {
+			diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(src)),
+				B:        difflib.SplitLines(res),
+				FromFile: filename + ".orig",
+				ToFile:   filename,
+				Context:  3,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(out, diff)
+		}
+	}
+	if !listFiles && !writeFiles && !showDiff {
+		_, err = io.WriteString(out, res)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/arr-ai/wbnf/parser"
+	"github.com/arr-ai/wbnf/wbnf"
+)
+
+var lintCommand = cli.Command{
+	Name:      "lint",
+	Usage:     "Report likely mistakes in grammars",
+	ArgsUsage: "[files...]",
+	Action:    lintFiles,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:        "start",
+			Usage:       "rule that the other rules must be reachable from (default: the first rule)",
+			Destination: &startingRule,
+		},
+	},
+}
+
+func lintFiles(c *cli.Context) error {
+	problems := 0
+	for _, filename := range c.Args() {
+		text, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		tree, findings, err := wbnf.Load(string(text), makeResolver(filename))
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, finding := range wbnf.Lint(tree, startingRule, findings...) {
+			// Findings in the file itself have no filename, unlike those in
+			// the files it imports.
+			if s, ok := finding.(interface{ Scanner() parser.Scanner }); ok && s.Scanner().Filename() == "" {
+				fmt.Printf("%s:", filename)
+			}
+			fmt.Println(finding)
+			// Information, such as left recursion the parser handles, isn't a
+			// problem.
+			type severe interface {
+				Severity() wbnf.ValidationSeverity
+			}
+			if s, ok := finding.(severe); !ok || s.Severity() != wbnf.SeverityInfo {
+				problems++
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
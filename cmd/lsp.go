@@ -0,0 +1,19 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/arr-ai/wbnf/cmd/lsp"
+)
+
+var lspCommand = cli.Command{
+	Name:   "lsp",
+	Usage:  "Run a language server for grammars over stdio",
+	Action: runLSP,
+}
+
+func runLSP(c *cli.Context) error {
+	return lsp.Serve(os.Stdin, os.Stdout)
+}
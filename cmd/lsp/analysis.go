@@ -0,0 +1,383 @@
+package lsp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arr-ai/wbnf/parser"
+	"github.com/arr-ai/wbnf/wbnf"
+)
+
+type symbolKind int
+
+const (
+	ruleDef symbolKind = iota
+	ruleRef
+	macroDef
+	macroRef
+	importPath
+)
+
+// symbol is an occurrence of a name in a grammar.
+type symbol struct {
+	kind  symbolKind
+	name  string
+	s     parser.Scanner
+	scope *parser.Scanner // the term whose nested grammar defines a rule, or nil
+	path  string          // the resolved file of an import
+}
+
+// analysis is what the server knows about one open grammar document.
+type analysis struct {
+	doc         *document
+	dir         string
+	symbols     []symbol
+	grammar     parser.Grammar // nil unless the grammar compiles
+	diagnostics []Diagnostic
+}
+
+// importResolver resolves imports relative to the importing file, or to dir
+// for the document itself.
+type importResolver struct {
+	dir string
+}
+
+func (r importResolver) Resolve(from, path string) string {
+	if from == "" {
+		return filepath.Join(r.dir, path)
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+func analyse(doc *document) *analysis {
+	a := &analysis{
+		doc:         doc,
+		dir:         filepath.Dir(uriToPath(doc.uri)),
+		diagnostics: []Diagnostic{},
+	}
+	resolver := importResolver{a.dir}
+
+	own, err := wbnf.Parse(parser.NewScanner(doc.text))
+	if err != nil {
+		a.diagnostics = append(a.diagnostics, syntaxDiagnostic(doc, err))
+		return a
+	}
+	tree, findings, err := wbnf.Load(doc.text, resolver)
+	if err != nil {
+		// The document parsed, so one of its imports must have failed.
+		a.diagnostics = append(a.diagnostics, a.importDiagnostic(own, err))
+		a.symbols = collectSymbols(own, resolver)
+		return a
+	}
+	a.symbols = collectSymbols(tree, resolver)
+
+	valid := true
+	for _, err := range wbnf.Validate(tree, findings...) {
+		if d, ok := a.validationDiagnostic(err); ok {
+			a.diagnostics = append(a.diagnostics, d)
+		}
+		if severity(err) == wbnf.SeverityError {
+			valid = false
+		}
+	}
+	if valid {
+		if p, err := wbnf.Compile(doc.text, resolver); err == nil {
+			a.grammar = p.Grammar()
+		}
+	}
+	return a
+}
+
+func syntaxDiagnostic(doc *document, err error) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Source: "wbnf", Message: "syntax error"}
+	switch err := err.(type) {
+	case interface{ Furthest() parser.Failure }:
+		f := err.Furthest()
+		expected := make([]string, 0, len(f.Expected))
+		for _, t := range f.Expected {
+			expected = append(expected, t.String())
+		}
+		if len(expected) > 0 {
+			d.Message += ": expected " + strings.Join(expected, " or ")
+		}
+		pos := doc.position(f.Input.Offset())
+		d.Range = Range{Start: pos, End: pos}
+	case parser.UnconsumedInputError:
+		pos := doc.position(err.Residue().Offset())
+		d.Message += ": unexpected input"
+		d.Range = Range{Start: pos, End: pos}
+	default:
+		d.Message = err.Error()
+	}
+	return d
+}
+
+// importDiagnostic reports a failed import against the first import of the
+// document that can't be read.
+func (a *analysis) importDiagnostic(tree wbnf.GrammarNode, err error) Diagnostic {
+	d := Diagnostic{Severity: SeverityError, Source: "wbnf", Message: err.Error()}
+	for _, sym := range collectSymbols(tree, importResolver{a.dir}) {
+		if sym.kind == importPath {
+			if _, statErr := os.Stat(sym.path); statErr != nil {
+				d.Range = span(sym.s)
+				d.Message = fmt.Sprintf("cannot import %s: %v", sym.path, statErr)
+				break
+			}
+		}
+	}
+	return d
+}
+
+// validationDiagnostic converts a validation finding. Findings within imported
+// files aren't reported against the document.
+func (a *analysis) validationDiagnostic(err error) (Diagnostic, bool) {
+	d := Diagnostic{Severity: SeverityError, Source: "wbnf", Message: err.Error()}
+	switch severity(err) {
+	case wbnf.SeverityWarning:
+		d.Severity = SeverityWarning
+	case wbnf.SeverityInfo:
+		d.Severity = SeverityInformation
+	}
+	if v, ok := err.(interface{ Message() string }); ok {
+		// The range says where it is.
+		d.Message = v.Message()
+	}
+	if v, ok := err.(interface{ Scanner() parser.Scanner }); ok {
+		if s := v.Scanner(); !s.IsNil() {
+			if s.Filename() != "" {
+				return d, false
+			}
+			d.Range = span(s)
+		}
+	}
+	return d, true
+}
+
+// severity returns the severity of a validation finding. Only errors stop the
+// grammar from compiling.
+func severity(err error) wbnf.ValidationSeverity {
+	if v, ok := err.(interface {
+		Severity() wbnf.ValidationSeverity
+	}); ok {
+		return v.Severity()
+	}
+	return wbnf.SeverityError
+}
+
+// collectSymbols lists the names defined and referenced in a grammar.
+func collectSymbols(tree wbnf.GrammarNode, resolver importResolver) []symbol {
+	var symbols []symbol
+	var scopes []*parser.Scanner
+	var macroArgs []map[string]bool
+	add := func(kind symbolKind, ident *wbnf.IdentNode) {
+		if ident == nil || ident.Node == nil {
+			return
+		}
+		sym := symbol{kind: kind, name: ident.String(), s: ident.Scanner()}
+		if len(scopes) > 0 {
+			sym.scope = scopes[len(scopes)-1]
+		}
+		symbols = append(symbols, sym)
+	}
+	wbnf.WalkerOps{
+		EnterTermNode: func(node wbnf.TermNode) wbnf.Stopper {
+			if len(node.AllGrammar()) > 0 {
+				s := node.Scanner()
+				scopes = append(scopes, &s)
+			}
+			return nil
+		},
+		ExitTermNode: func(node wbnf.TermNode) wbnf.Stopper {
+			if len(node.AllGrammar()) > 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			return nil
+		},
+		EnterProdNode: func(node wbnf.ProdNode) wbnf.Stopper {
+			// Overrides and extensions refer to a rule defined elsewhere.
+			if op := node.OneOp(); op != nil && op.OneToken() != "->" {
+				add(ruleRef, node.OneIdent())
+			} else {
+				add(ruleDef, node.OneIdent())
+			}
+			return nil
+		},
+		EnterAtomNode: func(node wbnf.AtomNode) wbnf.Stopper {
+			if ident := node.OneIdent(); ident != nil && ident.String() != "@" {
+				if len(macroArgs) == 0 || !macroArgs[len(macroArgs)-1][ident.String()] {
+					add(ruleRef, ident)
+				}
+			}
+			return nil
+		},
+		EnterPragmaMacrodefNode: func(node wbnf.PragmaMacrodefNode) wbnf.Stopper {
+			add(macroDef, node.OneName())
+			args := map[string]bool{}
+			for _, arg := range node.AllArgs() {
+				args[arg.String()] = true
+			}
+			macroArgs = append(macroArgs, args)
+			return nil
+		},
+		ExitPragmaMacrodefNode: func(node wbnf.PragmaMacrodefNode) wbnf.Stopper {
+			macroArgs = macroArgs[:len(macroArgs)-1]
+			return nil
+		},
+		EnterMacrocallNode: func(node wbnf.MacrocallNode) wbnf.Stopper {
+			add(macroRef, node.OneName())
+			return nil
+		},
+		EnterPragmaImportNode: func(node wbnf.PragmaImportNode) wbnf.Stopper {
+			path := node.OnePath()
+			if path == nil || path.Node == nil {
+				return nil
+			}
+			from := ""
+			if s := path.Scanner(); s.Filename() != "" {
+				from = s.Filename()
+			}
+			symbols = append(symbols, symbol{
+				kind: importPath,
+				name: filepath.Join(path.AllToken()...),
+				s:    path.Scanner(),
+				path: resolver.Resolve(from, filepath.Join(path.AllToken()...)),
+			})
+			return nil
+		},
+	}.Walk(tree)
+	return symbols
+}
+
+// symbolAt returns the symbol of the document at the given position.
+func (a *analysis) symbolAt(pos Position) (symbol, bool) {
+	offset := a.doc.offset(pos)
+	for _, sym := range a.symbols {
+		if sym.s.Filename() == "" && sym.s.Offset() <= offset && offset <= sym.s.Offset()+len(sym.s.String()) {
+			return sym, true
+		}
+	}
+	return symbol{}, false
+}
+
+// definition returns the definition a symbol refers to. Rules defined in a
+// nested grammar shadow those defined further out.
+func (a *analysis) definition(sym symbol) (symbol, bool) {
+	var kind symbolKind
+	switch sym.kind {
+	case ruleDef, macroDef, importPath:
+		return sym, true
+	case ruleRef:
+		kind = ruleDef
+	case macroRef:
+		kind = macroDef
+	}
+	var best symbol
+	found := false
+	for _, def := range a.symbols {
+		if def.kind != kind || def.name != sym.name {
+			continue
+		}
+		if def.scope != nil && !def.scope.Contains(sym.s) {
+			continue
+		}
+		if !found || def.scope != nil && (best.scope == nil || best.scope.Contains(*def.scope)) {
+			best, found = def, true
+		}
+	}
+	return best, found
+}
+
+// references returns every symbol that refers to the same definition as sym.
+func (a *analysis) references(sym symbol, includeDeclaration bool) []symbol {
+	def, ok := a.definition(sym)
+	if !ok {
+		return nil
+	}
+	var refs []symbol
+	for _, other := range a.symbols {
+		switch other.kind {
+		case ruleRef, macroRef:
+			if d, ok := a.definition(other); ok && d.kind == def.kind && sameSymbol(d, def) {
+				refs = append(refs, other)
+			}
+		case def.kind:
+			if includeDeclaration && sameSymbol(other, def) {
+				refs = append(refs, other)
+			}
+		}
+	}
+	return refs
+}
+
+func sameSymbol(a, b symbol) bool {
+	return a.name == b.name && a.s.Filename() == b.s.Filename() && a.s.Offset() == b.s.Offset()
+}
+
+// hover describes the rule or macro a symbol refers to.
+func (a *analysis) hover(sym symbol) (string, bool) {
+	def, ok := a.definition(sym)
+	if !ok || def.kind == importPath {
+		return "", false
+	}
+	if def.kind == ruleDef && def.scope == nil && a.grammar != nil {
+		if term, has := a.grammar[parser.Rule(def.name)]; has {
+			return fmt.Sprintf("```wbnf\n%s -> %s;\n```", def.name, term), true
+		}
+	}
+	return fmt.Sprintf("`%s` defined at %s", def.name, a.describe(def.s)), true
+}
+
+func (a *analysis) describe(s parser.Scanner) string {
+	filename := s.Filename()
+	if filename == "" {
+		filename = filepath.Base(uriToPath(a.doc.uri))
+	}
+	line, col := s.Position()
+	return fmt.Sprintf("%s:%d:%d", filename, line, col)
+}
+
+// completions lists the rules and macros visible at a position.
+func (a *analysis) completions(pos Position) []CompletionItem {
+	offset := a.doc.offset(pos)
+	seen := map[string]bool{}
+	items := []CompletionItem{}
+	for _, def := range a.symbols {
+		var kind CompletionItemKind
+		switch def.kind {
+		case ruleDef:
+			kind = CompletionReference
+		case macroDef:
+			kind = CompletionFunction
+		default:
+			continue
+		}
+		if def.scope != nil && (def.scope.Filename() != "" ||
+			offset < def.scope.Offset() || offset > def.scope.Offset()+len(def.scope.String())) {
+			continue
+		}
+		if seen[def.name] {
+			continue
+		}
+		seen[def.name] = true
+		item := CompletionItem{Label: def.name, Kind: kind}
+		if term, has := a.grammar[parser.Rule(def.name)]; has && def.scope == nil {
+			item.Detail = term.String()
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// location converts the span of a scanner from the document or one of its
+// imports.
+func (a *analysis) location(s parser.Scanner) Location {
+	uri := a.doc.uri
+	if filename := s.Filename(); filename != "" {
+		uri = pathToURI(filename)
+	}
+	return Location{URI: uri, Range: span(s)}
+}
@@ -0,0 +1,73 @@
+package lsp
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/arr-ai/wbnf/parser"
+)
+
+// document is the text of a file, indexed by line so positions can be
+// converted between byte offsets and LSP positions, whose characters count
+// UTF-16 code units.
+type document struct {
+	uri   string
+	text  string
+	lines []int // the offset of the start of each line
+}
+
+func newDocument(uri, text string) *document {
+	lines := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &document{uri: uri, text: text, lines: lines}
+}
+
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.SearchInts(d.lines, offset+1) - 1
+	char := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		char += utf16Len(r)
+	}
+	return Position{Line: line, Character: char}
+}
+
+func (d *document) offset(pos Position) int {
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	for char := 0; char < pos.Character && offset < len(d.text); {
+		r, n := utf8.DecodeRuneInString(d.text[offset:])
+		if r == '\n' {
+			break
+		}
+		char += utf16Len(r)
+		offset += n
+	}
+	return offset
+}
+
+// span returns the range of a scanner over its source.
+func span(s parser.Scanner) Range {
+	n := len(s.String())
+	return Range{Start: scannerPosition(s), End: scannerPosition(*s.Slice(n, n))}
+}
+
+func scannerPosition(s parser.Scanner) Position {
+	line, col := s.UTF16Position()
+	return Position{Line: line - 1, Character: col - 1}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
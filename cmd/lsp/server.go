@@ -0,0 +1,204 @@
+// Package lsp implements a Language Server Protocol server for ωBNF grammars.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Server serves one client, holding the documents it has open.
+type Server struct {
+	w    io.Writer
+	docs map[string]*analysis
+}
+
+// Serve reads requests from r and writes responses and notifications to w until
+// the client sends exit or closes r.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &Server{w: w, docs: map[string]*analysis{}}
+	in := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(in)
+		if err != nil {
+			var rerr *responseError
+			if errors.As(err, &rerr) {
+				if err := s.reply(msg, nil, rerr); err != nil {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			// Notifications get no response.
+			continue
+		}
+		if err := s.reply(msg, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(req *message, result any, rerr *responseError) error {
+	resp := &message{ID: req.ID, Error: rerr}
+	if rerr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = data
+	}
+	if resp.ID == nil {
+		resp.ID = (*json.RawMessage)(&[]byte{'n', 'u', 'l', 'l'})
+	}
+	return writeMessage(s.w, resp)
+}
+
+func (s *Server) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.w, &message{Method: method, Params: data})
+}
+
+func (s *Server) handle(msg *message) (any, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]any{},
+			},
+			"serverInfo": map[string]any{"name": "wbnf"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if rerr := decode(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if rerr := decode(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if rerr := decode(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, []Diagnostic{})
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		a, sym, rerr := s.lookup(msg, &params)
+		if rerr != nil || a == nil {
+			return nil, rerr
+		}
+		if sym.kind == importPath {
+			return Location{URI: pathToURI(sym.path)}, nil
+		}
+		def, ok := a.definition(sym)
+		if !ok {
+			return nil, nil
+		}
+		return a.location(def.s), nil
+	case "textDocument/references":
+		var params ReferenceParams
+		a, sym, rerr := s.lookup(msg, &params)
+		if rerr != nil || a == nil {
+			return nil, rerr
+		}
+		locations := []Location{}
+		for _, ref := range a.references(sym, params.Context.IncludeDeclaration) {
+			locations = append(locations, a.location(ref.s))
+		}
+		return locations, nil
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		a, sym, rerr := s.lookup(msg, &params)
+		if rerr != nil || a == nil {
+			return nil, rerr
+		}
+		text, ok := a.hover(sym)
+		if !ok {
+			return nil, nil
+		}
+		r := span(sym.s)
+		return Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}, nil
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if rerr := decode(msg, &params); rerr != nil {
+			return nil, rerr
+		}
+		a, has := s.docs[params.TextDocument.URI]
+		if !has {
+			return []CompletionItem{}, nil
+		}
+		return a.completions(params.Position), nil
+	}
+	if msg.ID == nil {
+		// Unknown notifications, such as $/cancelRequest, may be ignored.
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// update analyses a document's new text and publishes its diagnostics.
+func (s *Server) update(uri, text string) *responseError {
+	a := analyse(newDocument(uri, text))
+	s.docs[uri] = a
+	return s.publish(uri, a.diagnostics)
+}
+
+func (s *Server) publish(uri string, diagnostics []Diagnostic) *responseError {
+	err := s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+	if err != nil {
+		return &responseError{Code: codeInternalError, Message: err.Error()}
+	}
+	return nil
+}
+
+// lookup decodes a request about a position and finds the symbol there. It
+// returns a nil analysis if there is none.
+func (s *Server) lookup(msg *message, params positioned) (*analysis, symbol, *responseError) {
+	if rerr := decode(msg, params); rerr != nil {
+		return nil, symbol{}, rerr
+	}
+	p := params.position()
+	a, has := s.docs[p.TextDocument.URI]
+	if !has {
+		return nil, symbol{}, nil
+	}
+	sym, ok := a.symbolAt(p.Position)
+	if !ok {
+		return nil, symbol{}, nil
+	}
+	return a, sym, nil
+}
+
+func decode(msg *message, params any) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
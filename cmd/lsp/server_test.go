@@ -0,0 +1,257 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// session runs the server over a sequence of messages and returns its
+// responses by id and its notifications in order.
+func session(t *testing.T, msgs ...message) (map[int]message, []message) {
+	t.Helper()
+	var in, out bytes.Buffer
+	for i := range msgs {
+		require.NoError(t, writeMessage(&in, &msgs[i]))
+	}
+	require.NoError(t, Serve(&in, &out))
+
+	responses := map[int]message{}
+	var notifications []message
+	r := bufio.NewReader(&out)
+	for r.Buffered() > 0 || out.Len() > 0 {
+		msg, err := readMessage(r)
+		require.NoError(t, err)
+		if msg.ID == nil {
+			notifications = append(notifications, *msg)
+			continue
+		}
+		var id int
+		require.NoError(t, json.Unmarshal(*msg.ID, &id))
+		responses[id] = *msg
+	}
+	return responses, notifications
+}
+
+func request(id int, method string, params any) message {
+	raw := json.RawMessage(mustMarshal(id))
+	return message{ID: &raw, Method: method, Params: mustMarshal(params)}
+}
+
+func notification(method string, params any) message {
+	return message{Method: method, Params: mustMarshal(params)}
+}
+
+func mustMarshal(v any) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func open(uri, text string) message {
+	return notification("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, Text: text},
+	})
+}
+
+func at(id int, method, uri string, line, char int) message {
+	return request(id, method, TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: char},
+	})
+}
+
+func decodeResult(t *testing.T, msg message, v any) {
+	t.Helper()
+	require.Nil(t, msg.Error)
+	require.NoError(t, json.Unmarshal(msg.Result, v))
+}
+
+func diagnostics(t *testing.T, notifications []message) []Diagnostic {
+	t.Helper()
+	require.NotEmpty(t, notifications)
+	var params PublishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(notifications[len(notifications)-1].Params, &params))
+	return params.Diagnostics
+}
+
+const uri = "file:///tmp/test.wbnf"
+
+func TestInitialize(t *testing.T) {
+	t.Parallel()
+	responses, _ := session(t,
+		request(1, "initialize", map[string]any{}),
+		request(2, "workspace/symbol", map[string]any{}),
+		request(3, "shutdown", nil),
+		notification("exit", nil),
+	)
+	var result struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	decodeResult(t, responses[1], &result)
+	assert.EqualValues(t, 1, result.Capabilities["textDocumentSync"])
+	assert.Equal(t, true, result.Capabilities["hoverProvider"])
+
+	require.NotNil(t, responses[2].Error)
+	assert.Equal(t, codeMethodNotFound, responses[2].Error.Code)
+	assert.Nil(t, responses[3].Error)
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+	for _, test := range []struct {
+		name, text string
+		message    string
+		start      Position
+	}{
+		{"unknown rule", "a -> b;", "'b' is not a defined rule", Position{0, 5}},
+		{"duplicate rule", "a -> 'x';\na -> 'y';", "defined multiple times, first at 1:1", Position{1, 0}},
+		{"invalid regex", "a -> /{[};", "is not valid", Position{0, 5}},
+		{"cycle", "a -> b;\nb -> a;", "cycle", Position{}},
+		{"syntax", "a -> 'x'\nb -> ;", "syntax error", Position{1, 2}},
+	} {
+		_, notifications := session(t, open(uri, test.text))
+		diags := diagnostics(t, notifications)
+		require.NotEmpty(t, diags, test.name)
+		assert.Contains(t, diags[0].Message, test.message, test.name)
+		assert.Equal(t, test.start, diags[0].Range.Start, test.name)
+	}
+
+	_, notifications := session(t, open(uri, "a -> 'x' b;\nb -> [0-9]+;"))
+	assert.Empty(t, diagnostics(t, notifications))
+}
+
+func TestDidChangeAndClose(t *testing.T) {
+	t.Parallel()
+	_, notifications := session(t,
+		open(uri, "a -> b;"),
+		notification("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []map[string]any{{"text": "a -> b; b -> 'x';"}},
+		}),
+		notification("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}),
+	)
+	require.Len(t, notifications, 3)
+	assert.NotEmpty(t, diagnostics(t, notifications[:1]))
+	assert.Empty(t, diagnostics(t, notifications[:2]))
+	assert.Empty(t, diagnostics(t, notifications))
+}
+
+const navText = `expr -> term:"+";
+term -> num | "(" expr ")";
+num  -> \d+;
+nested -> x { x -> "y" term; };
+x -> num;
+`
+
+func TestDefinitionAndReferences(t *testing.T) {
+	t.Parallel()
+	responses, _ := session(t,
+		open(uri, navText),
+		at(1, "textDocument/definition", uri, 1, 20), // expr in term
+		at(2, "textDocument/definition", uri, 3, 10), // x in nested
+		at(3, "textDocument/definition", uri, 0, 2),  // expr itself
+		at(4, "textDocument/definition", uri, 0, 6),  // the arrow
+		request(5, "textDocument/references", map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			"position":     Position{Line: 2, Character: 1}, // num
+			"context":      map[string]any{"includeDeclaration": true},
+		}),
+	)
+	var loc Location
+	decodeResult(t, responses[1], &loc)
+	assert.Equal(t, Location{URI: uri, Range: Range{Position{0, 0}, Position{0, 4}}}, loc)
+
+	// The nested grammar's x shadows the outer one.
+	decodeResult(t, responses[2], &loc)
+	assert.Equal(t, Range{Position{3, 14}, Position{3, 15}}, loc.Range)
+
+	decodeResult(t, responses[3], &loc)
+	assert.Equal(t, Range{Position{0, 0}, Position{0, 4}}, loc.Range)
+
+	assert.Equal(t, "null", string(responses[4].Result))
+
+	var locs []Location
+	decodeResult(t, responses[5], &locs)
+	var starts []Position
+	for _, l := range locs {
+		starts = append(starts, l.Range.Start)
+	}
+	assert.ElementsMatch(t, []Position{{1, 8}, {2, 0}, {4, 5}}, starts)
+}
+
+func TestImports(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib.wbnf"), []byte("digit -> [0-9];\n"), 0o600))
+	main := pathToURI(filepath.Join(dir, "main.wbnf"))
+	responses, notifications := session(t,
+		open(main, ".import lib.wbnf\nnum -> digit+;\n"),
+		at(1, "textDocument/definition", main, 1, 8),
+		at(2, "textDocument/definition", main, 0, 10),
+	)
+	assert.Empty(t, diagnostics(t, notifications))
+
+	var loc Location
+	decodeResult(t, responses[1], &loc)
+	assert.Equal(t, Location{
+		URI:   pathToURI(filepath.Join(dir, "lib.wbnf")),
+		Range: Range{Position{0, 0}, Position{0, 5}},
+	}, loc)
+
+	decodeResult(t, responses[2], &loc)
+	assert.Equal(t, pathToURI(filepath.Join(dir, "lib.wbnf")), loc.URI)
+
+	_, notifications = session(t, open(main, ".import missing.wbnf\nnum -> digit+;\n"))
+	diags := diagnostics(t, notifications)
+	require.Len(t, diags, 1)
+	assert.Contains(t, diags[0].Message, "cannot import")
+	assert.Equal(t, Range{Position{0, 8}, Position{0, 20}}, diags[0].Range)
+}
+
+func TestHoverAndCompletion(t *testing.T) {
+	t.Parallel()
+	responses, _ := session(t,
+		open(uri, navText),
+		at(1, "textDocument/hover", uri, 1, 9),
+		at(2, "textDocument/completion", uri, 0, 8),
+		at(3, "textDocument/completion", uri, 3, 25),
+	)
+	var hover Hover
+	decodeResult(t, responses[1], &hover)
+	assert.Equal(t, "markdown", hover.Contents.Kind)
+	assert.Contains(t, hover.Contents.Value, "num -> ")
+	assert.Equal(t, &Range{Position{1, 8}, Position{1, 11}}, hover.Range)
+
+	labels := func(msg message) []string {
+		var items []CompletionItem
+		decodeResult(t, msg, &items)
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+	assert.Equal(t, []string{"expr", "nested", "num", "term", "x"}, labels(responses[2]))
+	assert.Equal(t, []string{"expr", "nested", "num", "term", "x"}, labels(responses[3]))
+}
+
+func TestDocumentPositions(t *testing.T) {
+	t.Parallel()
+	doc := newDocument(uri, "a\n𝔸é -> x;\n")
+	assert.Equal(t, Position{1, 0}, doc.position(2))
+	assert.Equal(t, Position{1, 2}, doc.position(6))
+	assert.Equal(t, Position{1, 3}, doc.position(8))
+	assert.Equal(t, 8, doc.offset(Position{1, 3}))
+	assert.Equal(t, 6, doc.offset(Position{1, 2}))
+	assert.Equal(t, 14, doc.offset(Position{1, 100}))
+	assert.Equal(t, len(doc.text), doc.offset(Position{5, 0}))
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/arr-ai/wbnf/parser"
+	"github.com/arr-ai/wbnf/wbnf"
+)
+
+var pprofFile string
+var profileCommand = cli.Command{
+	Name:   "profile",
+	Usage:  "Report the cost of each rule of a grammar in parsing an input",
+	Action: profile,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:        "grammar",
+			Usage:       "input grammar file",
+			Required:    true,
+			TakesFile:   true,
+			Destination: &inGrammarFile,
+		},
+		cli.StringFlag{
+			Name:        "start",
+			Usage:       "starting rule to process the input text",
+			Required:    true,
+			Destination: &startingRule,
+		},
+		cli.StringFlag{
+			Name:        "input",
+			Usage:       "input test file",
+			Required:    true,
+			TakesFile:   true,
+			Destination: &inFile,
+		},
+		cli.StringFlag{
+			Name:        "pprof",
+			Usage:       "also write the profile to this file for go tool pprof",
+			TakesFile:   true,
+			Destination: &pprofFile,
+		},
+	},
+}
+
+func profile(c *cli.Context) error {
+	input, err := os.ReadFile(inFile)
+	if err != nil {
+		return err
+	}
+	text, err := os.ReadFile(inGrammarFile)
+	if err != nil {
+		return err
+	}
+	g, err := wbnf.Compile(string(text), makeResolver(inGrammarFile))
+	if err != nil {
+		return err
+	}
+	if !g.HasRule(parser.Rule(startingRule)) {
+		return fmt.Errorf("starting rule '%s' not in grammar", startingRule)
+	}
+
+	p := parser.NewProfile()
+	_, parseErr := g.Parse(parser.Rule(startingRule), parser.NewScannerWithFilename(string(input), inFile),
+		parser.WithTracer(p))
+	if err := p.Report(os.Stdout); err != nil {
+		return err
+	}
+	if pprofFile != "" {
+		f, err := os.Create(pprofFile)
+		if err != nil {
+			return err
+		}
+		if err := p.WritePprof(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return parseErr
+}
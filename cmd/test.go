@@ -20,6 +20,10 @@ var inGrammarFile string
 var startingRule string
 var verboseMode bool
 var printTree bool
+var traceParse bool
+var traceFormat string
+var printCutPoints bool
+var noOptimize bool
 var testCommand = cli.Command{
 	Name:    "test",
 	Aliases: []string{"t"},
@@ -65,9 +69,40 @@ var testCommand = cli.Command{
 			Hidden:      false,
 			Destination: &printTree,
 		},
+		cli.BoolFlag{
+			Name:        "trace",
+			Usage:       "write a trace of the parse to stderr",
+			Destination: &traceParse,
+		},
+		cli.StringFlag{
+			Name:        "trace-format",
+			Usage:       "format of the trace: text (indented) or json (one event per line)",
+			Value:       "text",
+			Destination: &traceFormat,
+		},
+		cli.BoolFlag{
+			Name:        "cutpoints",
+			Usage:       "write where cutpoints were placed in the grammar, and why, to stderr",
+			Destination: &printCutPoints,
+		},
+		cli.BoolFlag{
+			Name:        "no-optimize",
+			Usage:       "parse with the grammar's terms compiled verbatim, without optimization",
+			Destination: &noOptimize,
+		},
 	},
 }
 
+func newTracer(w io.Writer) (parser.Tracer, error) {
+	switch traceFormat {
+	case "text":
+		return parser.NewTextTracer(w), nil
+	case "json":
+		return parser.NewJSONTracer(w), nil
+	}
+	return nil, fmt.Errorf("unknown trace format '%s'", traceFormat)
+}
+
 type resolver struct {
 	base string
 }
@@ -91,7 +126,11 @@ func loadTestGrammar() parser.Parsers {
 	if startingRule == "" {
 		panic(fmt.Errorf("--start missing"))
 	}
-	return wbnf.MustCompile(string(text), makeResolver(inGrammarFile))
+	var opts []parser.CompileOption
+	if noOptimize {
+		opts = append(opts, parser.WithoutOptimization())
+	}
+	return wbnf.MustCompile(string(text), makeResolver(inGrammarFile), opts...)
 }
 
 func testWbnfFile(filename, grammar string) error {
@@ -141,7 +180,20 @@ func test(c *cli.Context) error {
 	if !g.HasRule(parser.Rule(startingRule)) {
 		return fmt.Errorf("starting rule '%s' not in test grammar", startingRule)
 	}
-	tree, err := g.Parse(parser.Rule(startingRule), parser.NewScannerWithFilename(input, source))
+	if printCutPoints {
+		for _, placement := range wbnf.DescribeCutPoints(g.Grammar()) {
+			fmt.Fprintln(os.Stderr, placement)
+		}
+	}
+	var opts []parser.ParseOption
+	if traceParse {
+		tracer, err := newTracer(os.Stderr)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, parser.WithTracer(tracer))
+	}
+	tree, err := g.Parse(parser.Rule(startingRule), parser.NewScannerWithFilename(input, source), opts...)
 	if err != nil {
 		if uci, ok := err.(parser.UnconsumedInputError); ok {
 			logrus.Warningln("Partial result:")
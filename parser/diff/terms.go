@@ -92,6 +92,12 @@ func Terms(a, b parser.Term) TermDiff {
 		return diffRules(a, b.(parser.Rule))
 	case parser.S:
 		return diffSes(a, b.(parser.S))
+	case parser.SI:
+		return diffSes(parser.S(a), parser.S(b.(parser.SI)))
+	case parser.Position:
+		return diffSes(parser.S(a.String()), parser.S(b.(parser.Position).String()))
+	case parser.Token:
+		return diffSes(parser.S(a.String()), parser.S(b.(parser.Token).String()))
 	case parser.RE:
 		return diffREs(a, b.(parser.RE))
 	case parser.Seq:
@@ -116,6 +122,12 @@ func Terms(a, b parser.Term) TermDiff {
 		return diffRefs(a, b.(parser.REF))
 	case parser.LookAhead:
 		return Terms(a.Term, b.(parser.LookAhead).Term)
+	case parser.NotLookAhead:
+		return Terms(a.Term, b.(parser.NotLookAhead).Term)
+	case parser.LookBehind:
+		return Terms(a.Term, b.(parser.LookBehind).Term)
+	case parser.NotLookBehind:
+		return Terms(a.Term, b.(parser.NotLookBehind).Term)
 	default:
 		panic(fmt.Errorf("unknown term type: %v %[1]T", a))
 	}
@@ -2,12 +2,16 @@ package parser
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/arr-ai/wbnf/gotree"
 )
 
 type ParseError struct {
 	rule      Rule
+	expected  Term
+	input     Scanner
 	msgFormat string
 	msgArgs   []any
 	children  []func() error
@@ -42,21 +46,26 @@ func isNotMyFatalError(err error, cp Cutpointdata) bool {
 
 func newParseError(
 	rule Rule,
+	input *Scanner,
 	format string,
 	args ...any,
 ) func(fatal Cutpointdata, errors ...func() error) error {
-	return func(fatal Cutpointdata, errors ...func() error) error {
-		err := ParseError{
-			rule:      rule,
-			msgFormat: format,
-			msgArgs:   args,
-			children:  errors,
-		}
-		if fatal.valid() {
-			return FatalError{err, fatal}
-		}
-		return err
+	return ParseError{
+		rule:      rule,
+		input:     *input,
+		msgFormat: format,
+		msgArgs:   args,
+	}.with
+}
+
+// with completes the error with its children, making it fatal if the cutpoint
+// is valid.
+func (p ParseError) with(fatal Cutpointdata, errors ...func() error) error {
+	p.children = errors
+	if fatal.valid() {
+		return FatalError{p, fatal}
 	}
+	return p
 }
 
 func (p ParseError) Error() string {
@@ -79,6 +88,133 @@ func (p ParseError) walkErrors(parent gotree.Tree) {
 	parent.AddTree(x)
 }
 
+// Rule returns the rule that failed to parse.
+func (p ParseError) Rule() Rule { return p.rule }
+
+// Message returns a description of the failure, excluding its causes.
+func (p ParseError) Message() string { return fmt.Sprintf(p.msgFormat, p.msgArgs...) }
+
+// Expected returns the terminal that failed to match, or nil if the failure
+// wasn't caused directly by a terminal.
+func (p ParseError) Expected() Term { return p.expected }
+
+// Input returns the remaining input at the point of failure.
+func (p ParseError) Input() Scanner { return p.input }
+
+// Offset returns the offset of the failure within the source.
+func (p ParseError) Offset() int { return p.input.Offset() }
+
+// Position returns the 1-indexed line and column of the failure.
+func (p ParseError) Position() (line, col int) {
+	if p.input.IsNil() {
+		return 1, 1
+	}
+	return p.input.Position()
+}
+
+// Children returns the errors that caused this one. The call stack, which is
+// reported via CallStack, is excluded.
+func (p ParseError) Children() []error {
+	var children []error
+	for _, errf := range p.children {
+		if err := errf(); err != nil {
+			if _, ok := err.(*call); !ok {
+				children = append(children, err)
+			}
+		}
+	}
+	return children
+}
+
+// Frame is an entry in the parser's call stack.
+type Frame struct {
+	Ident string
+	Term  Term
+}
+
+// CallStack returns the parser's call stack at the point of failure, innermost
+// first.
+func (p ParseError) CallStack() []Frame {
+	for _, errf := range p.children {
+		if stk, ok := errf().(*call); ok {
+			var frames []Frame
+			for ; stk != nil; stk = stk.next {
+				frames = append(frames, Frame{Ident: stk.ident, Term: stk.term})
+			}
+			return frames
+		}
+	}
+	return nil
+}
+
+// Failure summarises what the parser expected at some position.
+type Failure struct {
+	Input    Scanner
+	Expected []Term
+}
+
+// Furthest returns the failure which got furthest into the input, together
+// with every term that was expected there.
+func (p ParseError) Furthest() Failure {
+	var f Failure
+	seen := map[string]bool{}
+	var walk func(p ParseError)
+	walk = func(p ParseError) {
+		leaf := true
+		for _, child := range p.Children() {
+			switch child := child.(type) {
+			case ParseError:
+				leaf = false
+				walk(child)
+			case FatalError:
+				leaf = false
+				walk(child.ParseError)
+			}
+		}
+		if !leaf {
+			return
+		}
+		expected := p.expected
+		if expected == nil {
+			expected = p.rule
+		}
+		switch {
+		case f.Input.IsNil() || p.input.Offset() > f.Input.Offset():
+			f = Failure{Input: p.input}
+			seen = map[string]bool{}
+		case p.input.Offset() < f.Input.Offset():
+			return
+		}
+		if key := expected.String(); !seen[key] {
+			seen[key] = true
+			f.Expected = append(f.Expected, expected)
+		}
+	}
+	walk(p)
+	sort.Slice(f.Expected, func(i, j int) bool { return f.Expected[i].String() < f.Expected[j].String() })
+	return f
+}
+
+func (f Failure) String() string {
+	line, col := 1, 1
+	if !f.Input.IsNil() {
+		line, col = f.Input.Position()
+	}
+	expected := make([]string, 0, len(f.Expected))
+	for _, t := range f.Expected {
+		expected = append(expected, t.String())
+	}
+	prefix := "expected"
+	if len(expected) > 1 {
+		prefix = "expected one of"
+	}
+	at := fmt.Sprintf("%d:%d", line, col)
+	if filename := f.Input.Filename(); filename != "" {
+		at = filename + ":" + at
+	}
+	return fmt.Sprintf("%s: %s %s", at, prefix, strings.Join(expected, ", "))
+}
+
 type UnconsumedInputError struct {
 	residue Scanner
 	tree    TreeElement
@@ -96,3 +232,30 @@ func (e UnconsumedInputError) Error() string {
 
 func (e UnconsumedInputError) Result() TreeElement { return e.tree }
 func (e UnconsumedInputError) Residue() *Scanner   { return &e.residue }
+
+// RecoveryError is returned by a parse that recovered from errors. See
+// WithRecovery.
+type RecoveryError struct {
+	errs []error
+	tree TreeElement
+}
+
+func (e RecoveryError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "recovered from %d error(s)", len(e.errs))
+	for _, err := range e.errs {
+		sb.WriteString("\n")
+		if pe, ok := err.(interface{ Furthest() Failure }); ok {
+			sb.WriteString(pe.Furthest().String())
+		} else {
+			sb.WriteString(err.Error())
+		}
+	}
+	return sb.String()
+}
+
+// Errors returns the errors recovered from, in input order.
+func (e RecoveryError) Errors() []error { return e.errs }
+
+// Result returns the parse tree, with an ErrorNode in place of each error.
+func (e RecoveryError) Result() TreeElement { return e.tree }
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrorAccessors(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a":       Seq{S("x"), Rule("b")},
+		"b":       Oneof{S("y"), RE(`\d+`)},
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+
+	_, err := p.Parse("a", NewScannerWithFilename("x\n  z", "test.txt"))
+	require.IsType(t, ParseError{}, err)
+	pe := err.(ParseError)
+
+	assert.Equal(t, Rule("a"), pe.Rule())
+	assert.Equal(t, "could not complete sequence", pe.Message())
+	assert.Nil(t, pe.Expected())
+	assert.Equal(t, 4, pe.Offset())
+	line, col := pe.Position()
+	assert.Equal(t, [2]int{2, 3}, [2]int{line, col})
+
+	children := pe.Children()
+	require.Len(t, children, 1)
+	require.IsType(t, ParseError{}, children[0])
+	oneof := children[0].(ParseError)
+	assert.Equal(t, Rule("b"), oneof.Rule())
+
+	stk := oneof.CallStack()
+	require.NotEmpty(t, stk)
+	assert.Equal(t, "b", stk[0].Ident)
+
+	leaves := oneof.Children()
+	require.Len(t, leaves, 2)
+	assert.Equal(t, S("y"), leaves[0].(ParseError).Expected())
+	assert.Equal(t, RE(`\d+`), leaves[1].(ParseError).Expected())
+}
+
+func TestParseErrorFurthest(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a": Oneof{
+			Seq{S("x"), S("y"), S("z")},
+			Seq{S("x"), S("y"), RE(`\d`)},
+			Seq{S("x"), S("w")},
+		},
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+
+	_, err := p.Parse("a", NewScannerWithFilename("x\nyq", "test.txt"))
+	require.Error(t, err)
+	f := err.(ParseError).Furthest()
+	assert.Equal(t, 3, f.Input.Offset())
+	assert.Equal(t, []Term{S("z"), RE(`\d`)}, f.Expected)
+	assert.Equal(t, `test.txt:2:2: expected one of "z", /\d/`, f.String())
+}
+
+func TestParseErrorFurthestFatal(t *testing.T) {
+	t.Parallel()
+	p := Grammar{"a": Seq{CutPoint{S("x")}, S("y")}}.Compile(nil)
+
+	_, err := p.Parse("a", NewScanner("xz"))
+	require.IsType(t, FatalError{}, err)
+	assert.Equal(t, `1:2: expected "y"`, err.(FatalError).Furthest().String())
+
+	p = Grammar{"a": Seq{CutPoint{Rule("b")}, S("y")}, "b": S("x")}.Compile(nil)
+	_, err = p.Parse("a", NewScanner("xz"))
+	require.IsType(t, FatalError{}, err)
+	assert.Equal(t, `1:2: expected "y"`, err.(FatalError).Furthest().String())
+}
+
+func TestQuantErrorChildren(t *testing.T) {
+	t.Parallel()
+	p := Grammar{"a": Some(S("x"))}.Compile(nil)
+
+	_, err := p.Parse("a", NewScanner("y"))
+	require.IsType(t, ParseError{}, err)
+	pe := err.(ParseError)
+
+	// The quant's error must not list itself as its own child.
+	children := pe.Children()
+	require.Len(t, children, 1)
+	assert.NotContains(t, children[0].Error(), "quant failed")
+	assert.Equal(t, 0, pe.Furthest().Input.Offset())
+}
@@ -0,0 +1,379 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+// Edit describes a change to a source: the Length bytes at Offset are replaced
+// with Text.
+type Edit struct {
+	Offset int
+	Length int
+	Text   string
+}
+
+// Reparse parses the text that results from applying edits to the source of
+// old, which prev was parsed from. The edits are applied in order, each to the
+// text produced by the ones before it. The returned tree is the same as a full
+// Parse of the new text would produce, and the returned scanner covers the new
+// text so it can be passed as old to the next Reparse.
+//
+// Rule results that were parsed from old by an earlier Reparse and only looked
+// at input the edits left intact are reused instead of being parsed again. So
+// the first Reparse of a scanner from NewScanner parses everything; subsequent
+// ones only parse what the edits affected. Reparse ignores WithMemo, and no
+// results are reused or recorded under WithRecovery.
+func (p Parsers) Reparse(
+	rule Rule, prev TreeElement, old *Scanner, edits []Edit, opts ...ParseOption,
+) (TreeElement, *Scanner, error) {
+	text, pieces, err := applyEdits(old.src.slice(0, old.src.length()), edits)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := &editSource{
+		stringSource: newStringSource(text, old.Filename()),
+		results:      map[resultKey]result{},
+	}
+	opts = append(opts, func(st *parseState) { st.memo = nil })
+	if o, ok := old.src.(*editSource); ok && prev != nil {
+		src.old, src.pieces, src.shifted = o, pieces, map[childrenKey][]TreeElement{}
+		defer func() {
+			src.carry()
+			src.old, src.pieces, src.shifted = nil, nil, nil
+		}()
+	}
+
+	e, err := p.Parse(rule, &Scanner{src: src, sliceLength: len(text)}, opts...)
+	return e, &Scanner{src: src, sliceLength: len(text)}, err
+}
+
+// piece is a span of text after edits, which was either copied from the text
+// before the edits or inserted by them.
+type piece struct {
+	start, end int // the span of the piece in the new text
+	from       int // the offset in the old text the piece was copied from, or -1
+}
+
+func (p piece) slice(start, end int) piece {
+	q := piece{start: start, end: end, from: -1}
+	if p.from >= 0 {
+		q.from = p.from + start - p.start
+	}
+	return q
+}
+
+// applyEdits applies edits to text, also returning the pieces describing where
+// each part of the result came from.
+func applyEdits(text string, edits []Edit) (string, []piece, error) {
+	pieces := []piece{{start: 0, end: len(text), from: 0}}
+	for _, e := range edits {
+		end := e.Offset + e.Length
+		if e.Offset < 0 || e.Length < 0 || end > len(text) {
+			return "", nil, fmt.Errorf("edit [%d:%d] out of range [0:%d]", e.Offset, end, len(text))
+		}
+		shift := len(e.Text) - e.Length
+		next := make([]piece, 0, len(pieces)+2)
+		for _, p := range pieces {
+			if p.start < e.Offset {
+				end := p.end
+				if end > e.Offset {
+					end = e.Offset
+				}
+				next = append(next, p.slice(p.start, end))
+			}
+		}
+		if e.Text != "" {
+			next = append(next, piece{start: e.Offset, end: e.Offset + len(e.Text), from: -1})
+		}
+		for _, p := range pieces {
+			if p.end > end {
+				start := p.start
+				if start < end {
+					start = end
+				}
+				q := p.slice(start, p.end)
+				q.start += shift
+				q.end += shift
+				next = append(next, q)
+			}
+		}
+		pieces = next
+		text = text[:e.Offset] + e.Text + text[end:]
+	}
+	return text, pieces, nil
+}
+
+type resultKey struct {
+	p      *entryParser
+	offset int
+	cut    bool
+}
+
+// result is the successful outcome of a rule, along with reach, the offset just
+// past the furthest input the rule looked at, and back, the offset of the
+// earliest, which lookbehinds put before where the rule started. Any edit
+// before back or at or after reach can't have changed the outcome.
+type result struct {
+	output TreeElement
+	end    int
+	reach  int
+	back   int
+	ends   tokenEnds
+}
+
+// editSource is the source of scanners returned by Reparse. It records how far
+// ahead and behind each rule looks as it is parsed, and the result of each rule
+// that only depended on the input.
+type editSource struct {
+	stringSource
+	reach   int
+	back    int
+	results map[resultKey]result
+
+	// While reparsing, the source the text was derived from.
+	old     *editSource
+	pieces  []piece
+	shifted map[childrenKey][]TreeElement
+	reused  int // how many results were carried over from old
+}
+
+func (s *editSource) slice(i, length int) string {
+	if end := i + length; end > s.reach {
+		s.reach = end
+	}
+	s.lookBack(i)
+	return s.stringSource.slice(i, length)
+}
+
+func (s *editSource) runeReader(i, length int) io.RuneReader {
+	s.lookBack(i)
+	return &editSourceRunes{s, i, i + length}
+}
+
+// lookBack notes that the rule being parsed looked at the input from offset i.
+func (s *editSource) lookBack(i int) {
+	if i < s.back {
+		s.back = i
+	}
+}
+
+// lookBack notes that the rule being parsed looked at src from offset i, if src
+// is being reparsed.
+func lookBack(src source, i int) {
+	if s, ok := src.(*editSource); ok {
+		s.lookBack(i)
+	}
+}
+
+// reachOf calls read, which reads src from offset i, and returns how far ahead
+// it looked if src is being reparsed.
+func reachOf(src source, i int, read func()) int {
+	s, ok := src.(*editSource)
+	if !ok {
+		read()
+		return 0
+	}
+	reach := s.reach
+	s.reach = i
+	read()
+	readTo := s.reach
+	if reach > s.reach {
+		s.reach = reach
+	}
+	return readTo
+}
+
+// reread notes that src was looked at from offset i up to reach, as it was for
+// an outcome that is reused, if src is being reparsed.
+func reread(src source, i, reach int) {
+	if s, ok := src.(*editSource); ok {
+		s.lookBack(i)
+		if reach > s.reach {
+			s.reach = reach
+		}
+	}
+}
+
+type editSourceRunes struct {
+	src      *editSource
+	off, end int
+}
+
+func (r *editSourceRunes) ReadRune() (rune, int, error) {
+	// Hitting the end of the input counts as looking at what follows it.
+	if r.off >= r.src.reach {
+		r.src.reach = r.off + 1
+	}
+	if r.off >= r.end {
+		return 0, 0, io.EOF
+	}
+	c, n := utf8.DecodeRuneInString(r.src.origin[r.off:r.end])
+	r.off += n
+	if r.off > r.src.reach {
+		r.src.reach = r.off
+	}
+	return c, n, nil
+}
+
+func (s *editSource) parse(
+	p *entryParser, st *parseState, scope Scope, input *Scanner, output *TreeElement, stk *call,
+) error {
+	offset := input.sliceStart
+	if offset+input.sliceLength != len(s.origin) ||
+		p.leftRec && st.seeds[seedKey{p: p, offset: offset, length: input.sliceLength}] != nil {
+		return p.parse(st, scope, input, output, stk)
+	}
+
+	key := resultKey{p: p, offset: offset, cut: scope.GetCutPoint().valid()}
+	r, has := s.results[key]
+	if !has {
+		if r, has = s.reuse(key); has {
+			s.results[key] = r
+			s.reused++
+		}
+	}
+	if has {
+		if r.reach > s.reach {
+			s.reach = r.reach
+		}
+		s.lookBack(r.back)
+		*output = r.output
+		*input = *input.Skip(r.end - offset)
+		st.restoreTokenEnds(r.ends)
+		return nil
+	}
+
+	reach, back, volatile := s.reach, s.back, st.volatile
+	before := st.tokenEnds()
+	s.reach, s.back = offset, offset
+	err := p.parse(st, scope, input, output, stk)
+	if err == nil && st.volatile == volatile {
+		s.results[key] = result{
+			output: *output, end: input.sliceStart, reach: s.reach, back: s.back, ends: st.tokenEndsSince(before),
+		}
+	}
+	if reach > s.reach {
+		s.reach = reach
+	}
+	s.lookBack(back)
+	return err
+}
+
+// reuse looks for a result from the old source that still holds at key.
+func (s *editSource) reuse(key resultKey) (result, bool) {
+	i := sort.Search(len(s.pieces), func(i int) bool { return s.pieces[i].end > key.offset })
+	if i == len(s.pieces) || s.pieces[i].from < 0 {
+		return result{}, false
+	}
+	from := s.pieces[i].from + key.offset - s.pieces[i].start
+	r, has := s.old.results[resultKey{p: key.p, offset: from, cut: key.cut}]
+	if !has || !s.intact(i, r) {
+		return result{}, false
+	}
+	delta := key.offset - from
+	ends := r.ends
+	ends.tokenEnd += delta
+	ends.matchEnd += delta
+	return result{
+		output: s.shift(r.output, delta),
+		end:    r.end + delta,
+		reach:  r.reach + delta,
+		back:   r.back + delta,
+		ends:   ends,
+	}, true
+}
+
+// intact reports whether the input that old result r looked at lies entirely
+// within the piece at index i.
+func (s *editSource) intact(i int, r result) bool {
+	pc := s.pieces[i]
+	limit := pc.from + pc.end - pc.start
+	if i == len(s.pieces)-1 && limit == len(s.old.origin) {
+		// The end of the input is still the end of the input.
+		limit++
+	}
+	start := pc.from
+	if start == 0 && pc.start > 0 {
+		// Nor is the start of the input.
+		start++
+	}
+	return start <= r.back && r.reach <= limit
+}
+
+// carry copies the results of the old source that still hold over to this one.
+// A result is only carried if its output has been shifted already, as part of
+// a reused result that contained it, so nothing is shifted twice and the new
+// results don't hold on to the old source.
+func (s *editSource) carry() {
+	copied := make([]int, 0, len(s.pieces))
+	for i, pc := range s.pieces {
+		if pc.from >= 0 {
+			copied = append(copied, i)
+		}
+	}
+	for key, r := range s.old.results {
+		j := sort.Search(len(copied), func(j int) bool {
+			pc := s.pieces[copied[j]]
+			return pc.from+pc.end-pc.start > key.offset
+		})
+		if j == len(copied) || s.pieces[copied[j]].from > key.offset || !s.intact(copied[j], r) {
+			continue
+		}
+		pc := s.pieces[copied[j]]
+		delta := pc.start - pc.from
+		key.offset += delta
+		if _, has := s.results[key]; has {
+			continue
+		}
+		if n, ok := r.output.(Node); ok && len(n.Children) > 0 {
+			children, has := s.shifted[childrenKey{&n.Children[0], len(n.Children)}]
+			if !has {
+				continue
+			}
+			n.Children = children
+			r.output = n
+		} else {
+			r.output = s.shift(r.output, delta)
+		}
+		r.end += delta
+		r.reach += delta
+		r.back += delta
+		s.results[key] = r
+	}
+}
+
+// childrenKey identifies the children of a node by their backing array.
+type childrenKey struct {
+	first *TreeElement
+	n     int
+}
+
+// shift moves a tree from the old source to this one. Subtrees shared by old
+// results are only shifted once.
+func (s *editSource) shift(e TreeElement, delta int) TreeElement {
+	switch e := e.(type) {
+	case Scanner:
+		e.src = s
+		e.sliceStart += delta
+		return e
+	case Node:
+		if len(e.Children) == 0 {
+			return e
+		}
+		key := childrenKey{&e.Children[0], len(e.Children)}
+		children, has := s.shifted[key]
+		if !has {
+			children = make([]TreeElement, len(e.Children))
+			for i, child := range e.Children {
+				children[i] = s.shift(child, delta)
+			}
+			s.shifted[key] = children
+		}
+		e.Children = children
+		return e
+	}
+	return e
+}
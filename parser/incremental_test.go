@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var letGrammar = Grammar{
+	"stmts":   Any(Rule("stmt")),
+	"stmt":    Seq{S("let"), Rule("name"), S("="), Rule("expr"), S(";")},
+	"name":    RE(`[a-z]+`),
+	"expr":    Delim{Term: Rule("value"), Sep: S("+")},
+	"value":   Oneof{RE(`\d+`), Rule("name")},
+	".wrapRE": RE(`\s*()\s*`),
+}.Compile(nil)
+
+// leaves lists the offset and text of every leaf of a tree.
+func leaves(e TreeElement, out []string) []string {
+	switch e := e.(type) {
+	case Scanner:
+		out = append(out, fmt.Sprintf("%d:%s", e.Offset(), e))
+	case Node:
+		for _, child := range e.Children {
+			out = leaves(child, out)
+		}
+	}
+	return out
+}
+
+func assertReparse(t *testing.T, prev TreeElement, old *Scanner, edits ...Edit) (TreeElement, *Scanner) {
+	t.Helper()
+	e, s, err := letGrammar.Reparse("stmts", prev, old, edits)
+	expected, expectedErr := letGrammar.Parse("stmts", NewScanner(s.String()))
+	if expectedErr != nil {
+		assert.EqualError(t, err, expectedErr.Error())
+		return nil, s
+	}
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e))
+	assert.Equal(t, leaves(expected, nil), leaves(e, nil))
+	return e, s
+}
+
+func TestReparse(t *testing.T) {
+	t.Parallel()
+	e, s := assertReparse(t, nil, NewScanner("let a = 1;\nlet b = 2 + a;\nlet c = 4;\n"))
+	assert.Zero(t, s.src.(*editSource).reused)
+
+	for _, test := range []struct {
+		name  string
+		edits []Edit
+		text  string
+	}{
+		{"no edits", nil, "let a = 1;\nlet b = 2 + a;\nlet c = 4;\n"},
+		{"replace", []Edit{{Offset: 19, Length: 1, Text: "22"}}, "let a = 1;\nlet b = 22 + a;\nlet c = 4;\n"},
+		{"insert", []Edit{{Offset: 11, Text: "let x = 5;\n"}}, "let a = 1;\nlet x = 5;\nlet b = 22 + a;\nlet c = 4;\n"},
+		{"delete", []Edit{{Offset: 11, Length: 11}}, "let a = 1;\nlet b = 22 + a;\nlet c = 4;\n"},
+		{"append", []Edit{{Offset: 38, Text: "let d = c;"}}, "let a = 1;\nlet b = 22 + a;\nlet c = 4;\nlet d = c;"},
+		{"extend token", []Edit{{Offset: 4, Text: "b"}}, "let ba = 1;\nlet b = 22 + a;\nlet c = 4;\nlet d = c;"},
+		{"sequential", []Edit{{Offset: 0, Length: 12}, {Offset: 0, Text: "let e=3;"}},
+			"let e=3;let b = 22 + a;\nlet c = 4;\nlet d = c;"},
+	} {
+		e, s = assertReparse(t, e, s, test.edits...)
+		assert.Equal(t, test.text, s.String(), test.name)
+		assert.NotZero(t, s.src.(*editSource).reused, test.name)
+	}
+}
+
+func TestReparseError(t *testing.T) {
+	t.Parallel()
+	e, s := assertReparse(t, nil, NewScanner("let a = 1; let b = 2;"))
+	_, s = assertReparse(t, e, s, Edit{Offset: 9, Length: 1})
+	e, s = assertReparse(t, nil, s, Edit{Offset: 9, Text: ";"})
+	require.NotNil(t, e)
+
+	_, _, err := letGrammar.Reparse("stmts", e, s, []Edit{{Offset: 20, Length: 2}})
+	assert.EqualError(t, err, "edit [20:22] out of range [0:21]")
+}
+
+func TestReparseLookBehind(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"s": Seq{RE(`[-+]`), Rule("x")},
+		"x": Oneof{Seq{LookBehind{S("-")}, RE(`[a-z]`)}, Seq{RE(`[a-z]`), S("")}},
+	}.Compile(nil)
+
+	e, s, err := p.Reparse("s", nil, NewScanner("-a"), nil)
+	require.NoError(t, err)
+
+	// x looked behind where it started, at the text the edit replaces.
+	e, s, err = p.Reparse("s", e, s, []Edit{{Offset: 0, Length: 1, Text: "+"}})
+	require.NoError(t, err)
+	expected, err := p.Parse("s", NewScanner(s.String()))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e))
+}
+
+func TestReparseReusedLookBehind(t *testing.T) {
+	t.Parallel()
+	expected, err := reusedLookBehindGrammar.Parse("s", NewScanner("x ?"))
+	require.NoError(t, err)
+	e, s, err := reusedLookBehindGrammar.Reparse("s", nil, NewScanner("x ?"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e))
+
+	e, _, err = reusedLookBehindGrammar.Reparse("s", e, s, []Edit{{Offset: 1, Length: 1, Text: "  "}})
+	require.NoError(t, err)
+	expected, err = reusedLookBehindGrammar.Parse("s", NewScanner("x  ?"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e))
+}
+
+func TestReparseReusedToken(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"s": Oneof{Rule("a"), Rule("b")},
+		"a": Seq{Token{Rule: "t", Label: "x"}, S("!")},
+		"b": Token{Rule: "t", Label: "x"},
+		"t": Oneof{Eq("x", RE(`\w+`)), Eq("y", S("zz"))},
+	}.Compile(nil)
+
+	// b reuses the token that a matched, which looked ahead to the end.
+	e, s, err := p.Reparse("s", nil, NewScanner("ab"), nil)
+	require.NoError(t, err)
+	e, s, err = p.Reparse("s", e, s, nil)
+	require.NoError(t, err)
+	e, s, err = p.Reparse("s", e, s, []Edit{{Offset: 2, Text: "c"}})
+	require.NoError(t, err)
+	expected, err := p.Parse("s", NewScanner(s.String()))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e))
+}
+
+func TestReparsePositions(t *testing.T) {
+	t.Parallel()
+	for _, test := range []struct {
+		name string
+		prop PositionProp
+		text string
+		edit Edit
+	}{
+		{"col after deletion", PosCol, "a\n  b", Edit{Offset: 2, Length: 1}},
+		{"col after insertion", PosCol, "a\n  b", Edit{Offset: 2, Text: " "}},
+		{"line", PosLine, "a\n  b", Edit{Offset: 0, Text: "\n"}},
+		{"offset", PosOffset, "a\nb", Edit{Offset: 0, Text: "\n"}},
+	} {
+		// y looks behind where it starts, at the text the edit changes.
+		p := Grammar{
+			"s": Seq{RE(`\n?[a-z]?\n *`), Rule("y")},
+			"y": Seq{Position{Prop: test.prop, Op: "=", Value: 2}, RE(`\w?`)},
+		}.Compile(nil)
+		e, s, err := p.Reparse("s", nil, NewScanner(test.text), nil)
+		require.NoError(t, err, test.name)
+		e, s, err = p.Reparse("s", e, s, []Edit{test.edit})
+		expected, expectedErr := p.Parse("s", NewScanner(s.String()))
+		if assert.Equal(t, expectedErr == nil, err == nil, test.name) && err == nil {
+			assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(e), test.name)
+		}
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	t.Parallel()
+	text, pieces, err := applyEdits("0123456789", []Edit{
+		{Offset: 2, Length: 3, Text: "ab"},
+		{Offset: 7, Text: "cd"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "01ab567cd89", text)
+	assert.Equal(t, []piece{
+		{start: 0, end: 2, from: 0},
+		{start: 2, end: 4, from: -1},
+		{start: 4, end: 7, from: 5},
+		{start: 7, end: 9, from: -1},
+		{start: 9, end: 11, from: 8},
+	}, pieces)
+}
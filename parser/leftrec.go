@@ -0,0 +1,247 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// leftRecursion finds the rules of g that can invoke themselves without first
+// consuming any input, directly or through other rules. Such rules are parsed
+// by growing a seed rather than by plain recursion, which would never
+// terminate. Rules not defined in g are looked up in outer.
+func leftRecursion(g, outer Grammar) map[Rule]bool {
+	invoked := false
+	for rule := range g {
+		// Magic rules, such as .wrapRE, configure the parser rather than
+		// being invoked.
+		invoked = invoked || !strings.HasPrefix(string(rule), ".")
+	}
+	if !invoked {
+		return nil
+	}
+
+	lookup := func(rule Rule) Term {
+		if t, has := g[rule]; has {
+			return t
+		}
+		return outer[rule]
+	}
+
+	nullable := nullableRules(outer, g)
+	result := map[Rule]bool{}
+	for rule, term := range g {
+		seen := map[Rule]bool{}
+		pending := leftRules(term, nullable)
+		for len(pending) > 0 {
+			next := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			if next == rule {
+				result[rule] = true
+				break
+			}
+			if !seen[next] {
+				seen[next] = true
+				if t := lookup(next); t != nil {
+					pending = append(pending, leftRules(t, nullable)...)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// NullableRules returns the rules of g that can succeed without consuming
+// input.
+func NullableRules(g Grammar) map[Rule]bool {
+	return nullableRules(g)
+}
+
+// nullableRules returns the rules of the grammars that can succeed without
+// consuming input. Start by assuming none can and iterate until nothing changes.
+func nullableRules(grammars ...Grammar) map[Rule]bool {
+	nullable := map[Rule]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, grammar := range grammars {
+			for rule, term := range grammar {
+				if !nullable[rule] && IsNullable(term, nullable) {
+					nullable[rule] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return nullable
+}
+
+// IsNullable reports whether t can succeed without consuming input, given the
+// rules that can, as returned by NullableRules. Terms that depend on runtime
+// context, such as back-references, are assumed to be nullable.
+func IsNullable(t Term, nullable map[Rule]bool) bool {
+	switch t := t.(type) {
+	case S:
+		return t == ""
+	case SI:
+		return t == ""
+	case RE:
+		re, err := regexp.Compile(`\A(?:` + string(t) + `)`)
+		return err == nil && re.MatchString("")
+	case Rule:
+		return nullable[t]
+	case Seq:
+		for _, term := range t {
+			if !IsNullable(term, nullable) {
+				return false
+			}
+		}
+		return true
+	case Oneof:
+		for _, term := range t {
+			if IsNullable(term, nullable) {
+				return true
+			}
+		}
+		return false
+	case Stack:
+		for _, term := range t {
+			if IsNullable(term, nullable) {
+				return true
+			}
+		}
+		return false
+	case Delim:
+		return IsNullable(t.Term, nullable)
+	case Quant:
+		return t.Min == 0 || IsNullable(t.Term, nullable)
+	case Named:
+		return IsNullable(t.Term, nullable)
+	case CutPoint:
+		return IsNullable(t.Term, nullable)
+	case ScopedGrammar:
+		return IsNullable(t.Term, nullable)
+	case LookAhead, NotLookAhead, LookBehind, NotLookBehind, Position, REF, ExtRef:
+		return true
+	}
+	return false
+}
+
+// leftRules returns the rules t may invoke at the position t starts at.
+func leftRules(t Term, nullable map[Rule]bool) []Rule {
+	switch t := t.(type) {
+	case Rule:
+		return []Rule{t}
+	case Seq:
+		var rules []Rule
+		for _, term := range t {
+			rules = append(rules, leftRules(term, nullable)...)
+			if !IsNullable(term, nullable) {
+				break
+			}
+		}
+		return rules
+	case Oneof:
+		var rules []Rule
+		for _, term := range t {
+			rules = append(rules, leftRules(term, nullable)...)
+		}
+		return rules
+	case Delim:
+		rules := leftRules(t.Term, nullable)
+		if t.CanStartWithSep {
+			rules = append(rules, leftRules(t.Sep, nullable)...)
+		}
+		return rules
+	case Quant:
+		return leftRules(t.Term, nullable)
+	case Named:
+		return leftRules(t.Term, nullable)
+	case CutPoint:
+		return leftRules(t.Term, nullable)
+	case LookAhead:
+		return leftRules(t.Term, nullable)
+	case NotLookAhead:
+		return leftRules(t.Term, nullable)
+	case ScopedGrammar:
+		return leftRules(t.Term, nullable)
+	}
+	return nil
+}
+
+type seedKey struct {
+	p      *entryParser
+	offset int
+	length int
+}
+
+// seed is the best outcome found so far for a left-recursive rule at a given
+// position. Recursive invocations at that position are answered with it.
+type seed struct {
+	output TreeElement
+	input  Scanner
+	err    error
+	hits   int
+	ends   tokenEnds
+}
+
+// growSeed parses a left-recursive rule. The first attempt is made with the
+// recursive invocations failing, which parses the non-recursive alternatives.
+// Each subsequent attempt lets the recursive invocations return the previous
+// result, and the seed grows for as long as the attempts consume more input.
+func (st *parseState) growSeed(p *entryParser, scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	key := seedKey{p: p, offset: input.sliceStart, length: input.sliceLength}
+	if s, has := st.seeds[key]; has {
+		s.hits++
+		st.volatile++
+		*input = s.input
+		*output = s.output
+		st.restoreTokenEnds(s.ends)
+		return s.err
+	}
+
+	if st.seeds == nil {
+		st.seeds = map[seedKey]*seed{}
+	}
+	s := &seed{
+		input: *input,
+		err: newParseError(p.rule, input, "left recursion without a base case")(invalidCutpoint,
+			func() error { return stk },
+		),
+	}
+	st.seeds[key] = s
+	defer delete(st.seeds, key)
+
+	volatile := st.volatile
+	before := st.tokenEnds()
+	start := *input
+	start.hold()
+	defer start.unhold()
+	for grown := false; ; grown = true {
+		hits := s.hits
+		in := start
+		var out TreeElement
+		if err := p.p.Parse(scope, &in, &out, stk); err != nil {
+			if !grown || isFatal(err) {
+				*input = in
+				return err
+			}
+			break
+		}
+		if grown && in.sliceStart <= s.input.sliceStart {
+			break
+		}
+		s.output, s.input, s.err, s.ends = out, in, nil, st.tokenEndsSince(before)
+		if s.hits == hits {
+			// The rule didn't recurse at this position, so it can't grow.
+			break
+		}
+	}
+
+	// Consulting our own seed doesn't make the final outcome context-dependent.
+	if st.volatile-volatile == s.hits {
+		st.volatile = volatile
+	}
+	*input = s.input
+	*output = s.output
+	st.restoreTokenEnds(s.ends)
+	return nil
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeftRecursion(t *testing.T) {
+	t.Parallel()
+	for _, test := range []struct {
+		name    string
+		grammar Grammar
+		leftRec []Rule
+	}{
+		{name: "none", grammar: Grammar{"a": Seq{S("("), Rule("a"), S(")")}}},
+		{name: "direct", grammar: Grammar{"a": Oneof{Seq{Rule("a"), S("x")}, S("y")}}, leftRec: []Rule{"a"}},
+		{
+			name:    "indirect",
+			grammar: Grammar{"a": Oneof{Seq{Rule("b"), S("x")}, S("y")}, "b": Rule("a"), "c": Rule("a")},
+			leftRec: []Rule{"a", "b"},
+		},
+		{
+			name:    "after nullable",
+			grammar: Grammar{"a": Oneof{Seq{Opt(S("-")), Rule("a"), S("x")}, S("y")}},
+			leftRec: []Rule{"a"},
+		},
+		{name: "after required", grammar: Grammar{"a": Oneof{Seq{Some(S("-")), Rule("a")}, S("y")}}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			expected := map[Rule]bool{}
+			for _, rule := range test.leftRec {
+				expected[rule] = true
+			}
+			assert.Equal(t, expected, leftRecursion(test.grammar, nil))
+		})
+	}
+}
+
+func TestParserLeftRecursion(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"expr":    Oneof{Seq{Rule("expr"), S("-"), Rule("term")}, Rule("term")},
+		"term":    Oneof{Seq{Rule("term"), S("*"), Rule("num")}, Rule("num")},
+		"num":     RE(`\d+`),
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+
+	for _, opts := range [][]ParseOption{nil, {WithMemo()}} {
+		v, err := p.Parse("expr", NewScanner("1 - 2 * 3 - 4"), opts...)
+		require.NoError(t, err)
+
+		// Left recursion should produce a left-associative tree.
+		n := v.(Node)
+		assert.Equal(t, Choice(0), n.Extra)
+		assert.Equal(t, "4", n.GetString(0, 2, 0))
+		left := n.GetNode(0, 0)
+		assert.Equal(t, Choice(0), left.Extra)
+		assert.Equal(t, "1", left.GetString(0, 0, 0, 0))
+		assert.Equal(t, "*", left.GetString(0, 2, 0, 1))
+	}
+
+	_, err := p.Parse("expr", NewScanner("1 - "))
+	assert.Error(t, err)
+}
+
+func TestParserIndirectLeftRecursion(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"list": Oneof{Seq{Rule("item"), S(","), RE(`\w`)}, RE(`\w`)},
+		"item": Seq{Rule("list")},
+	}.Compile(nil)
+
+	v, err := p.Parse("list", NewScanner("a,b,c"))
+	require.NoError(t, err)
+	assert.Equal(t, "c", v.(Node).GetString(0, 2))
+	assert.Equal(t, 3, strings.Count(v.(Node).String(), "list"))
+}
+
+func TestParserLeftRecursionWithoutBase(t *testing.T) {
+	t.Parallel()
+	p := Grammar{"a": Seq{Rule("a"), S("x")}}.Compile(nil)
+	_, err := p.Parse("a", NewScanner("xx"))
+	assert.IsType(t, ParseError{}, err)
+}
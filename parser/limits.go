@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMaxDepth is the cause of a StoppedError when rules nest more deeply
+	// than WithMaxDepth allows.
+	ErrMaxDepth = errors.New("maximum rule depth exceeded")
+
+	// ErrMaxSteps is the cause of a StoppedError when a parse enters more
+	// rules than WithMaxSteps allows.
+	ErrMaxSteps = errors.New("maximum parse steps exceeded")
+)
+
+// stepsPerCheck is the number of rule entries between checks for the
+// cancellation of a parse's context.
+const stepsPerCheck = 256
+
+// StoppedError reports that a parse was abandoned before it finished, because
+// its context was done, it exceeded a limit, its input couldn't be read or a
+// token rule it refers to can't be matched. It is a StopError, so no
+// alternative is tried and no error recovery is attempted after it.
+type StoppedError struct {
+	cause error
+	input Scanner
+}
+
+func (StoppedError) IsStopError() {}
+
+func (e StoppedError) Error() string {
+	line, col := 1, 1
+	if !e.input.IsNil() {
+		line, col = e.input.Position()
+	}
+	return fmt.Sprintf("parse stopped at %d:%d: %v", line, col, e.cause)
+}
+
+// Unwrap returns the reason the parse stopped: the error of its context,
+// ErrMaxDepth, ErrMaxSteps, the error reading its input or what is wrong with
+// a token rule.
+func (e StoppedError) Unwrap() error { return e.cause }
+
+// Input returns the remaining input at the point the parse stopped.
+func (e StoppedError) Input() Scanner { return e.input }
+
+// WithMaxDepth stops the parse with ErrMaxDepth if rules nest more than n deep.
+func WithMaxDepth(n int) ParseOption {
+	return func(st *parseState) {
+		st.maxDepth = n
+	}
+}
+
+// WithMaxSteps stops the parse with ErrMaxSteps after n rules have been
+// entered. Each attempt at a rule counts, including those that backtrack, so
+// this bounds the work a parse can do.
+func WithMaxSteps(n int) ParseOption {
+	return func(st *parseState) {
+		st.maxSteps = n
+	}
+}
+
+func withContext(ctx context.Context) ParseOption {
+	return func(st *parseState) {
+		if ctx.Done() != nil {
+			st.ctx = ctx
+		}
+	}
+}
+
+// ParseContext parses like Parse, but stops if ctx is done before the parse
+// finishes, returning a StoppedError that wraps ctx.Err().
+func (p Parsers) ParseContext(ctx context.Context, rule Rule, input *Scanner, opts ...ParseOption) (TreeElement, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, StoppedError{cause: err, input: *input}
+	}
+	return p.Parse(rule, input, append(opts, withContext(ctx))...)
+}
+
+func (st *parseState) limited() bool {
+	return st.ctx != nil || st.maxDepth > 0 || st.maxSteps > 0
+}
+
+// enter accounts for entering a rule, returning a StoppedError if the parse
+// must stop. Each successful call must be followed by a call to exit.
+func (st *parseState) enter(input *Scanner) error {
+	st.steps++
+	if st.maxSteps > 0 && st.steps > st.maxSteps {
+		return StoppedError{cause: ErrMaxSteps, input: *input}
+	}
+	if st.maxDepth > 0 && st.depth >= st.maxDepth {
+		return StoppedError{cause: ErrMaxDepth, input: *input}
+	}
+	if st.ctx != nil && st.steps%stepsPerCheck == 0 {
+		select {
+		case <-st.ctx.Done():
+			return StoppedError{cause: st.ctx.Err(), input: *input}
+		default:
+		}
+	}
+	st.depth++
+	return nil
+}
+
+func (st *parseState) exit() {
+	st.depth--
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// explosiveGrammar takes time exponential in the nesting of its input without
+// memoization, since each level parses the next three times.
+var explosiveGrammar = Grammar{
+	"stmts": Some(Seq{CutPoint{S("do")}, Rule("a"), S(";")}),
+	"a":     Oneof{Seq{Rule("b"), S("x")}, Seq{Rule("b"), S("y")}, Rule("b")},
+	"b":     Oneof{Seq{S("("), Rule("a"), S(")")}, S("z")},
+	".sync": S(";"),
+}.Compile(nil)
+
+func explosiveInput(depth int) string {
+	return "do" + strings.Repeat("(", depth) + "z" + strings.Repeat(")", depth) + ";"
+}
+
+func assertStopped(t *testing.T, err, cause error) {
+	t.Helper()
+	var stopped StoppedError
+	if assert.True(t, errors.As(err, &stopped), "%v", err) {
+		assert.ErrorIs(t, stopped, cause)
+		assert.Implements(t, (*StopError)(nil), stopped)
+	}
+}
+
+func TestParseMaxSteps(t *testing.T) {
+	t.Parallel()
+	_, err := explosiveGrammar.Parse("stmts", NewScanner(explosiveInput(40)), WithMaxSteps(10000))
+	assertStopped(t, err, ErrMaxSteps)
+
+	// Recovery doesn't skip past the limit.
+	_, err = explosiveGrammar.Parse("stmts", NewScanner(explosiveInput(40)), WithMaxSteps(10000), WithRecovery())
+	assertStopped(t, err, ErrMaxSteps)
+
+	_, err = explosiveGrammar.Parse("stmts", NewScanner(explosiveInput(3)), WithMaxSteps(10000))
+	assert.NoError(t, err)
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	t.Parallel()
+	g := Grammar{"a": Oneof{Seq{S("("), Rule("a"), S(")")}, S("z")}}.Compile(nil)
+	input := strings.Repeat("(", 100) + "z" + strings.Repeat(")", 100)
+	_, err := g.Parse("a", NewScanner(input), WithMaxDepth(50))
+	assertStopped(t, err, ErrMaxDepth)
+	assert.Equal(t, 50, err.(StoppedError).Input().Offset())
+
+	_, err = g.Parse("a", NewScanner(input), WithMaxDepth(101))
+	assert.NoError(t, err)
+}
+
+func TestParseContext(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := explosiveGrammar.ParseContext(ctx, "stmts", NewScanner(explosiveInput(40)))
+	assertStopped(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = explosiveGrammar.ParseContext(ctx, "stmts", NewScanner(explosiveInput(1)))
+	assertStopped(t, err, context.DeadlineExceeded)
+
+	e, err := explosiveGrammar.ParseContext(context.Background(), "stmts", NewScanner(explosiveInput(1)))
+	require.NoError(t, err)
+	assert.NotNil(t, e)
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// lineIndex records where the lines of a text start. It is built on first use
+// and shared by every scanner over the text, so that finding the line of an
+// offset takes a binary search rather than a scan of the text before it.
+type lineIndex struct {
+	once   sync.Once
+	starts []int // the offset of the start of every line after the first
+}
+
+func (x *lineIndex) get(text string) []int {
+	x.once.Do(func() { x.starts = lineStarts(text, 0, nil) })
+	return x.starts
+}
+
+// lineStarts appends to starts the offset of the start of every line of text
+// after the first, counting offsets from base.
+func lineStarts(text string, base int, starts []int) []int {
+	for i := strings.IndexByte(text, '\n'); i >= 0; {
+		starts = append(starts, base+i+1)
+		j := strings.IndexByte(text[i+1:], '\n')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	return starts
+}
+
+// lineAt returns the 0-indexed line that contains offset i and the offset at
+// which that line starts, given the starts of every line after the first.
+func lineAt(starts []int, i int) (line, start int) {
+	line = sort.SearchInts(starts, i+1)
+	if line > 0 {
+		start = starts[line-1]
+	}
+	return line, start
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// maxWidth returns the most bytes t can match, not counting text consumed by
+// .wrapRE, and the most tokens it can match. ok is false if either is
+// unbounded or depends on other rules.
+func maxWidth(t Term) (width, tokens int, ok bool) {
+	switch t := t.(type) {
+	case S:
+		return len(t), 1, true
+	case SI:
+		// Other cases of each rune may be longer.
+		return utf8.RuneCountInString(string(t)) * utf8.UTFMax, 1, true
+	case RE:
+		re, err := syntax.Parse(string(t), syntax.Perl)
+		if err != nil {
+			return 0, 0, false
+		}
+		width, ok := maxREWidth(re.Simplify())
+		return width, 1, ok
+	case Seq:
+		for _, term := range t {
+			w, n, ok := maxWidth(term)
+			if !ok {
+				return 0, 0, false
+			}
+			width += w
+			tokens += n
+		}
+		return width, tokens, true
+	case Oneof:
+		for _, term := range t {
+			w, n, ok := maxWidth(term)
+			if !ok {
+				return 0, 0, false
+			}
+			if w > width {
+				width = w
+			}
+			if n > tokens {
+				tokens = n
+			}
+		}
+		return width, tokens, true
+	case Quant:
+		if t.Max == 0 {
+			return 0, 0, false
+		}
+		w, n, ok := maxWidth(t.Term)
+		return w * t.Max, n * t.Max, ok
+	case Named:
+		return maxWidth(t.Term)
+	case CutPoint:
+		return maxWidth(t.Term)
+	case LookAhead, NotLookAhead, LookBehind, NotLookBehind, Position:
+		return 0, 0, true
+	}
+	return 0, 0, false
+}
+
+// maxREWidth returns the most bytes re can match.
+func maxREWidth(re *syntax.Regexp) (int, bool) {
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch,
+		syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return 0, true
+	case syntax.OpLiteral:
+		width := 0
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 {
+				// Other cases of r may be longer.
+				width += utf8.UTFMax
+			} else {
+				width += utf8.RuneLen(r)
+			}
+		}
+		return width, true
+	case syntax.OpCharClass:
+		if n := len(re.Rune); n > 0 {
+			if width := utf8.RuneLen(re.Rune[n-1]); width > 0 {
+				return width, true
+			}
+		}
+		return utf8.UTFMax, true
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return utf8.UTFMax, true
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxREWidth(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Max < 0 {
+			return 0, false
+		}
+		width, ok := maxREWidth(re.Sub[0])
+		return width * re.Max, ok
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			width, ok := maxREWidth(sub)
+			if !ok {
+				return 0, false
+			}
+			if re.Op == syntax.OpConcat {
+				total += width
+			} else if width > total {
+				total = width
+			}
+		}
+		return total, true
+	}
+	// OpStar and OpPlus.
+	return 0, false
+}
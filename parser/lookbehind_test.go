@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotLookAhead(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"ident": Seq{NotLookAhead{Term: Seq{S("let"), RE(`\W|\z`)}}, RE(`\w+`)},
+	}.Compile(nil)
+
+	e, err := g.Parse("ident", NewScanner("letter"))
+	require.NoError(t, err)
+	assert.Equal(t, "letter", unparseString(t, lossless(g.Grammar()), e))
+
+	_, err = g.Parse("ident", NewScanner("let"))
+	assert.Error(t, err)
+}
+
+func TestLookBehind(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a":       Seq{RE(`\w*`), Rule("b"), RE(`:?`), Rule("c")},
+		"b":       Seq{LookBehind{Term: S("x")}, S(".")},
+		"c":       Seq{NotLookBehind{Term: Oneof{S(":"), S("::")}}, RE(`\w+`)},
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+
+	for _, input := range []string{"x.y", "abx . y", "x  .\ny"} {
+		e, err := g.Parse("a", NewScanner(input))
+		require.NoError(t, err, "%q", input)
+		assert.Equal(t, input, unparseString(t, lossless(g.Grammar()), e), "%q", input)
+		assert.Equal(t, input, unparseString(t, pretty(g.Grammar()), e), "%q", input)
+	}
+
+	for _, input := range []string{"y.z", "x.:z", "x. : z"} {
+		_, err := g.Parse("a", NewScanner(input))
+		assert.Error(t, err, "%q", input)
+	}
+}
+
+func TestLookBehindUnbounded(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a": Seq{RE(`a*`), LookBehind{Term: Seq{S("b"), Quant{Term: S("a")}}}, S("c")},
+	}.Compile(nil)
+
+	_, err := g.Parse("a", NewScanner("aaac"))
+	assert.Error(t, err)
+
+	g = Grammar{
+		"a": Seq{RE(`[ab]*`), LookBehind{Term: Seq{S("b"), Quant{Term: S("a")}}}, S("c")},
+	}.Compile(nil)
+	_, err = g.Parse("a", NewScanner("ab"+strings.Repeat("a", 100)+"c"))
+	assert.NoError(t, err)
+}
+
+func TestLookBehindStops(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a": Seq{RE(`\w*`), NotLookBehind{Term: Rule("b")}},
+		"b": RE(`\w`),
+	}.Compile(nil)
+
+	_, err := g.Parse("a", NewScanner("abc"), WithMaxSteps(1))
+	var stopped StoppedError
+	assert.ErrorAs(t, err, &stopped)
+}
+
+func TestMaxWidth(t *testing.T) {
+	t.Parallel()
+	for _, test := range []struct {
+		term          Term
+		width, tokens int
+		ok            bool
+	}{
+		{S("abc"), 3, 1, true},
+		{RE(`a[bc]?d{2,3}`), 5, 1, true},
+		{RE(`é|x`), 2, 1, true},
+		{RE(`(?i)k`), 4, 1, true},
+		{RE(`\w+`), 0, 0, false},
+		{Seq{S("a"), Quant{Term: RE(`b`), Max: 2}, LookAhead{Term: RE(`.*`)}}, 3, 3, true},
+		{Oneof{S("ab"), Seq{S("a"), S("b")}}, 2, 2, true},
+		{Quant{Term: S("a")}, 0, 0, false},
+		{Rule("a"), 0, 0, false},
+	} {
+		width, tokens, ok := maxWidth(test.term)
+		assert.Equal(t, test.ok, ok, "%v", test.term)
+		if test.ok {
+			assert.Equal(t, test.width, width, "%v", test.term)
+			assert.Equal(t, test.tokens, tokens, "%v", test.term)
+		}
+	}
+}
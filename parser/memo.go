@@ -0,0 +1,48 @@
+package parser
+
+type memoKey struct {
+	p      *entryParser
+	offset int
+	length int
+	cut    bool
+}
+
+type memoEntry struct {
+	output TreeElement
+	input  Scanner
+	err    error
+	cp     Cutpointdata
+	ends   tokenEnds
+}
+
+// memoTable records rule outcomes for packrat parsing.
+//
+// An outcome depends on whether a cutpoint is active when the rule is entered,
+// since that decides whether a failure is fatal, but not on the cutpoint's
+// actual value. Fatal errors tagged with the recorded cutpoint are retagged
+// with the current one when an outcome is reused.
+type memoTable map[memoKey]memoEntry
+
+func (m memoTable) parse(p *entryParser, scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	cp := scope.GetCutPoint()
+	st := scope.getParseState()
+	key := memoKey{p: p, offset: input.sliceStart, length: input.sliceLength, cut: cp.valid()}
+	if e, has := m[key]; has {
+		*input = e.input
+		*output = e.output
+		st.restoreTokenEnds(e.ends)
+		if fatal, ok := e.err.(FatalError); ok && fatal.Cutpointdata == e.cp {
+			fatal.Cutpointdata = cp
+			return fatal
+		}
+		return e.err
+	}
+
+	volatile := st.volatile
+	before := st.tokenEnds()
+	err := p.parse(st, scope, input, output, stk)
+	if st.volatile == volatile {
+		m[key] = memoEntry{output: *output, input: *input, err: err, cp: cp, ends: st.tokenEndsSince(before)}
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParserMemo(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a":       Oneof{Seq{Rule("b"), S("!")}, Seq{Rule("b"), S("?")}, Rule("b")},
+		"b":       Some(Oneof{Seq{S("("), Rule("b"), S(")")}, RE(`\w+`)}),
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+
+	for _, input := range []string{"x", "x ?", "((x) y) !", "((x) (y z))", "(x"} {
+		expected, expectedErr := p.Parse("a", NewScanner(input))
+		actual, actualErr := p.Parse("a", NewScanner(input), WithMemo())
+		if expectedErr != nil {
+			assert.IsType(t, expectedErr, actualErr, input)
+			continue
+		}
+		if assert.NoError(t, actualErr, input) {
+			AssertEqualNodes(t, expected.(Node), actual.(Node))
+		}
+	}
+}
+
+// reusedLookBehindGrammar looks behind the token of b, after the first
+// alternative matched c beyond it, so reusing b must restore where its token
+// ended.
+var reusedLookBehindGrammar = Grammar{
+	"s":       Oneof{Seq{Rule("b"), Rule("c"), S("!")}, Seq{Rule("b"), LookBehind{S("x")}, S("?")}},
+	"b":       S("x"),
+	"c":       S("?"),
+	".wrapRE": RE(`\s*()\s*`),
+}.Compile(nil, WithoutOptimization())
+
+func TestParserMemoLookBehind(t *testing.T) {
+	t.Parallel()
+	expected, err := reusedLookBehindGrammar.Parse("s", NewScanner("x ?"))
+	require.NoError(t, err)
+	actual, err := reusedLookBehindGrammar.Parse("s", NewScanner("x ?"), WithMemo())
+	require.NoError(t, err)
+	AssertEqualNodes(t, expected.(Node), actual.(Node))
+}
+
+func TestParserMemoBackref(t *testing.T) {
+	t.Parallel()
+	// r is attempted at the same offset twice, with different bindings for x.
+	p := Grammar{
+		"a": Oneof{
+			Seq{Eq("x", S("a")), S("b"), Rule("r")},
+			Seq{S("a"), Eq("x", S("b")), Rule("r")},
+		},
+		"r": REF{Ident: "x"},
+	}.Compile(nil)
+
+	_, err := p.Parse("a", NewScanner("abb"), WithMemo())
+	assert.NoError(t, err)
+}
+
+func TestParserMemoExternals(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a": Oneof{Seq{Rule("e"), S("!")}, Seq{Rule("e"), S("?")}},
+		"e": ExtRef("ext"),
+	}.Compile(nil)
+
+	calls := 0
+	_, err := p.ParseWithExternals("a", NewScanner("x?"), ExternalRefs{
+		"ext": func(scope Scope, input *Scanner) (TreeElement, error) {
+			calls++
+			var eaten Scanner
+			input.Eat(1, &eaten)
+			return eaten, nil
+		},
+	}, WithMemo())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestParserMemoEscape(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a": Oneof{Seq{S("y"), Rule("e"), S("!")}, Seq{S("y"), Rule("e"), S("?")}},
+		"e": S("x"),
+	}.Compile(nil, WithoutOptimization())
+
+	for _, opts := range [][]ParseOption{nil, {WithMemo()}} {
+		calls := 0
+		_, err := p.ParseWithExternals("a", NewScanner("y{:x:}?"), ExternalRefs{
+			"*{:():}": func(scope Scope, input *Scanner) (TreeElement, error) {
+				calls++
+				var eaten Scanner
+				input.Eat(1, &eaten)
+				return eaten, nil
+			},
+		}, opts...)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	}
+}
+
+// nestedAlts backtracks over every alternative of "a" at each nesting level,
+// which is exponential in depth without memoization.
+var nestedAlts = Grammar{
+	"a": Oneof{Seq{Rule("b"), S("!")}, Seq{Rule("b"), S("?")}, Rule("b")},
+	"b": Oneof{Seq{S("("), Rule("a"), S(")")}, S("x")},
+}.Compile(nil)
+
+func BenchmarkParserNestedAlternatives(b *testing.B) {
+	for _, depth := range []int{3, 6, 9} {
+		input := strings.Repeat("(", depth) + "x" + strings.Repeat(")", depth)
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				nestedAlts.MustParse("a", NewScanner(input))
+			}
+		})
+		b.Run(fmt.Sprintf("depth=%d-memo", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := nestedAlts.Parse("a", NewScanner(input), WithMemo()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
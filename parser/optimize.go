@@ -0,0 +1,298 @@
+package parser
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Optimization makes the parsers of a grammar faster without changing what
+// they parse. The grammar a Parsers holds is left alone, and every optimized
+// parser produces exactly the tree, including each Oneof's Choice, that the
+// verbatim one would, so the trees still fit ast.FromParserNode and the code
+// generated for the grammar. Errors are the same too, except that a delimited
+// list reports one less level of sequence.
+//
+// The optimizations are:
+//
+//   - Each run of two or more alternatives of a Oneof that are tokens, or
+//     rules that are tokens, is matched with a single regexp, whose first
+//     matching alternative is the one the Oneof would have chosen.
+//   - A rule that is a token and is referred to only once is parsed in place,
+//     without the bookkeeping of a rule invocation.
+//   - Adjacent alternatives of a Oneof that begin with the same items parse
+//     those items only once.
+//   - A delimited list parses as a flat sequence of its term and the rest of
+//     the list, rather than nesting them in a sequence of their own.
+//
+// Other nested sequences aren't flattened. A sequence bounds the reach of its
+// cutpoints and of the names its items bind, and its node stays in the tree,
+// so a flattened parse would have to rebuild each of them at its end and
+// would save little.
+//
+// When the alternatives matched by a single regexp all fail, the error each
+// would have reported is only made when the error is rendered, by parsing it
+// again on its own.
+//
+// The optimized paths stand aside when a parse is traced, limited, parsing an
+// edited source or inside a parse escape, since each of those observes the
+// individual steps the optimizations skip.
+
+// direct reports whether a parse may skip the individual steps that tracing,
+// limits and incremental parsing observe.
+func direct(scope Scope, input *Scanner) bool {
+	if scope.tracing() {
+		return false
+	}
+	if _, ok := input.src.(*editSource); ok {
+		return false
+	}
+	st := scope.getParseState()
+	return st == nil || !st.limited()
+}
+
+// link points the references to a rule at its parser. A rule that is a token
+// and is referred to only once is parsed in place.
+func (c cache) link(p Parser, rulePtrs []*Parser) {
+	if entry, ok := p.(*entryParser); ok && c.optimize && len(rulePtrs) == 1 && !entry.leftRec {
+		if _, ok := tokenRE(entry.p); ok {
+			p = inlineParser{entry}
+		}
+	}
+	for _, rulePtr := range rulePtrs {
+		*rulePtr = p
+	}
+}
+
+// inlineParser parses a token rule in place of its entryParser.
+type inlineParser struct {
+	entry *entryParser
+}
+
+func (p inlineParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	if !direct(scope, input) {
+		return p.entry.Parse(scope, input, output, stk)
+	}
+	return p.entry.p.Parse(scope, input, output, stk)
+}
+func (p inlineParser) AsTerm() Term { return p.entry.AsTerm() }
+
+// maxTokenGroups is the most capture groups eatRegexp can take a token from.
+const maxTokenGroups = 2
+
+// tokenRE returns the parser's regexp if it matches a token, which eatRegexp
+// takes from its last capture group.
+func tokenRE(p Parser) (*regexp.Regexp, bool) {
+	switch p := p.(type) {
+	case *sParser:
+		return p.re, p.re.NumSubexp() <= maxTokenGroups
+	case *siParser:
+		return p.re, p.re.NumSubexp() <= maxTokenGroups
+	case *reParser:
+		return p.re, p.re.NumSubexp() <= maxTokenGroups
+	case *cutPointParser:
+		// A cutpoint has no effect as an alternative.
+		return tokenRE(p.p)
+	case inlineParser:
+		return tokenRE(p.entry.p)
+	}
+	return nil, false
+}
+
+// oneofStep tries the alternatives from up to to of a oneofParser.
+type oneofStep struct {
+	from, to int
+
+	// re matches the alternatives as tokens. The match of each alternative is
+	// in the capture group alts[i-from], and its token in tokens[i-from].
+	re           *regexp.Regexp
+	alts, tokens []int
+
+	// prefix is the number of leading items the alternatives, which are all
+	// sequences, share.
+	prefix int
+}
+
+// plan groups the alternatives of the oneof into steps.
+func (p *oneofParser) plan() {
+	var steps []oneofStep
+	optimized := false
+	for i := 0; i < len(p.parsers); {
+		step := p.tokenStep(i)
+		if step.to-step.from < 2 {
+			step = p.prefixStep(i)
+		}
+		if step.to-step.from < 2 {
+			step = oneofStep{from: i, to: i + 1}
+		} else {
+			optimized = true
+		}
+		steps = append(steps, step)
+		i = step.to
+	}
+	if optimized {
+		p.steps = steps
+	}
+}
+
+// tokenStep matches the run of token alternatives from i with one regexp.
+func (p *oneofParser) tokenStep(i int) oneofStep {
+	step := oneofStep{from: i, to: i}
+	var alts []string
+	group := 1
+	for ; step.to < len(p.parsers); step.to++ {
+		re, ok := tokenRE(p.parsers[step.to])
+		if !ok {
+			break
+		}
+		alts = append(alts, "("+strings.TrimPrefix(re.String(), `(?m)\A`)+")")
+		step.alts = append(step.alts, group)
+		step.tokens = append(step.tokens, group+re.NumSubexp())
+		group += 1 + re.NumSubexp()
+	}
+	if len(alts) > 1 {
+		step.re = regexp.MustCompile(`(?m)\A(?:` + strings.Join(alts, "|") + `)`)
+	}
+	return step
+}
+
+// prefixStep groups the sequences from i that begin with the same items.
+func (p *oneofParser) prefixStep(i int) oneofStep {
+	step := oneofStep{from: i, to: i}
+	first, ok := p.parsers[i].(*seqParser)
+	if !ok {
+		return step
+	}
+	for ; step.to < len(p.parsers); step.to++ {
+		seq, ok := p.parsers[step.to].(*seqParser)
+		if !ok || seq.rule != first.rule {
+			break
+		}
+		n := sharedItems(first, seq)
+		if n == 0 {
+			break
+		}
+		if step.to == i || n < step.prefix {
+			step.prefix = n
+		}
+	}
+	return step
+}
+
+// sharedItems returns the number of leading items two sequences share. A
+// cutpoint ends them, since each sequence would cut on its own.
+func sharedItems(a, b *seqParser) int {
+	n := 0
+	for n < len(a.t) && n < len(b.t) && reflect.DeepEqual(a.t[n], b.t[n]) && !isCutPoint(a.parsers[n]) {
+		n++
+	}
+	return n
+}
+
+// parseSteps is Parse by way of the steps.
+func (p *oneofParser) parseSteps(scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	furthest := *input
+	input.hold()
+	defer input.unhold()
+
+	stk = stk.push(string(p.rule), p.AsTerm())
+	scope, prevcp, mycp := scope.ReplaceCutPoint(false)
+	var errors []func() error
+	for _, step := range p.steps {
+		start := *input
+		switch {
+		case step.re != nil:
+			if loc := start.match(step.re); loc != nil && loc[0] == 0 {
+				for j, group := range step.alts {
+					if loc[2*group] >= 0 {
+						t := step.tokens[j]
+						match, token := *start.Slice(0, loc[1]), *start.Slice(loc[2*t], loc[2*t+1])
+						noteToken(scope, match, &token)
+						*input = *start.Skip(loc[1])
+						return p.put(output, Choice(step.from+j), token)
+					}
+				}
+			}
+			// Each alternative failed as it would have on its own, which
+			// only leaves the error it would have reported.
+			for _, par := range p.parsers[step.from:step.to] {
+				par := par
+				errors = append(errors, func() error {
+					// The error may be rendered while the parse goes on, which
+					// this parse must not disturb.
+					start := start
+					var v TreeElement
+					return par.Parse(scope.withParseState(&parseState{}), &start, &v, stk)
+				})
+			}
+		case step.prefix > 0:
+			// The prefix is parsed for the first alternative and shared with
+			// the rest, unless its outcome depended on more than the input.
+			st := scope.getParseState()
+			volatile := 0
+			if st != nil {
+				volatile = st.volatile
+			}
+			first := p.parsers[step.from].(*seqParser)
+			after := start
+			rest, prefix, perr := first.items(scope, &after, stk, 0, step.prefix, make([]TreeElement, 0, step.prefix))
+			shared := st != nil && st.volatile == volatile
+			var tokenEnd, matchEnd int
+			if st != nil {
+				tokenEnd, matchEnd = st.tokenEnd, st.matchEnd
+			}
+			for i := step.from; i < step.to; i++ {
+				seq := p.parsers[i].(*seqParser)
+				end := after
+				var v TreeElement
+				var err error
+				switch {
+				case i > step.from && !shared:
+					end = start
+					err = seq.Parse(scope, &end, &v, stk)
+				case perr != nil:
+					err = perr
+				default:
+					if st != nil {
+						// Tokens matched by alternatives tried before this
+						// one don't precede its items.
+						st.tokenEnd, st.matchEnd = tokenEnd, matchEnd
+					}
+					result := append(make([]TreeElement, 0, len(seq.parsers)), prefix...)
+					if _, result, err = seq.items(rest, &end, stk, step.prefix, len(seq.parsers), result); err == nil {
+						err = seq.put(&v, nil, result...)
+					}
+				}
+				if err != nil {
+					if isNotMyFatalError(err, mycp) {
+						return err
+					}
+					errors = append(errors, func() error { return err })
+					if furthest.Offset() < end.Offset() {
+						furthest = end
+					}
+					continue
+				}
+				*input = end
+				return p.put(output, Choice(i), v)
+			}
+		default:
+			var v TreeElement
+			if err := p.parsers[step.from].Parse(scope, &start, &v, stk); err != nil {
+				if isNotMyFatalError(err, mycp) {
+					return err
+				}
+				errors = append(errors, func() error { return err })
+				if furthest.Offset() < start.Offset() {
+					furthest = start
+				}
+				continue
+			}
+			*input = start
+			return p.put(output, Choice(step.from), v)
+		}
+	}
+	errors = append(errors, func() error { return stk })
+	*input = furthest
+	return newParseError(p.rule, input, "None of the available options could be satisfied")(prevcp, errors...)
+}
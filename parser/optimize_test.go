@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pointers matches the addresses of calls in the stacks of parse errors.
+var pointers = regexp.MustCompile(`0x[0-9a-f]+`)
+
+// assertSameParse parses each input with g compiled with and without
+// optimization, and checks that the two agree.
+func assertSameParse(t *testing.T, g Grammar, rule Rule, inputs ...string) {
+	t.Helper()
+	compareParses(t, g, rule, true, inputs)
+}
+
+// compareParses is assertSameParse, but only compares where parses failed
+// unless exact.
+func compareParses(t *testing.T, g Grammar, rule Rule, exact bool, inputs []string) {
+	t.Helper()
+	optimized, verbatim := g.Compile(nil), g.Compile(nil, WithoutOptimization())
+	for _, input := range inputs {
+		s := NewScanner(input)
+		a, b := *s, *s
+		tree, err := optimized.Parse(rule, &a)
+		want, wantErr := verbatim.Parse(rule, &b)
+		assert.Equal(t, want, tree, input)
+		if !assert.Equal(t, wantErr == nil, err == nil, input) || err == nil {
+			continue
+		}
+		assert.IsType(t, wantErr, err, input)
+		if exact {
+			assert.Equal(t,
+				pointers.ReplaceAllString(wantErr.Error(), "0x"),
+				pointers.ReplaceAllString(err.Error(), "0x"),
+				input)
+		} else if pe, ok := wantErr.(ParseError); ok {
+			assert.Equal(t, pe.Furthest().String(), err.(ParseError).Furthest().String(), input)
+		}
+	}
+}
+
+func oneofSteps(t *testing.T, p Parsers, rule Rule) []oneofStep {
+	t.Helper()
+	oneof, ok := p.parsers[rule].(*entryParser).p.(*oneofParser)
+	require.True(t, ok)
+	return oneof.steps
+}
+
+func TestOptimizeMergesTokens(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a":       Oneof{S("if"), SI("else"), RE(`(\d)\d*`), Rule("kw"), Seq{S("("), Rule("a"), S(")")}, S("x")},
+		"kw":      S("while"),
+		".wrapRE": RE(`\s*()\s*`),
+	}
+
+	steps := oneofSteps(t, g.Compile(nil), "a")
+	require.Len(t, steps, 3)
+	assert.NotNil(t, steps[0].re)
+	assert.Equal(t, [2]int{0, 4}, [2]int{steps[0].from, steps[0].to})
+	assert.Nil(t, oneofSteps(t, g.Compile(nil, WithoutOptimization()), "a"))
+
+	assertSameParse(t, g, "a", "if", " ELSE ", "42", "while", "(while)", "( if )", "x", "whilst", "(if", "")
+}
+
+func TestOptimizeInlinesSingleUseTokenRules(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"a":    Seq{Rule("once"), Rule("twice"), Rule("twice")},
+		"once": S("x"),
+		// Referred to twice.
+		"twice": S("y"),
+	}.Compile(nil)
+
+	seq := p.parsers["a"].(*entryParser).p.(*seqParser)
+	assert.IsType(t, inlineParser{}, seq.parsers[0])
+	assert.IsType(t, &entryParser{}, seq.parsers[1])
+
+	// The rule can still be parsed on its own.
+	_, err := p.Parse("once", NewScanner("x"))
+	assert.NoError(t, err)
+
+	// Tracing sees every rule entered.
+	var r traceRecorder
+	_, err = p.Parse("a", NewScanner("xyy"), WithTracer(&r))
+	require.NoError(t, err)
+	assert.Contains(t, r, "1 enter once 0-0")
+}
+
+func TestOptimizeFactorsCommonPrefixes(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a": Oneof{
+			Seq{S("a"), Rule("b"), S("c")},
+			Seq{S("a"), Rule("b"), S("d")},
+			Seq{S("a"), S("e")},
+			Seq{S("f")},
+		},
+		"b":       Oneof{S("b"), Seq{S("("), Rule("a"), S(")")}},
+		".wrapRE": RE(`\s*()\s*`),
+	}
+
+	steps := oneofSteps(t, g.Compile(nil), "a")
+	require.Len(t, steps, 2)
+	assert.Equal(t, 1, steps[0].prefix)
+	assert.Equal(t, [2]int{0, 3}, [2]int{steps[0].from, steps[0].to})
+
+	assertSameParse(t, g, "a", "abc", "abd", "ae", "f", "a(abc)d", "a(ae)c", "ab", "abe", "a", "x")
+}
+
+func TestOptimizeFactoredPrefixesRespectCutPoints(t *testing.T) {
+	t.Parallel()
+	assertSameParse(t, Grammar{
+		"a": Oneof{
+			Seq{S("a"), CutPoint{S("b")}, S("c")},
+			Seq{S("a"), CutPoint{S("b")}, S("d")},
+			Seq{S("a"), S("e")},
+		},
+	}, "a", "abc", "abd", "ae", "abe")
+}
+
+func TestOptimizeFactoredPrefixesRestoreTokenEnds(t *testing.T) {
+	t.Parallel()
+
+	// The lookbehind must find the "a" before it, even though the first
+	// alternative got as far as "b" before failing.
+	assertSameParse(t, Grammar{
+		"a": Oneof{
+			Seq{S("a"), S("b"), S("x")},
+			Seq{S("a"), LookBehind{S("a")}, S("b"), S("y")},
+		},
+		".wrapRE": RE(`\s*()\s*`),
+	}, "a", "a b y", "a b x", "a b z")
+}
+
+func TestOptimizeFlattensDelims(t *testing.T) {
+	t.Parallel()
+	for _, d := range []Delim{
+		{Term: S("a"), Sep: S(",")},
+		{Term: S("a"), Sep: S(","), CanStartWithSep: true},
+		{Term: S("a"), Sep: S(","), CanEndWithSep: true},
+		{Term: S("a"), Sep: S(","), CanStartWithSep: true, CanEndWithSep: true},
+		{Term: S("a"), Sep: S(","), Assoc: LeftToRight},
+		{Term: S("a"), Sep: S(","), Assoc: RightToLeft},
+	} {
+		// The errors have one less level of sequence.
+		compareParses(t, Grammar{"a": d}, "a", false, []string{"a", "a,a,a", ",a,a", "a,a,", ",a,", "", ","})
+	}
+}
+
+func BenchmarkKeywords(b *testing.B) {
+	keywords := strings.Fields("if else while for do switch case default break continue return goto func var const")
+	alts := Oneof{}
+	for _, kw := range keywords {
+		alts = append(alts, S(kw))
+	}
+	g := Grammar{"a": Some(alts), ".wrapRE": RE(`\s*()\s*`)}
+	input := strings.Repeat(strings.Join(keywords, " ")+" ", 20)
+	for _, opts := range [][]CompileOption{nil, {WithoutOptimization()}} {
+		p := g.Compile(nil, opts...)
+		name := "optimized"
+		if opts != nil {
+			name = "verbatim"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Parse("a", NewScanner(input)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package parser
+
+import "context"
+
+// CompileOption configures Grammar.Compile.
+type CompileOption func(*compileOptions)
+
+type compileOptions struct {
+	optimize bool
+}
+
+// WithoutOptimization compiles each term of the grammar into a parser
+// verbatim. By default, runs of alternative tokens are matched with a single
+// regexp, alternatives that begin with the same items parse them only once,
+// tokens defined by rules used once are parsed in place and delimited lists
+// parse as a flat sequence. The trees parsed are the same either way.
+func WithoutOptimization() CompileOption {
+	return func(o *compileOptions) {
+		o.optimize = false
+	}
+}
+
+// ParseOption configures a single run of Parsers.ParseWithExternals.
+type ParseOption func(*parseState)
+
+// WithMemo enables packrat memoization. The outcome of each rule is recorded
+// against the position it was attempted at, so when an alternative backtracks
+// and the same rule is tried again at the same place, the recorded outcome is
+// reused instead of reparsing. Outcomes that consulted a backref (REF), an
+// external (ExtRef) or a parse escape are never recorded, since they depend on
+// more than the input.
+func WithMemo() ParseOption {
+	return func(st *parseState) {
+		st.memo = memoTable{}
+	}
+}
+
+// WithRecovery enables error recovery. When a repetition fails after a cutpoint
+// has committed the parse to it, the input is skipped up to and including the
+// next match of the grammar's .sync rule, an ErrorNode is recorded in place of
+// the failed item and the repetition carries on. A parse that recovered from
+// errors returns its tree in a RecoveryError.
+func WithRecovery() ParseOption {
+	return func(st *parseState) {
+		st.recover = true
+	}
+}
+
+// WithTrivia records the trivia around each token: the text, such as
+// whitespace and comments, that .wrapRE consumes without it becoming part of
+// the tree. It is available from the token's Scanner via Leading and Trailing.
+// The trivia between two tokens is divided at the first newline: the text up
+// to and including it trails the first token and the rest leads the second.
+func WithTrivia() ParseOption {
+	return func(st *parseState) {
+		st.trivia = true
+	}
+}
+
+// parseState holds the mutable bookkeeping of one parse run. It travels by
+// pointer inside the Scope so that every parser in the run sees the same one.
+type parseState struct {
+	memo    memoTable
+	seeds   map[seedKey]*seed
+	recover bool
+	trivia  bool
+	tracer  Tracer
+	rules   []Rule // the rules entered and not yet exited, for the tracer
+
+	// tokenEnd and matchEnd are the ends of the last token matched and of the
+	// text its .wrapRE consumed, for lookbehinds.
+	tokenEnd, matchEnd int
+
+	// tokens records the outcome of each token rule at each position.
+	tokens map[tokenKey]tokenMatch
+
+	// forgotten is the offset before which outcomes are no longer recorded,
+	// since the input there has been released.
+	forgotten int
+
+	// ctx, maxDepth and maxSteps limit the parse, which has entered rules
+	// steps times and is currently depth rules deep.
+	ctx                context.Context
+	maxDepth, maxSteps int
+	depth, steps       int
+
+	// volatile is bumped each time a parser consults something other than the
+	// input. Comparing it before and after a parse tells whether the outcome
+	// is context-dependent.
+	volatile int
+}
+
+func newParseState(opts []ParseOption) *parseState {
+	st := &parseState{}
+	for _, opt := range opts {
+		opt(st)
+	}
+	return st
+}
+
+// tokenEnds is where a rule left parseState.tokenEnd and matchEnd, which
+// lookbehinds read, so that reusing its outcome leaves them as parsing it again
+// would.
+type tokenEnds struct {
+	tokenEnd, matchEnd int
+	moved              bool // whether the rule moved them
+}
+
+func (st *parseState) tokenEnds() tokenEnds {
+	return tokenEnds{tokenEnd: st.tokenEnd, matchEnd: st.matchEnd}
+}
+
+// tokenEndsSince returns where the token ends are, and whether they have moved
+// since before.
+func (st *parseState) tokenEndsSince(before tokenEnds) tokenEnds {
+	ends := st.tokenEnds()
+	ends.moved = ends.tokenEnd != before.tokenEnd || ends.matchEnd != before.matchEnd
+	return ends
+}
+
+// restoreTokenEnds puts the token ends where a rule left them, if it moved
+// them.
+func (st *parseState) restoreTokenEnds(ends tokenEnds) {
+	if ends.moved {
+		st.tokenEnd, st.matchEnd = ends.tokenEnd, ends.matchEnd
+	}
+}
+
+// forget drops the outcomes recorded before offset i, which the parser can no
+// longer return to. Outcomes are swept once a chunk of input has been released
+// since the last sweep, so that they don't accumulate over a long input.
+func (st *parseState) forget(i int) {
+	if i-st.forgotten < readerChunkSize {
+		return
+	}
+	st.forgotten = i
+	for key := range st.memo {
+		if key.offset < i {
+			delete(st.memo, key)
+		}
+	}
+	for key := range st.tokens {
+		if key.offset < i {
+			delete(st.tokens, key)
+		}
+	}
+}
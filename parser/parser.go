@@ -13,18 +13,35 @@ const (
 	StackDelim = "@"
 	At         = Rule(StackDelim)
 
-	seqTag       = "_"
-	oneofTag     = "|"
-	delimTag     = ":"
-	quantTag     = "?"
-	lookaheadTag = "?="
-	WrapRE       = Rule(".wrapRE")
+	seqTag        = "_"
+	oneofTag      = "|"
+	delimTag      = ":"
+	quantTag      = "?"
+	lookaheadTag  = "?="
+	notAheadTag   = "?!"
+	lookbehindTag = "?<="
+	notBehindTag  = "?<!"
+	WrapRE        = Rule(".wrapRE")
+	Sync          = Rule(".sync")
 )
 
 type cache struct {
 	parsers    map[Rule]Parser
 	grammar    Grammar
 	rulePtrses map[Rule][]*Parser
+	tokenizers map[Rule]*tokenizer
+
+	// optimize enables the optimizations in optimize.go, whose oneofs are
+	// planned once every rule is linked.
+	optimize bool
+	oneofs   *[]*oneofParser
+
+	// unbuilt holds the tokenizers to build once every rule is linked.
+	unbuilt *[]*tokenizer
+
+	// behind is the most that a lookbehind of the grammar looks back over, or
+	// -1 if that is unbounded.
+	behind *int
 }
 
 func (c cache) registerRule(parser *Parser) {
@@ -93,9 +110,9 @@ func (g Grammar) resolveStacks() {
 	}
 }
 
-// Compile prepares a grammar for parsing. The parser holds a copy of the
-// grammar modified to support parser execution.
-func (g Grammar) Compile(node any) Parsers {
+// withoutStacks returns the grammar, or a copy of it with its stacks resolved
+// into separate rules for each level.
+func (g Grammar) withoutStacks() Grammar {
 	for _, term := range g {
 		if _, ok := term.(Stack); ok {
 			g = g.clone()
@@ -103,12 +120,31 @@ func (g Grammar) Compile(node any) Parsers {
 			break
 		}
 	}
+	return g
+}
 
+// Compile prepares a grammar for parsing. The parser holds a copy of the
+// grammar modified to support parser execution. It panics if a token
+// reference names a rule that isn't a token rule or a label it lacks. Unless
+// WithoutOptimization is given, the parsers are optimized as described in
+// optimize.go.
+func (g Grammar) Compile(node any, opts ...CompileOption) Parsers {
+	o := compileOptions{optimize: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	g = g.withoutStacks()
 	c := cache{
 		parsers:    map[Rule]Parser{},
 		grammar:    g,
 		rulePtrses: map[Rule][]*Parser{},
+		tokenizers: map[Rule]*tokenizer{},
+		optimize:   o.optimize,
+		oneofs:     &[]*oneofParser{},
+		unbuilt:    &[]*tokenizer{},
+		behind:     new(int),
 	}
+	leftRec := leftRecursion(g, nil)
 	for rule, term := range g {
 		for {
 			switch r := term.(type) {
@@ -118,14 +154,17 @@ func (g Grammar) Compile(node any) Parsers {
 			}
 			break
 		}
-		c.parsers[rule] = term.Parser(rule, c)
+		c.parsers[rule] = &entryParser{rule: rule, t: term, p: term.Parser(rule, c), leftRec: leftRec[rule]}
 	}
 
 	for rule, rulePtrs := range c.rulePtrses {
-		p := c.parsers[rule]
-		for _, rulePtr := range rulePtrs {
-			*rulePtr = p
-		}
+		c.link(c.parsers[rule], rulePtrs)
+	}
+	for _, tok := range *c.unbuilt {
+		tok.once.Do(tok.build)
+	}
+	for _, p := range *c.oneofs {
+		p.plan()
 	}
 
 	return Parsers{
@@ -140,6 +179,7 @@ func parseEscape(p Parser, scope Scope, ident string, t Term, input *Scanner, ou
 	if esc := scope.getParserEscape(); esc != nil {
 		var match Scanner
 		if _, ok := input.EatRegexp(esc.openDelim, &match, nil); ok {
+			scope.markVolatile()
 			if ident != "" {
 				scope = scope.With(ident, t)
 			}
@@ -182,10 +222,50 @@ func (t Rule) Parser(rule Rule, c cache) Parser {
 	}
 }
 
+// entryParser fronts the compiled parser of every grammar rule, so every rule
+// invocation passes through it. Per-parse bookkeeping such as memoization hangs
+// off this point.
+type entryParser struct {
+	rule    Rule
+	t       Term // the rule's term in its grammar, which p may have simplified
+	p       Parser
+	leftRec bool
+}
+
+func (p *entryParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (err error) {
+	if scope.tracing() {
+		start := input.Offset()
+		scope.trace(TraceEnter, p.rule, start, start, nil)
+		defer func() { scope.trace(TraceExit, p.rule, start, input.Offset(), err) }()
+	}
+	st := scope.getParseState()
+	if st != nil && st.limited() {
+		if err := st.enter(input); err != nil {
+			return err
+		}
+		defer st.exit()
+	}
+	if src, ok := input.src.(*editSource); ok && st != nil && !st.recover {
+		return src.parse(p, st, scope, input, output, stk)
+	}
+	if st != nil && st.memo != nil {
+		return st.memo.parse(p, scope, input, output, stk)
+	}
+	return p.parse(st, scope, input, output, stk)
+}
+
+func (p *entryParser) parse(st *parseState, scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	if p.leftRec && st != nil {
+		return st.growSeed(p, scope, input, output, stk)
+	}
+	return p.p.Parse(scope, input, output, stk)
+}
+func (p *entryParser) AsTerm() Term { return p.p.AsTerm() }
+
 //-----------------------------------------------------------------------------
 
 func getErrorStrings(input *Scanner) string {
-	text := strings.TrimSpace(input.String())
+	text := strings.TrimSpace(input.peek(256))
 	if len(text) > 40 {
 		text = text[:40] + "  ..."
 	}
@@ -193,15 +273,33 @@ func getErrorStrings(input *Scanner) string {
 	return NewScanner(text).Context(DefaultLimit)
 }
 
-func eatRegexp(input *Scanner, re *regexp.Regexp, output *TreeElement) bool {
+func eatRegexp(scope Scope, input *Scanner, re *regexp.Regexp, output *TreeElement) bool {
+	var match Scanner
 	var eaten [2]Scanner
-	if n, ok := input.EatRegexp(re, nil, eaten[:]); ok {
-		*output = eaten[n-1]
+	if n, ok := input.EatRegexp(re, &match, eaten[:]); ok {
+		token := eaten[n-1]
+		noteToken(scope, match, &token)
+		*output = token
 		return true
 	}
 	return false
 }
 
+// noteToken records the end of a token and of the text its .wrapRE consumed,
+// which match spans, and under WithTrivia, the trivia around it. The token
+// keeps its text, should its input be released.
+func noteToken(scope Scope, match Scanner, token *Scanner) {
+	if st := scope.getParseState(); st != nil {
+		st.tokenEnd = token.sliceStart + token.sliceLength
+		st.matchEnd = match.sliceStart + match.sliceLength
+		if st.trivia {
+			token.leading = token.sliceStart - match.sliceStart
+			token.trailing = st.matchEnd - st.tokenEnd
+		}
+	}
+	*token = token.held()
+}
+
 func applyWrapRE(re string, prepare func(string) string, c cache) string {
 	pre := prepare(re)
 	if wrap, has := c.grammar[WrapRE]; has {
@@ -235,8 +333,8 @@ func (p *sParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *c
 	if escaped, err := parseEscape(p, scope, string(p.rule), p.t, input, output); escaped || err != nil {
 		return err
 	}
-	if ok := eatRegexp(input, p.re, output); !ok {
-		return newParseError(p.rule, "")(scope.GetCutPoint(),
+	if ok := eatRegexp(scope, input, p.re, output); !ok {
+		return ParseError{rule: p.rule, expected: p.t, input: *input}.with(scope.GetCutPoint(),
 			func() error { return fmt.Errorf("expect: %s", NewScanner(p.t.String()).Context(DefaultLimit)) },
 			func() error { return fmt.Errorf("actual: %s", getErrorStrings(input)) },
 			func() error { return stk },
@@ -255,6 +353,36 @@ func (t S) Parser(rule Rule, c cache) Parser {
 	}
 }
 
+type siParser struct {
+	rule Rule
+	t    SI
+	re   *regexp.Regexp
+}
+
+func (p *siParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) error { //nolint:dupl
+	if escaped, err := parseEscape(p, scope, string(p.rule), p.t, input, output); escaped || err != nil {
+		return err
+	}
+	if ok := eatRegexp(scope, input, p.re, output); !ok {
+		return ParseError{rule: p.rule, expected: p.t, input: *input}.with(scope.GetCutPoint(),
+			func() error { return fmt.Errorf("expect: %s", NewScanner(p.t.String()).Context(DefaultLimit)) },
+			func() error { return fmt.Errorf("actual: %s", getErrorStrings(input)) },
+			func() error { return stk },
+		)
+	}
+	return nil
+}
+func (p *siParser) AsTerm() Term { return p.t }
+
+func (t SI) Parser(rule Rule, c cache) Parser {
+	re := applyWrapRE(string(t), func(re string) string { return "((?i:" + regexp.QuoteMeta(re) + "))" }, c)
+	return &siParser{
+		rule: rule,
+		t:    t,
+		re:   regexp.MustCompile(`(?m)\A` + re),
+	}
+}
+
 type reParser struct {
 	rule Rule
 	t    RE
@@ -265,8 +393,8 @@ func (p *reParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *
 	if escaped, err := parseEscape(p, scope, string(p.rule), p.t, input, output); escaped || err != nil {
 		return err
 	}
-	if ok := eatRegexp(input, p.re, output); !ok {
-		return newParseError(p.rule, "")(scope.GetCutPoint(),
+	if ok := eatRegexp(scope, input, p.re, output); !ok {
+		return ParseError{rule: p.rule, expected: p.t, input: *input}.with(scope.GetCutPoint(),
 			func() error { return fmt.Errorf("expect: %s", NewScanner(p.re.String()).Context(DefaultLimit)) },
 			func() error { return fmt.Errorf("actual: %s", getErrorStrings(input)) },
 			func() error { return stk },
@@ -335,34 +463,47 @@ func nodesEqual(a, b any) bool {
 }
 
 func (p *seqParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
-	// defer enterf("%s: %T %[2]v", p.rule, p.t).exitf("%v %v", &out, output)
 	if escaped, err := parseEscape(p, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
-	result := make([]TreeElement, 0, len(p.parsers))
-	furthest := *input
+	_, result, err := p.items(scope, input, stk, 0, len(p.parsers), make([]TreeElement, 0, len(p.parsers)))
+	if err != nil {
+		return err
+	}
+	return p.put(output, nil, result...)
+}
 
-	for i, item := range p.parsers {
+// items parses the items of the sequence from index from up to to, appending
+// their values to result. It returns the scope the items left behind, which
+// a parse of the rest of the sequence would continue in.
+func (p *seqParser) items(
+	scope Scope, input *Scanner, stk *call, from, to int, result []TreeElement,
+) (Scope, []TreeElement, error) {
+	furthest := *input
+	for i, item := range p.parsers[from:to] {
+		i += from
 		var v TreeElement
 		ident := identFromTerm(p.t[i])
+		start := input.Offset()
 		if err := item.Parse(scope, input, &v, stk.push(ident, item.AsTerm())); err != nil {
 			if isFatal(err) {
-				return err
+				return scope, nil, err
 			}
 			*input = furthest
-			return newParseError(p.rule, "could not complete sequence")(scope.GetCutPoint(),
+			return scope, nil, newParseError(p.rule, input, "could not complete sequence")(scope.GetCutPoint(),
 				func() error { return err },
 				func() error { return stk },
 			)
 		}
-		if _, ok := item.(*cutPointParser); ok {
+		if isCutPoint(item) {
 			scope, _, _ = scope.ReplaceCutPoint(true)
+			scope.trace(TraceCut, p.rule, start, input.Offset(), nil)
 		}
 		scope = scope.WithVal(ident, p.parsers[i], v)
 		furthest = *input
 		result = append(result, v)
 	}
-	return p.put(output, nil, result...)
+	return scope, result, nil
 }
 func (p *seqParser) AsTerm() Term { return p.t }
 
@@ -382,6 +523,7 @@ type delimParser struct {
 	t     Delim
 	child Parser
 	put   putter
+	flat  bool // whether child parses the term and the rest of the list as items of one Seq
 }
 
 type Empty struct{}
@@ -389,7 +531,6 @@ type Empty struct{}
 func (Empty) IsTreeElement() {}
 
 func (p *delimParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
-	// defer enterf("%s: %T %[2]v", p.rule, p.t).exitf("%v %v", &out, output)
 	if escaped, err := parseEscape(p, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
@@ -403,7 +544,21 @@ func (p *delimParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 
 	var seq Node
 	var final TreeElement
-	if p.t.CanStartWithSep {
+	switch {
+	case p.flat:
+		i := 0
+		if p.t.CanStartWithSep {
+			if x := (*output).(Node).GetNode(0).Children; len(x) != 0 {
+				result = append(result, []TreeElement{Empty{}, x[0]}...)
+			}
+			i++
+		}
+		result = append(result, (*output).(Node).Get(i)) // term
+		seq = (*output).(Node).GetNode(i + 1)
+		if p.t.CanEndWithSep {
+			final = (*output).(Node).Get(i + 2)
+		}
+	case p.t.CanStartWithSep:
 		if x := (*output).(Node).GetNode(0).Children; len(x) != 0 {
 			result = append(result, []TreeElement{Empty{}, x[0]}...)
 		}
@@ -412,7 +567,7 @@ func (p *delimParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 		if p.t.CanEndWithSep {
 			final = (*output).(Node).Get(2)
 		}
-	} else {
+	default:
 		result = append(result, (*output).(Node).Get(0, 0)) // term
 		seq = (*output).(Node).GetNode(0, 1)
 		if p.t.CanEndWithSep {
@@ -421,6 +576,11 @@ func (p *delimParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 	}
 
 	for _, child := range seq.Children {
+		if e, ok := child.(ErrorNode); ok {
+			// A recovered error stands in for a sep and term pair.
+			result = append(result, e, Empty{})
+			continue
+		}
 		child := child.(Node)
 		result = append(result, child.Get(0)) // sep
 		result = append(result, child.Get(1)) // term
@@ -459,11 +619,18 @@ func (t Delim) Parser(rule Rule, c cache) Parser {
 	// a -> x:y    ===   a -> x (y x)*
 	// a -> x:,y   ===   a -> y? x (y x)*
 	// a -> x:y,   ===   a -> x (y x)* y?
+	//
+	// The nested Seq only groups the term with the rest of the list, which the
+	// delimParser takes apart again, so optimization flattens it.
 	seq := Seq{}
 	if t.CanStartWithSep {
 		seq = append(seq, Opt(t.Sep))
 	}
-	seq = append(seq, Seq{t.Term, Any(Seq{t.Sep, t.Term})})
+	if c.optimize {
+		seq = append(seq, t.Term, Any(Seq{t.Sep, t.Term}))
+	} else {
+		seq = append(seq, Seq{t.Term, Any(Seq{t.Sep, t.Term})})
+	}
 	if t.CanEndWithSep {
 		seq = append(seq, Opt(t.Sep))
 	}
@@ -473,6 +640,7 @@ func (t Delim) Parser(rule Rule, c cache) Parser {
 		t:     t,
 		child: seq.Parser(rule, c),
 		put:   tag(rule, delimTag),
+		flat:  c.optimize,
 	}
 	c.registerRule(&p.child)
 
@@ -527,6 +695,8 @@ func (l *lookaheadParser) Parse(scope Scope, input *Scanner, output *TreeElement
 	stk = stk.push(string(l.rule), l.AsTerm())
 	var v TreeElement
 	start := *input
+	start.hold()
+	defer start.unhold()
 	if err := l.term.Parse(scope, &start, &v, stk); err != nil {
 		return err
 	}
@@ -546,23 +716,211 @@ func (t LookAhead) Parser(rule Rule, c cache) Parser {
 	return p
 }
 
+type notLookaheadParser struct {
+	term Parser
+	t    NotLookAhead
+	rule Rule
+	put  putter
+}
+
+func (l *notLookaheadParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
+	if escaped, err := parseEscape(l, scope, "", nil, input, output); escaped || err != nil {
+		return err
+	}
+
+	stk = stk.push(string(l.rule), l.AsTerm())
+	var v TreeElement
+	start := *input
+	start.hold()
+	defer start.unhold()
+	err := l.term.Parse(scope, &start, &v, stk)
+	if err == nil {
+		return newParseError(l.rule, input, "unexpected %s", l.t.Term)(scope.GetCutPoint(),
+			func() error { return stk },
+		)
+	}
+	if _, ok := err.(StopError); ok {
+		return err
+	}
+	return l.put(output, nil)
+}
+
+func (l *notLookaheadParser) AsTerm() Term { return l.t }
+
+func (t NotLookAhead) Parser(rule Rule, c cache) Parser {
+	p := &notLookaheadParser{
+		rule: rule,
+		term: t.Term.Parser("", c),
+		t:    t,
+		put:  tag(rule, notAheadTag),
+	}
+	c.registerRule(&p.term)
+	return p
+}
+
 //-----------------------------------------------------------------------------
 
-type quantParser struct {
+// lookbehind matches a term against the input that ends where another term
+// starts.
+type lookbehind struct {
+	term    Parser
+	width   int  // the most bytes term can match, or -1 if unbounded
+	wrapped bool // whether term's tokens are wrapped by .wrapRE
+}
+
+func newLookbehind(t Term, c cache) lookbehind {
+	l := lookbehind{term: t.Parser("", c), width: -1}
+	_, l.wrapped = c.grammar[WrapRE]
+	if width, tokens, ok := maxWidth(t); ok && (!l.wrapped || tokens <= 1) {
+		l.width = width
+	}
+	if c.behind != nil && *c.behind >= 0 && (l.width < 0 || l.width > *c.behind) {
+		*c.behind = l.width
+	}
+	return l
+}
+
+// match tries term against the input that ends at input, starting as close
+// to it as possible. It returns the match, or nil if there is none. Only a
+// StopError is returned as an error.
+func (l *lookbehind) match(scope Scope, input *Scanner, stk *call) (TreeElement, error) {
+	end := input.sliceStart
+	from := 0
+	if src, ok := input.src.(*readerSource); ok {
+		from = src.base
+	}
+	if l.width >= 0 {
+		width := l.width
+		if l.wrapped {
+			// A single token may be followed by the text .wrapRE consumed.
+			st := scope.getParseState()
+			if st == nil {
+				width = -1
+			} else if st.matchEnd == end {
+				width += st.matchEnd - st.tokenEnd
+			}
+		}
+		if width >= 0 && end-width > from {
+			from = end - width
+		}
+	}
+	behind := Scanner{src: input.src, sliceStart: from}
+	behind.hold()
+	defer behind.unhold()
+	if st := scope.getParseState(); st != nil {
+		// Tokens matched behind the input don't precede it.
+		defer func(tokenEnd, matchEnd int) { st.tokenEnd, st.matchEnd = tokenEnd, matchEnd }(st.tokenEnd, st.matchEnd)
+	}
+	for start := end; start >= from; start-- {
+		behind := Scanner{src: input.src, sliceStart: start, sliceLength: end - start}
+		var v TreeElement
+		err := l.term.Parse(scope, &behind, &v, stk)
+		if err == nil && behind.sliceLength == 0 {
+			return v, nil
+		}
+		if _, ok := err.(StopError); ok {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+type lookbehindParser struct {
+	lookbehind
+	t    LookBehind
 	rule Rule
-	t    Quant
-	term Parser
 	put  putter
 }
 
+func (l *lookbehindParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
+	if escaped, err := parseEscape(l, scope, "", nil, input, output); escaped || err != nil {
+		return err
+	}
+
+	stk = stk.push(string(l.rule), l.AsTerm())
+	v, err := l.match(scope, input, stk)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return newParseError(l.rule, input, "expected %s before", l.t.Term)(scope.GetCutPoint(),
+			func() error { return stk },
+		)
+	}
+	return l.put(output, nil, v)
+}
+
+func (l *lookbehindParser) AsTerm() Term { return l.t }
+
+func (t LookBehind) Parser(rule Rule, c cache) Parser {
+	p := &lookbehindParser{
+		rule:       rule,
+		lookbehind: newLookbehind(t.Term, c),
+		t:          t,
+		put:        tag(rule, lookbehindTag),
+	}
+	c.registerRule(&p.term)
+	return p
+}
+
+type notLookbehindParser struct {
+	lookbehind
+	t    NotLookBehind
+	rule Rule
+	put  putter
+}
+
+func (l *notLookbehindParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
+	if escaped, err := parseEscape(l, scope, "", nil, input, output); escaped || err != nil {
+		return err
+	}
+
+	stk = stk.push(string(l.rule), l.AsTerm())
+	v, err := l.match(scope, input, stk)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		return newParseError(l.rule, input, "unexpected %s before", l.t.Term)(scope.GetCutPoint(),
+			func() error { return stk },
+		)
+	}
+	return l.put(output, nil)
+}
+
+func (l *notLookbehindParser) AsTerm() Term { return l.t }
+
+func (t NotLookBehind) Parser(rule Rule, c cache) Parser {
+	p := &notLookbehindParser{
+		rule:       rule,
+		lookbehind: newLookbehind(t.Term, c),
+		t:          t,
+		put:        tag(rule, notBehindTag),
+	}
+	c.registerRule(&p.term)
+	return p
+}
+
+//-----------------------------------------------------------------------------
+
+type quantParser struct {
+	rule   Rule
+	t      Quant
+	term   Parser
+	sync   Parser // nil unless Term contains a .sync terminal
+	put    putter
+	behind *int // see cache.behind
+}
+
 func (p *quantParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
-	// defer enterf("%s: %T %[2]v", p.rule, p.t).exitf("%v %v", &out, output)
 	if escaped, err := parseEscape(p, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
 	result := make([]TreeElement, 0, p.t.Min)
 	var v TreeElement
 	start := *input
+	input.hold()
+	defer input.unhold()
 
 	stk = stk.push(string(p.rule), p.AsTerm())
 
@@ -570,33 +928,66 @@ func (p *quantParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 	for p.t.Max == 0 || len(result) < p.t.Max {
 		if out = p.term.Parse(scope, &start, &v, stk); out != nil {
 			if isNotMyFatalError(out, mycp) {
+				start = *input
+				if v, ok := p.recover(scope, &start, out); ok {
+					result = append(result, v)
+					*input = start
+					continue
+				}
 				return out
 			}
+			scope.trace(TraceBacktrack, p.rule, input.Offset(), start.Offset(), out)
 			break
 		}
 		result = append(result, v)
 		*input = start
+		p.advance(scope, input)
 	}
 
 	if len(result) >= p.t.Min {
 		return p.put(output, nil, result...)
 	}
 
-	return newParseError(p.rule,
+	// Don't capture out itself: it is about to be set to the returned error.
+	err := out
+	return newParseError(p.rule, input,
 		"quant failed, expected: (%d, %d), have %d value(s)",
 		p.t.Min, p.t.Max, len(result),
-	)(prevcp, func() error { return out }, func() error { return stk })
+	)(prevcp, func() error { return err }, func() error { return stk })
 }
 func (p *quantParser) AsTerm() Term { return p.t }
 
+// advance lets a scanner from NewScannerFromReader release the input before an
+// item that is complete, unless something else may return to it or a
+// lookbehind may look back over it.
+func (p *quantParser) advance(scope Scope, input *Scanner) {
+	st := scope.getParseState()
+	if p.behind == nil || *p.behind < 0 || st == nil {
+		return
+	}
+	behind := *p.behind
+	if st.tokenEnd < input.sliceStart {
+		// A lookbehind also looks back over the text .wrapRE consumed.
+		behind += input.sliceStart - st.tokenEnd
+	}
+	if base := input.advance(behind); base >= 0 {
+		st.forget(base)
+	}
+}
+
 func (t Quant) Parser(rule Rule, c cache) Parser {
 	p := &quantParser{
-		rule: rule,
-		t:    t,
-		term: t.Term.Parser("", c),
-		put:  tag(rule, quantTag),
+		rule:   rule,
+		t:      t,
+		term:   t.Term.Parser("", c),
+		put:    tag(rule, quantTag),
+		behind: c.behind,
 	}
 	c.registerRule(&p.term)
+	if syncs := syncTerms(c.grammar); len(syncs) > 0 && containsSync(t.Term, c.grammar, syncs, map[Rule]bool{}) {
+		p.sync = Sync.Parser("", c)
+		c.registerRule(&p.sync)
+	}
 	return p
 }
 
@@ -607,14 +998,19 @@ type oneofParser struct {
 	t       Oneof
 	parsers []Parser
 	put     putter
+	steps   []oneofStep // nil unless optimized
 }
 
 func (p *oneofParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) (out error) {
-	// defer enterf("%s: %T %[2]v", p.rule, p.t).exitf("%v %v", &out, output)
+	if p.steps != nil && direct(scope, input) && scope.getParserEscape() == nil {
+		return p.parseSteps(scope, input, output, stk)
+	}
 	if escaped, err := parseEscape(p, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
 	furthest := *input
+	input.hold()
+	defer input.unhold()
 
 	stk = stk.push(string(p.rule), p.AsTerm())
 	scope, prevcp, mycp := scope.ReplaceCutPoint(false)
@@ -627,6 +1023,7 @@ func (p *oneofParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 				return err
 			}
 			errors = append(errors, func() error { return err })
+			scope.trace(TraceBacktrack, p.rule, input.Offset(), start.Offset(), err)
 
 			if furthest.Offset() < start.Offset() {
 				furthest = start
@@ -638,17 +1035,21 @@ func (p *oneofParser) Parse(scope Scope, input *Scanner, output *TreeElement, st
 	}
 	errors = append(errors, func() error { return stk })
 	*input = furthest
-	return newParseError(p.rule, "None of the available options could be satisfied")(prevcp, errors...)
+	return newParseError(p.rule, input, "None of the available options could be satisfied")(prevcp, errors...)
 }
 func (p *oneofParser) AsTerm() Term { return p.t }
 
 func (t Oneof) Parser(rule Rule, c cache) Parser {
-	return &oneofParser{
+	p := &oneofParser{
 		rule:    rule,
 		t:       t,
 		parsers: c.makeParsers(t),
 		put:     tag(rule, oneofTag),
 	}
+	if c.optimize && c.oneofs != nil {
+		*c.oneofs = append(*c.oneofs, p)
+	}
+	return p
 }
 
 //-----------------------------------------------------------------------------
@@ -686,6 +1087,7 @@ func (t *REF) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call)
 	if escaped, err := parseEscape(t, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
+	scope.markVolatile()
 	stk = stk.push(t.Ident, t.AsTerm())
 	var v TreeElement
 	if _, expected, ok := scope.GetVal(t.Ident); ok {
@@ -695,7 +1097,7 @@ func (t *REF) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call)
 			return err
 		}
 		if !nodesEqual(v, expected) {
-			return newParseError(Rule(t.Ident), "Backref not matched")(invalidCutpoint,
+			return newParseError(Rule(t.Ident), input, "Backref not matched")(invalidCutpoint,
 				func() error { return fmt.Errorf("expected: %s", expected) },
 				func() error { return fmt.Errorf("actual: %s", v) },
 				func() error { return stk },
@@ -706,7 +1108,7 @@ func (t *REF) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call)
 			return err
 		}
 	} else {
-		return newParseError(Rule(t.Ident), "Backref not found")(invalidCutpoint,
+		return newParseError(Rule(t.Ident), input, "Backref not found")(invalidCutpoint,
 			func() error { return stk },
 		)
 	}
@@ -723,13 +1125,16 @@ func (t ExtRef) Parse(scope Scope, input *Scanner, output *TreeElement, stk *cal
 	if escaped, err := parseEscape(t, scope, "", nil, input, output); escaped || err != nil {
 		return err
 	}
+	scope.markVolatile()
 	fn := scope.GetExternal(string(t))
 	if fn == nil {
-		return newParseError(Rule(string(t)), "External handler not found")(Cutpointdata(1),
+		return newParseError(Rule(string(t)), input, "External handler not found")(Cutpointdata(1),
 			func() error { return stk.push(string(t), t.AsTerm()) },
 		)
 	}
+	start := input.Offset()
 	*output, out = fn(scope, input)
+	scope.trace(TraceExternal, Rule(t), start, input.Offset(), out)
 	return out
 }
 
@@ -741,16 +1146,12 @@ func (t ExtRef) AsTerm() Term { return t }
 //-----------------------------------------------------------------------------
 
 func (t ScopedGrammar) Parser(name Rule, c cache) Parser {
-	for _, term := range t.Grammar {
-		if _, ok := term.(Stack); ok {
-			t.Grammar = t.Grammar.clone()
-			t.Grammar.resolveStacks()
-			break
-		}
-	}
-	if wrap, has := c.grammar[WrapRE]; has {
-		if _, has := t.Grammar[WrapRE]; !has {
-			t.Grammar[WrapRE] = wrap
+	t.Grammar = t.Grammar.withoutStacks()
+	for _, magic := range []Rule{WrapRE, Sync} {
+		if term, has := c.grammar[magic]; has {
+			if _, has := t.Grammar[magic]; !has {
+				t.Grammar[magic] = term
+			}
 		}
 	}
 
@@ -758,7 +1159,13 @@ func (t ScopedGrammar) Parser(name Rule, c cache) Parser {
 		parsers:    map[Rule]Parser{},
 		grammar:    t.Grammar,
 		rulePtrses: map[Rule][]*Parser{},
+		tokenizers: map[Rule]*tokenizer{},
+		optimize:   c.optimize,
+		oneofs:     c.oneofs,
+		unbuilt:    c.unbuilt,
+		behind:     c.behind,
 	}
+	leftRec := leftRecursion(t.Grammar, c.grammar)
 	for rule, term := range t.Grammar {
 		for {
 			switch r := term.(type) {
@@ -768,7 +1175,7 @@ func (t ScopedGrammar) Parser(name Rule, c cache) Parser {
 			}
 			break
 		}
-		cc.parsers[rule] = term.Parser(rule, cc)
+		cc.parsers[rule] = &entryParser{rule: rule, t: term, p: term.Parser(rule, cc), leftRec: leftRec[rule]}
 	}
 
 	// At this point we have the nested grammar cache populated with the grammar rules
@@ -779,10 +1186,7 @@ func (t ScopedGrammar) Parser(name Rule, c cache) Parser {
 	for rule, rulePtrs := range cc.rulePtrses {
 		if _, has := t.Grammar[rule]; has {
 			// local rule, simply hook up the pointers
-			p := cc.parsers[rule]
-			for _, rulePtr := range rulePtrs {
-				*rulePtr = p
-			}
+			cc.link(cc.parsers[rule], rulePtrs)
 		} else {
 			// must be from the previous scope, add it to the previous scopes cache
 			for _, rulePtr := range rulePtrs {
@@ -802,6 +1206,17 @@ func (t *cutPointParser) Parse(scope Scope, input *Scanner, output *TreeElement,
 	return t.p.Parse(scope, input, output, stk)
 }
 func (t *cutPointParser) AsTerm() Term { return t.t }
+
+func isCutPoint(p Parser) bool {
+	if e, ok := p.(*entryParser); ok {
+		p = e.p
+	}
+	_, ok := p.(*cutPointParser)
+	return ok
+}
+
 func (t CutPoint) Parser(rule Rule, c cache) Parser {
-	return &cutPointParser{t.Term.Parser(rule, c), t}
+	p := &cutPointParser{t.Term.Parser(rule, c), t}
+	c.registerRule(&p.p)
+	return p
 }
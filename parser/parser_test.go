@@ -1,9 +1,11 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // These test all verify the parser behaviour when the tested Term fails when inside a CutPoint scope
@@ -134,3 +136,28 @@ func TestParserEscaping2LevelGrammar(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expected.(Node).String(), actual.(Node).String())
 }
+
+func TestCaseInsensitiveString(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a":       Seq{SI("select"), RE(`\w+`)},
+		".wrapRE": RE(`\s*()\s*`),
+	}
+	p := g.Compile(nil)
+
+	for _, input := range []string{"select x", "SELECT x", "SeLeCt x"} {
+		e, err := p.Parse("a", NewScanner(input))
+		require.NoError(t, err, "%q", input)
+		assert.Equal(t, input, e.(Node).Children[0].(Scanner).String()+" x")
+	}
+	_, err := p.Parse("a", NewScanner("selec x"))
+	assert.Error(t, err)
+
+	assert.Equal(t, `i"select"`, SI("select").String())
+
+	// A hand-built tree without the token gets the string as written.
+	var sb strings.Builder
+	_, err = g.UnparsePretty(Node{Tag: "a", Children: []TreeElement{nil, *NewScanner("x")}}, &sb)
+	require.NoError(t, err)
+	assert.Equal(t, "select x", sb.String())
+}
@@ -8,8 +8,9 @@ type TreeElement interface {
 	IsTreeElement()
 }
 
-func (Node) IsTreeElement()    {}
-func (Scanner) IsTreeElement() {}
+func (Node) IsTreeElement()      {}
+func (Scanner) IsTreeElement()   {}
+func (ErrorNode) IsTreeElement() {}
 
 type Extra interface {
 	IsExtra()
@@ -84,6 +85,17 @@ func (n Node) Format(state fmt.State, c rune) {
 	fmt.Fprint(state, "]")
 }
 
+// ErrorNode stands in for input that was skipped while recovering from a
+// parse error. See WithRecovery.
+type ErrorNode struct {
+	Err     error
+	Skipped Scanner
+}
+
+func (e ErrorNode) String() string {
+	return fmt.Sprintf("error[%q]", e.Skipped.String())
+}
+
 type Parser interface {
 	Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) error
 	AsTerm() Term
@@ -0,0 +1,159 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PositionProp names a property of a position in the input.
+type PositionProp string
+
+const (
+	// PosCol is the column, counting from 0 at the start of the line. Tabs
+	// advance it to the next multiple of 8.
+	PosCol PositionProp = "col"
+
+	// PosLine is the line number, counting from 1.
+	PosLine PositionProp = "line"
+
+	// PosOffset is the offset in bytes from the start of the input.
+	PosOffset PositionProp = "offset"
+)
+
+const tabWidth = 8
+
+// prop returns the property of the position of s. Lines and offsets depend on
+// all the input before s, and columns on the line s is in, back to the newline
+// before it.
+func (p PositionProp) prop(s Scanner) int {
+	switch p {
+	case PosLine:
+		lookBack(s.src, 0)
+		line, _ := s.src.line(s.sliceStart)
+		return line
+	case PosOffset:
+		lookBack(s.src, 0)
+		return s.sliceStart
+	}
+	_, start := s.src.line(s.sliceStart)
+	if start > 0 {
+		lookBack(s.src, start-1)
+	}
+	col := 0
+	for _, r := range s.src.slice(start, s.sliceStart-start) {
+		if r == '\t' {
+			col = (col/tabWidth + 1) * tabWidth
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+func comparePositions(a int, op string, b int) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	panic(fmt.Errorf("unknown position comparison: %q", op))
+}
+
+// firstToken returns the first token of a tree.
+func firstToken(e TreeElement) (Scanner, bool) {
+	switch e := e.(type) {
+	case Scanner:
+		return e, true
+	case Node:
+		for _, child := range e.Children {
+			if s, ok := firstToken(child); ok {
+				return s, true
+			}
+		}
+	}
+	return Scanner{}, false
+}
+
+type positionParser struct {
+	rule Rule
+	t    Position
+	pre  *regexp.Regexp // the text .wrapRE consumes before a token, if any
+}
+
+func (p *positionParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	if escaped, err := parseEscape(p, scope, "", nil, input, output); escaped || err != nil {
+		return err
+	}
+	// Positions are measured where the next token would start.
+	at := input.Slice(0, 0)
+	if p.pre != nil {
+		if loc := input.match(p.pre); loc != nil {
+			at = input.Slice(loc[1], loc[1])
+		}
+	}
+	if p.t.Op != "" {
+		want := p.t.Value
+		if p.t.Ref != "" {
+			scope.markVolatile()
+			_, ref, ok := scope.GetVal(p.t.Ref)
+			if !ok {
+				return newParseError(Rule(p.t.Ref), input, "Position ref not found")(invalidCutpoint,
+					func() error { return stk },
+				)
+			}
+			token, ok := firstToken(ref)
+			if !ok {
+				return newParseError(Rule(p.t.Ref), input, "Position ref has no position")(invalidCutpoint,
+					func() error { return stk },
+				)
+			}
+			want = p.t.Prop.prop(token)
+		}
+		if got := p.t.Prop.prop(*at); !comparePositions(got, p.t.Op, want) {
+			return newParseError(p.rule, at, "expected @%s %s %d, got %d", p.t.Prop, p.t.Op, want, got)(
+				scope.GetCutPoint(),
+				func() error { return stk },
+			)
+		}
+	}
+	*output = *at
+	return nil
+}
+
+func (p *positionParser) AsTerm() Term { return p.t }
+
+func (t Position) Parser(rule Rule, c cache) Parser {
+	p := &positionParser{rule: rule, t: t}
+	if pre, _, ok := wrapParts(c.grammar); ok && pre != "" {
+		p.pre = regexp.MustCompile(`(?m)\A(?:` + pre + `)`)
+	}
+	return p
+}
+
+// wrapParts returns the parts of the grammar's .wrapRE before and after the
+// token.
+func wrapParts(g Grammar) (pre, post string, ok bool) {
+	wrap, has := g[WrapRE]
+	if oneof, ok := wrap.(Oneof); ok {
+		wrap = oneof[len(oneof)-1]
+	}
+	re, ok := wrap.(RE)
+	if !has || !ok {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(re), "()", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionProps(t *testing.T) {
+	t.Parallel()
+	s := NewScanner("ab\n\t é\tx")
+	for _, test := range []struct {
+		offset, col, line int
+	}{
+		{0, 0, 1},
+		{2, 2, 1},
+		{3, 0, 2},
+		{4, 8, 2},
+		{5, 9, 2},
+		{7, 10, 2},
+		{8, 16, 2},
+	} {
+		at := s.Slice(test.offset, test.offset)
+		assert.Equal(t, test.col, PosCol.prop(*at), "%d", test.offset)
+		assert.Equal(t, test.line, PosLine.prop(*at), "%d", test.offset)
+		assert.Equal(t, test.offset, PosOffset.prop(*at), "%d", test.offset)
+	}
+}
+
+func TestPositionTerm(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a":       Seq{Eq("x", Position{Prop: PosCol}), RE(`\w+`), RE(`\n`), Position{Prop: PosCol, Op: "<", Ref: "x"}, RE(`\w+`)},
+		".wrapRE": RE(`[ ]*()[ ]*`),
+	}.Compile(nil)
+
+	e, err := g.Parse("a", NewScanner("  a\n b"))
+	require.NoError(t, err)
+	assert.Equal(t, "  a\n b", unparseString(t, lossless(g.Grammar()), e))
+	assert.Equal(t, 2, e.(Node).Children[0].(Scanner).Offset())
+
+	_, err = g.Parse("a", NewScanner("  a\n  b"))
+	assert.Error(t, err)
+
+	assert.Equal(t, "@col", Position{Prop: PosCol}.String())
+	assert.Equal(t, "@line(>=2)", Position{Prop: PosLine, Op: ">=", Value: 2}.String())
+	assert.Equal(t, "@offset(!=x)", Position{Prop: PosOffset, Op: "!=", Ref: "x"}.String())
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the profile to w in the gzipped protocol buffer format
+// read by `go tool pprof`. Each rule appears as a function, so the call graph
+// shows which rules were entered from which. Samples count calls and the time
+// spent in each rule excluding nested rules.
+func (p *Profile) WritePprof(w io.Writer) error {
+	var b pprofBuilder
+	b.str("") // The string table starts with the empty string.
+
+	// message ValueType { int64 type = 1; int64 unit = 2; }
+	for _, vt := range [][2]string{{"calls", "count"}, {"time", "nanoseconds"}} {
+		var m protoBuf
+		m.varint(1, uint64(b.str(vt[0])))
+		m.varint(2, uint64(b.str(vt[1])))
+		b.out.bytes(1, m)
+	}
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.samples[key]
+		// message Sample { repeated uint64 location_id = 1; repeated int64 value = 2; }
+		var locs, values, m protoBuf
+		for i := len(s.stack) - 1; i >= 0; i-- {
+			locs.rawVarint(b.location(s.stack[i]))
+		}
+		values.rawVarint(uint64(s.calls))
+		values.rawVarint(uint64(s.self))
+		m.bytes(1, locs)
+		m.bytes(2, values)
+		b.out.bytes(2, m)
+	}
+
+	// Locations and functions share ids, one per rule.
+	for i := range b.rules {
+		id := uint64(i + 1)
+		// message Line { uint64 function_id = 1; }
+		// message Location { uint64 id = 1; repeated Line line = 4; }
+		var line, loc protoBuf
+		line.varint(1, id)
+		loc.varint(1, id)
+		loc.bytes(4, line)
+		b.out.bytes(4, loc)
+	}
+	for i, name := range b.rules {
+		// message Function { uint64 id = 1; int64 name = 2; int64 system_name = 3; }
+		var fn protoBuf
+		fn.varint(1, uint64(i+1))
+		fn.varint(2, uint64(b.str(name)))
+		fn.varint(3, uint64(b.str(name)))
+		b.out.bytes(5, fn)
+	}
+	for _, s := range b.strs {
+		b.out.bytes(6, protoBuf(s))
+	}
+	b.out.varint(10, uint64(p.elapsed)) // duration_nanos
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.out); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type pprofBuilder struct {
+	out     protoBuf
+	strs    []string
+	strIDs  map[string]int
+	rules   []string
+	ruleIDs map[Rule]uint64
+}
+
+// str returns the index of s in the string table, adding it if need be.
+func (b *pprofBuilder) str(s string) int {
+	if b.strIDs == nil {
+		b.strIDs = map[string]int{}
+	}
+	id, has := b.strIDs[s]
+	if !has {
+		id = len(b.strs)
+		b.strs = append(b.strs, s)
+		b.strIDs[s] = id
+	}
+	return id
+}
+
+// location returns the id of the location of r, adding it if need be.
+func (b *pprofBuilder) location(r Rule) uint64 {
+	if b.ruleIDs == nil {
+		b.ruleIDs = map[Rule]uint64{}
+	}
+	id, has := b.ruleIDs[r]
+	if !has {
+		b.rules = append(b.rules, displayRule(r))
+		id = uint64(len(b.rules))
+		b.ruleIDs[r] = id
+	}
+	return id
+}
+
+// protoBuf accumulates an encoded protocol buffer message.
+type protoBuf []byte
+
+func (m *protoBuf) rawVarint(v uint64) {
+	for v >= 0x80 {
+		*m = append(*m, byte(v)|0x80)
+		v >>= 7
+	}
+	*m = append(*m, byte(v))
+}
+
+func (m *protoBuf) varint(field int, v uint64) {
+	m.rawVarint(uint64(field) << 3)
+	m.rawVarint(v)
+}
+
+func (m *protoBuf) bytes(field int, v []byte) {
+	m.rawVarint(uint64(field)<<3 | 2)
+	m.rawVarint(uint64(len(v)))
+	*m = append(*m, v...)
+}
@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RuleProfile holds the statistics gathered by a Profile for one rule.
+type RuleProfile struct {
+	Rule        Rule
+	Calls       int           // the number of times the rule was entered
+	Successes   int           // the number of calls that matched
+	Failures    int           // the number of calls that failed
+	Backtracked int           // the number of bytes matched and then given up within the rule
+	Time        time.Duration // the time spent in calls, including nested rules
+	SelfTime    time.Duration // the time spent in calls, excluding nested rules
+}
+
+// Profile is a Tracer that gathers statistics per rule. Pass it to a parse
+// via WithTracer, then read them with Rules, Report or WritePprof.
+type Profile struct {
+	rules   map[Rule]*RuleProfile
+	active  map[Rule]int // how many calls to each rule are in progress
+	frames  []profileFrame
+	samples map[string]*profileSample // keyed by the rules of the stack, innermost last
+	elapsed time.Duration
+	now     func() time.Time
+}
+
+type profileFrame struct {
+	rule  Rule
+	start time.Time
+	inner time.Duration // the time spent in nested rules
+}
+
+type profileSample struct {
+	stack []Rule // innermost last
+	calls int
+	self  time.Duration
+}
+
+func NewProfile() *Profile {
+	return &Profile{
+		rules:   map[Rule]*RuleProfile{},
+		active:  map[Rule]int{},
+		samples: map[string]*profileSample{},
+		now:     time.Now,
+	}
+}
+
+func (p *Profile) rule(r Rule) *RuleProfile {
+	rp, has := p.rules[r]
+	if !has {
+		rp = &RuleProfile{Rule: r}
+		p.rules[r] = rp
+	}
+	return rp
+}
+
+func (p *Profile) Trace(e TraceEvent) {
+	switch e.Kind {
+	case TraceEnter:
+		p.rule(e.Rule).Calls++
+		p.active[e.Rule]++
+		p.frames = append(p.frames, profileFrame{rule: e.Rule, start: p.now()})
+	case TraceExit:
+		if len(p.frames) == 0 {
+			return
+		}
+		f := p.frames[len(p.frames)-1]
+		elapsed := p.now().Sub(f.start)
+		rp := p.rule(f.rule)
+		if e.Err == nil {
+			rp.Successes++
+		} else {
+			rp.Failures++
+		}
+		rp.SelfTime += elapsed - f.inner
+		p.active[f.rule]--
+		if p.active[f.rule] == 0 {
+			// Only the outermost call of a recursive rule counts towards its
+			// time, so that time isn't counted twice.
+			rp.Time += elapsed
+		}
+		p.sample(elapsed - f.inner)
+		p.frames = p.frames[:len(p.frames)-1]
+		if n := len(p.frames); n > 0 {
+			p.frames[n-1].inner += elapsed
+		} else {
+			p.elapsed += elapsed
+		}
+	case TraceBacktrack:
+		p.rule(e.Rule).Backtracked += e.End - e.Start
+	}
+}
+
+// sample records the innermost call in progress against the stack of rules it
+// was made from.
+func (p *Profile) sample(self time.Duration) {
+	stack := make([]Rule, 0, len(p.frames))
+	parts := make([]string, 0, len(p.frames))
+	for _, f := range p.frames {
+		stack = append(stack, f.rule)
+		parts = append(parts, string(f.rule))
+	}
+	key := strings.Join(parts, "\x00")
+	s, has := p.samples[key]
+	if !has {
+		s = &profileSample{stack: stack}
+		p.samples[key] = s
+	}
+	s.calls++
+	s.self += self
+}
+
+// Rules returns the statistics of every rule entered, most SelfTime first.
+func (p *Profile) Rules() []RuleProfile {
+	rules := make([]RuleProfile, 0, len(p.rules))
+	for _, rp := range p.rules {
+		rules = append(rules, *rp)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.SelfTime != b.SelfTime {
+			return a.SelfTime > b.SelfTime
+		}
+		if a.Time != b.Time {
+			return a.Time > b.Time
+		}
+		return a.Rule < b.Rule
+	})
+	return rules
+}
+
+// Report writes a table of the statistics of every rule to w, most SelfTime
+// first.
+func (p *Profile) Report(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "self\tself%\ttime\tcalls\tsuccesses\tfailures\tbacktracked\trule")
+	for _, rp := range p.Rules() {
+		percent := 0.0
+		if p.elapsed > 0 {
+			percent = 100 * float64(rp.SelfTime) / float64(p.elapsed)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%s\t%d\t%d\t%d\t%d\t%s\n",
+			rp.SelfTime.Round(time.Microsecond), percent, rp.Time.Round(time.Microsecond),
+			rp.Calls, rp.Successes, rp.Failures, rp.Backtracked, displayRule(rp.Rule))
+	}
+	return tw.Flush()
+}
+
+func displayRule(r Rule) string {
+	if r == "" {
+		return "<anonymous>"
+	}
+	return string(r)
+}
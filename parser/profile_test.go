@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// profileOf profiles a parse with a clock that ticks a millisecond per reading.
+func profileOf(t *testing.T, g Parsers, rule Rule, input string) *Profile {
+	t.Helper()
+	p := NewProfile()
+	var clock time.Time
+	p.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	_, err := g.Parse(rule, NewScanner(input), WithTracer(p))
+	require.NoError(t, err)
+	return p
+}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+	p := profileOf(t, traceGrammar, "stmt", "let a = b")
+	ms := time.Millisecond
+	assert.Equal(t, []RuleProfile{
+		{Rule: "stmt", Calls: 1, Successes: 1, Time: 7 * ms, SelfTime: 3 * ms},
+		{Rule: "value", Calls: 1, Successes: 1, Time: 3 * ms, SelfTime: 2 * ms},
+		{Rule: "name", Calls: 2, Successes: 2, Time: 2 * ms, SelfTime: 2 * ms},
+	}, p.Rules())
+
+	var sb strings.Builder
+	require.NoError(t, p.Report(&sb))
+	assert.Equal(t, `self  self%  time  calls  successes  failures  backtracked  rule
+3ms   42.9%  7ms   1      1          0         0            stmt
+2ms   28.6%  3ms   1      1          0         0            value
+2ms   28.6%  2ms   2      2          0         0            name
+`, sb.String())
+}
+
+func TestProfileBacktracking(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a": Oneof{Seq{S("("), Rule("a"), S(")")}, Rule("b")},
+		"b": Oneof{Seq{S("x"), S("y")}, Seq{S("x"), S("z")}},
+	}.Compile(nil)
+	p := profileOf(t, g, "a", "((xz))")
+	rules := p.Rules()
+	require.Len(t, rules, 2)
+
+	a := rules[0]
+	assert.Equal(t, Rule("a"), a.Rule)
+	assert.Equal(t, 3, a.Calls)
+	assert.Equal(t, 3, a.Successes)
+	// Recursive calls aren't counted twice.
+	assert.Equal(t, p.elapsed, a.Time)
+
+	b := rules[1]
+	assert.Equal(t, Rule("b"), b.Rule)
+	assert.Equal(t, 1, b.Calls)
+	assert.Equal(t, 1, b.Backtracked)
+}
+
+func TestProfileWritePprof(t *testing.T) {
+	t.Parallel()
+	p := profileOf(t, traceGrammar, "stmt", "let a = b")
+	var buf bytes.Buffer
+	require.NoError(t, p.WritePprof(&buf))
+	r, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	for _, s := range []string{"calls", "count", "time", "nanoseconds", "stmt", "value", "name"} {
+		assert.Contains(t, string(data), s)
+	}
+}
@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// readerChunkSize is the amount of input requested from a reader at a time.
+const readerChunkSize = 64 << 10
+
+// unbounded is the length of a scanner over a reader, whose actual length isn't
+// known until the reader is exhausted.
+const unbounded = math.MaxInt >> 1
+
+// readerSource is a source that draws its input from an io.Reader on demand.
+// Only a window of the input is held in memory. The window grows as the parser
+// reads ahead and is trimmed by release once the parser can no longer backtrack
+// to the input before a given offset. Line starts are recorded as input is read
+// so positions can be reported for input that has since been released.
+type readerSource struct {
+	r     io.Reader
+	f     string
+	buf   []byte // the window of input currently held
+	base  int    // the offset of buf[0] within the input
+	err   error  // the error that ended reading; io.EOF at the end of input
+	lines []int  // the offset of the start of every line after the first
+	holds []int  // the offsets the parser may still return to, innermost last
+}
+
+// NewScannerFromReader returns a scanner that reads its input from r as it is
+// needed, rather than requiring the whole input up front.
+//
+// Each time a repetition completes an item, the input before the earliest
+// offset that an open choice, repetition or lookaround may still return to is
+// released, less what the grammar's lookbehinds may look back over. Tokens in
+// the tree keep their own text, but a scanner spanning released input, such as
+// one from MergeScanners, only has the text still in the window.
+func NewScannerFromReader(r io.Reader, filename string) *Scanner {
+	return &Scanner{src: &readerSource{r: r, f: filename}, sliceLength: unbounded}
+}
+
+// fill reads from the reader until the input up to end is in the window or the
+// reader is exhausted. It reports whether the input up to end is available.
+func (s *readerSource) fill(end int) bool {
+	for s.err == nil && s.base+len(s.buf) < end {
+		n := len(s.buf)
+		if cap(s.buf)-n < readerChunkSize {
+			buf := make([]byte, n, 2*cap(s.buf)+readerChunkSize)
+			copy(buf, s.buf)
+			s.buf = buf
+		}
+		m, err := s.r.Read(s.buf[n : n+readerChunkSize])
+		s.buf = s.buf[:n+m]
+		s.lines = lineStarts(string(s.buf[n:]), s.base+n, s.lines)
+		s.err = err
+	}
+	return s.base+len(s.buf) >= end
+}
+
+// release drops the input before offset i from the window.
+func (s *readerSource) release(i int) {
+	if i <= s.base {
+		return
+	}
+	if end := s.base + len(s.buf); i > end {
+		i = end
+	}
+	s.buf = s.buf[:copy(s.buf, s.buf[i-s.base:])]
+	s.base = i
+}
+
+// hold records that the parser may return to offset i, until the matching
+// unhold.
+func (s *readerSource) hold(i int) {
+	s.holds = append(s.holds, i)
+}
+
+func (s *readerSource) unhold() {
+	s.holds = s.holds[:len(s.holds)-1]
+}
+
+// advance moves the innermost hold up to offset i and releases the input
+// before the earliest hold, less behind bytes. It returns the new start of
+// the window.
+func (s *readerSource) advance(i, behind int) int {
+	s.holds[len(s.holds)-1] = i
+	for _, hold := range s.holds {
+		if hold < i {
+			i = hold
+		}
+	}
+	s.release(i - behind)
+	return s.base
+}
+
+// buffered returns what the window holds from offset i, without reading more.
+func (s *readerSource) buffered(i int) string {
+	if i < s.base || i > s.base+len(s.buf) {
+		return ""
+	}
+	return string(s.buf[i-s.base:])
+}
+
+func (s *readerSource) length() int {
+	s.fill(unbounded)
+	return s.base + len(s.buf)
+}
+
+// slice returns the part of the given slice that is still in the window.
+func (s *readerSource) slice(i, length int) string {
+	end := i + length
+	s.fill(end)
+	if i < s.base {
+		i = s.base
+	}
+	if avail := s.base + len(s.buf); end > avail {
+		end = avail
+	}
+	if i >= end {
+		return ""
+	}
+	return string(s.buf[i-s.base : end-s.base])
+}
+
+func (s *readerSource) filename() string {
+	return s.f
+}
+
+func (s *readerSource) stripSource(i, length int) source {
+	return newStringSource(s.slice(i, length), s.f)
+}
+
+func (s *readerSource) line(i int) (line, start int) {
+	s.fill(i)
+	line, start = lineAt(s.lines, i)
+	return line + 1, start
+}
+
+func (s *readerSource) context(start, end, limitLines int) (above, below string) {
+	from := s.base
+	if limitLines != NoLimit {
+		s.fill(start)
+		if n := sort.SearchInts(s.lines, start+1) - limitLines; n > 0 && s.lines[n-1] > from {
+			from = s.lines[n-1]
+		}
+	}
+	above = s.slice(from, start-from)
+
+	switch limitLines {
+	case NoLimit:
+		return above, s.slice(end, unbounded)
+	case 0:
+		return above, ""
+	}
+	s.fill(end)
+	k := sort.SearchInts(s.lines, end+1) + limitLines - 1
+	for len(s.lines) <= k && s.err == nil {
+		s.fill(s.base + len(s.buf) + 1)
+	}
+	if k < len(s.lines) {
+		return above, s.slice(end, s.lines[k]-1-end)
+	}
+	return above, s.slice(end, unbounded)
+}
+
+// runeReader returns an io.RuneReader over the given slice of the input.
+func (s *readerSource) runeReader(i, length int) io.RuneReader {
+	return &readerSourceRunes{s, i, i + length}
+}
+
+type readerSourceRunes struct {
+	src      *readerSource
+	off, end int
+}
+
+func (r *readerSourceRunes) ReadRune() (rune, int, error) {
+	if r.off >= r.end || r.off < r.src.base || !r.src.fill(r.off+1) {
+		// Released input is as good as gone.
+		return 0, 0, io.EOF
+	}
+	r.src.fill(r.off + utf8.UTFMax)
+	end := r.src.base + len(r.src.buf)
+	if end > r.end {
+		end = r.end
+	}
+	c, n := utf8.DecodeRune(r.src.buf[r.off-r.src.base : end-r.src.base])
+	r.off += n
+	return c, n, nil
+}
+
+// heldSource is the text of a token read from a readerSource, which the token
+// keeps so that it outlives the window. Anything beyond the text is answered
+// by the readerSource.
+type heldSource struct {
+	src  *readerSource
+	at   int // the offset of text within the input
+	text string
+}
+
+func (s heldSource) length() int {
+	return s.src.length()
+}
+
+func (s heldSource) slice(i, length int) string {
+	if i >= s.at && i+length <= s.at+len(s.text) {
+		return s.text[i-s.at : i-s.at+length]
+	}
+	return s.src.slice(i, length)
+}
+
+func (s heldSource) buffered(i int) string {
+	if i >= s.at && i <= s.at+len(s.text) {
+		if rest := s.src.buffered(s.at + len(s.text)); rest != "" {
+			return s.text[i-s.at:] + rest
+		}
+		return s.text[i-s.at:]
+	}
+	return s.src.buffered(i)
+}
+
+func (s heldSource) filename() string {
+	return s.src.f
+}
+
+func (s heldSource) stripSource(i, length int) source {
+	return newStringSource(s.slice(i, length), s.src.f)
+}
+
+func (s heldSource) line(i int) (line, start int) {
+	return s.src.line(i)
+}
+
+func (s heldSource) context(start, end, limitLines int) (above, below string) {
+	return s.src.context(start, end, limitLines)
+}
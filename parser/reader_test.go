@@ -0,0 +1,204 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var logGrammar = Grammar{
+	"log":     Any(Rule("entry")),
+	"entry":   Seq{CutPoint{RE(`\d+`)}, Rule("level"), RE(`[^\n]*\n`)},
+	"level":   Oneof{S("INFO"), S("WARN"), S("ERROR")},
+	".wrapRE": RE(`[ \t]*()`),
+}.Compile(nil)
+
+func TestScannerFromReaderMatchesString(t *testing.T) {
+	t.Parallel()
+	input := "1 INFO starting\n2 WARN héllo wörld\n3 ERROR stopping\n"
+
+	expected, err := logGrammar.Parse("log", NewScanner(input))
+	require.NoError(t, err)
+	actual, err := logGrammar.Parse("log",
+		NewScannerFromReader(iotest.OneByteReader(strings.NewReader(input)), "app.log"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(expected), fmt.Sprint(actual))
+	assert.Equal(t, "app.log", actual.(Node).Children[0].(Node).Children[2].(Scanner).Filename())
+}
+
+func TestScannerFromReaderUnconsumed(t *testing.T) {
+	t.Parallel()
+	_, err := logGrammar.Parse("log", NewScannerFromReader(strings.NewReader("1 INFO a\nxyz\n"), ""))
+	require.IsType(t, UnconsumedInputError{}, err)
+	residue := err.(UnconsumedInputError).Residue()
+	line, col := residue.Position()
+	assert.Equal(t, 2, line)
+	assert.Equal(t, 1, col)
+	assert.Equal(t, "xyz\n", residue.String())
+}
+
+func TestScannerFromReaderPosition(t *testing.T) {
+	t.Parallel()
+	scanner := NewScannerFromReader(strings.NewReader("one\ntwo\nthree\nfour"), "")
+	assertLineColumn(t, scanner, 1, 1)
+
+	eaten := Scanner{}
+	scanner.Eat(4, &eaten)
+	assertLineColumn(t, &eaten, 1, 1)
+	assertLineColumn(t, scanner, 2, 1)
+
+	scanner.release()
+	scanner.Eat(12, &eaten)
+	assertLineColumn(t, &eaten, 2, 1)
+	assertLineColumn(t, scanner, 4, 3)
+
+	// Released input is no longer available, but its position is.
+	scanner.release()
+	assert.Equal(t, "", eaten.String())
+	assertLineColumn(t, &eaten, 2, 1)
+	assert.Equal(t, "ur", scanner.String())
+}
+
+func TestScannerFromReaderContext(t *testing.T) {
+	t.Parallel()
+	input := "one\ntwo\nthree\nfour\nfive"
+	for _, limit := range []int{NoLimit, 0, 1, 2, 5} {
+		expected := NewScannerAt(input, 9, 3).Context(limit)
+		scanner := NewScannerFromReader(strings.NewReader(input), "")
+		actual := scanner.Slice(9, 12).Context(limit)
+		assert.Equal(t, expected, actual, "limit %d", limit)
+	}
+}
+
+// logInput returns the given number of log entries.
+func logInput(entries int) string {
+	var sb strings.Builder
+	for i := 0; i < entries; i++ {
+		fmt.Fprintf(&sb, "%d INFO entry number %d\n", i, i)
+	}
+	return sb.String()
+}
+
+func TestScannerFromReaderSlides(t *testing.T) {
+	t.Parallel()
+	const entries = 20000
+	input := logInput(entries)
+	scanner := NewScannerFromReader(strings.NewReader(input), "")
+	src := scanner.src.(*readerSource)
+
+	v, err := logGrammar.Parse("log", scanner)
+	require.NoError(t, err)
+	assert.Greater(t, len(input), 4*readerChunkSize)
+	assert.Less(t, cap(src.buf), 4*readerChunkSize)
+
+	// The tree keeps the text and position of its tokens.
+	items := v.(Node).Children
+	require.Len(t, items, entries)
+	first := items[0].(Node).Children[2].(Scanner)
+	assert.Equal(t, "entry number 0\n", first.String())
+	line, col := first.Position()
+	assert.Equal(t, 1, line)
+	assert.Equal(t, 8, col)
+	assert.Equal(t, fmt.Sprint(entries-1), items[entries-1].(Node).Children[0].(Scanner).String())
+}
+
+func TestScannerFromReaderSlidesWithinHolds(t *testing.T) {
+	t.Parallel()
+	const entries = 20000
+	input := logInput(entries) + "END"
+	// The first alternative fails after reading every entry, so the second
+	// must find them still in the window.
+	g := Grammar{
+		"doc":     Oneof{Seq{Rule("log"), S("STOP")}, Seq{Rule("entries"), S("END")}},
+		"log":     Any(Rule("entry")),
+		"entries": Any(Rule("entry")),
+		"entry":   Seq{RE(`\d+`), Rule("level"), RE(`[^\n]*\n`)},
+		"level":   Oneof{S("INFO"), S("WARN"), S("ERROR")},
+		".wrapRE": RE(`[ \t]*()`),
+	}.Compile(nil)
+	v, err := g.Parse("doc", NewScannerFromReader(strings.NewReader(input), ""))
+	require.NoError(t, err)
+	assert.Equal(t, Choice(1), v.(Node).Extra)
+}
+
+func TestScannerFromReaderSlidesBehindLookbehinds(t *testing.T) {
+	t.Parallel()
+	const entries = 20000
+	input := logInput(entries)
+	g := Grammar{
+		"log":   Any(Rule("entry")),
+		"entry": Seq{NotLookBehind{Term: S("INFO")}, RE(`\d+ `), Rule("level"), RE(`[^\n]*\n`)},
+		"level": Seq{LookBehind{Term: RE(`\d{1,5} `)}, Oneof{S("INFO"), S("WARN"), S("ERROR")}},
+	}.Compile(nil)
+	scanner := NewScannerFromReader(strings.NewReader(input), "")
+	src := scanner.src.(*readerSource)
+	_, err := g.Parse("log", scanner)
+	require.NoError(t, err)
+	assert.Less(t, cap(src.buf), 4*readerChunkSize)
+
+	// An unbounded lookbehind may look back over the whole input.
+	g = Grammar{
+		"log":   Any(Rule("entry")),
+		"entry": Seq{RE(`\d+ `), LookBehind{Term: RE(`\d+ `)}, RE(`[^\n]*\n`)},
+	}.Compile(nil)
+	scanner = NewScannerFromReader(strings.NewReader(input), "")
+	src = scanner.src.(*readerSource)
+	_, err = g.Parse("log", scanner)
+	require.NoError(t, err)
+	assert.Equal(t, 0, src.base)
+}
+
+func TestParseStream(t *testing.T) {
+	t.Parallel()
+	const entries = 20000
+	text := logInput(entries)
+	input := NewScannerFromReader(strings.NewReader(text), "")
+	src := input.src.(*readerSource)
+
+	n := 0
+	require.NoError(t, logGrammar.ParseStream("entry", input, func(e TreeElement) error {
+		assert.Equal(t, fmt.Sprint(n), e.(Node).Children[0].(Scanner).String())
+		n++
+		return nil
+	}))
+	assert.Equal(t, entries, n)
+	assert.Less(t, cap(src.buf), len(text)/2)
+
+	line, _ := input.Position()
+	assert.Equal(t, entries+1, line)
+}
+
+func TestScannerFromReaderReadError(t *testing.T) {
+	t.Parallel()
+	boom := errors.New("boom")
+	input := func() *Scanner {
+		return NewScannerFromReader(io.MultiReader(
+			strings.NewReader("1 INFO a\n2 WARN b\n"), iotest.ErrReader(boom)), "")
+	}
+
+	_, err := logGrammar.Parse("log", input())
+	require.IsType(t, StoppedError{}, err)
+	assert.ErrorIs(t, err, boom)
+
+	n := 0
+	err = logGrammar.ParseStream("entry", input(), func(TreeElement) error { n++; return nil })
+	require.IsType(t, StoppedError{}, err)
+	assert.ErrorIs(t, err, boom)
+	assert.Equal(t, 2, n)
+}
+
+func TestParseStreamError(t *testing.T) {
+	t.Parallel()
+	n := 0
+	err := logGrammar.ParseStream("entry",
+		NewScannerFromReader(strings.NewReader("1 INFO a\n2 DEBUG b\n3 INFO c\n"), ""),
+		func(TreeElement) error { n++; return nil })
+	require.Error(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"unicode/utf8"
+)
+
+// recover skips input up to and including the next sync point after a fatal
+// error, so that the repetition can carry on. The skipped input is returned as
+// an ErrorNode. Recovery only happens when enabled via WithRecovery, and only
+// in repetitions whose items contain a sync terminal, so that inner
+// repetitions don't consume the sync point that ends an outer item.
+func (p *quantParser) recover(scope Scope, input *Scanner, err error) (TreeElement, bool) {
+	fatal, ok := err.(FatalError)
+	if st := scope.getParseState(); !ok || p.sync == nil || st == nil || !st.recover {
+		return nil, false
+	}
+	// Start looking for a sync point where the parse gave up.
+	scan := *input
+	if n := fatal.Furthest().Input.Offset() - input.Offset(); n > 0 {
+		scan = *input.Skip(n)
+	}
+	for {
+		var v TreeElement
+		end := scan
+		if p.sync.Parse(scope, &end, &v, nil) == nil && end.Offset() > input.Offset() {
+			skipped := input.Slice(0, end.Offset()-input.Offset()).held()
+			*input = end
+			return ErrorNode{Err: err, Skipped: skipped}, true
+		}
+		rest := scan.peek(utf8.UTFMax)
+		if rest == "" {
+			return nil, false
+		}
+		_, n := utf8.DecodeRuneInString(rest)
+		scan = *scan.Skip(n)
+	}
+}
+
+// syncTerms returns the terminals of the .sync rule, keyed by their String().
+func syncTerms(g Grammar) map[string]bool {
+	syncs := map[string]bool{}
+	var add func(t Term)
+	add = func(t Term) {
+		switch t := t.(type) {
+		case S, SI, RE:
+			syncs[t.String()] = true
+		case Oneof:
+			for _, t := range t {
+				add(t)
+			}
+		case CutPoint:
+			add(t.Term)
+		case Named:
+			add(t.Term)
+		}
+	}
+	if t, has := g[Sync]; has {
+		add(t)
+	}
+	return syncs
+}
+
+// containsSync reports whether t, or any rule it refers to, contains one of
+// the sync terminals.
+func containsSync(t Term, g Grammar, syncs map[string]bool, seen map[Rule]bool) bool {
+	switch t := t.(type) {
+	case S, SI, RE:
+		return syncs[t.String()]
+	case Rule:
+		if seen[t] || t == Sync || t == WrapRE {
+			return false
+		}
+		seen[t] = true
+		return containsSync(g[t], g, syncs, seen)
+	case Seq:
+		for _, t := range t {
+			if containsSync(t, g, syncs, seen) {
+				return true
+			}
+		}
+	case Oneof:
+		for _, t := range t {
+			if containsSync(t, g, syncs, seen) {
+				return true
+			}
+		}
+	case Delim:
+		return containsSync(t.Term, g, syncs, seen) || containsSync(t.Sep, g, syncs, seen)
+	case Quant:
+		return containsSync(t.Term, g, syncs, seen)
+	case Named:
+		return containsSync(t.Term, g, syncs, seen)
+	case CutPoint:
+		return containsSync(t.Term, g, syncs, seen)
+	case ScopedGrammar:
+		return containsSync(t.Term, g, syncs, seen)
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var recoveryGrammar = Grammar{
+	"stmts":   Any(Rule("stmt")),
+	"stmt":    Seq{CutPoint{S("let")}, RE(`[a-z]+`), S("="), Rule("expr"), S(";")},
+	"expr":    Delim{Term: RE(`\d+`), Sep: CutPoint{S("+")}},
+	".sync":   S(";"),
+	".wrapRE": RE(`\s*()\s*`),
+}.Compile(nil)
+
+func TestParserRecovery(t *testing.T) {
+	t.Parallel()
+	input := "let a = 1; let b = x; let c = 2 + ; let d = 3;"
+
+	_, err := recoveryGrammar.Parse("stmts", NewScanner(input))
+	require.IsType(t, FatalError{}, err)
+
+	_, err = recoveryGrammar.Parse("stmts", NewScanner(input), WithRecovery())
+	require.IsType(t, RecoveryError{}, err)
+	re := err.(RecoveryError)
+	require.Len(t, re.Errors(), 2)
+	assert.IsType(t, FatalError{}, re.Errors()[0])
+
+	stmts := re.Result().(Node)
+	require.Equal(t, 4, stmts.Count())
+	assert.IsType(t, Node{}, stmts.Children[0])
+	assert.Equal(t, "let b = x; ", stmts.Children[1].(ErrorNode).Skipped.String())
+	assert.Equal(t, "let c = 2 + ; ", stmts.Children[2].(ErrorNode).Skipped.String())
+	assert.IsType(t, Node{}, stmts.Children[3])
+
+	var sb strings.Builder
+	_, err = recoveryGrammar.Unparse(stmts, &sb)
+	require.NoError(t, err)
+	assert.Equal(t, input, sb.String())
+}
+
+func TestParserRecoveryValidInput(t *testing.T) {
+	t.Parallel()
+	input := "let a = 1; let b = 2 + 3;"
+	expected, err := recoveryGrammar.Parse("stmts", NewScanner(input))
+	require.NoError(t, err)
+	actual, err := recoveryGrammar.Parse("stmts", NewScanner(input), WithRecovery())
+	require.NoError(t, err)
+	AssertEqualNodes(t, expected.(Node), actual.(Node))
+}
+
+func TestParserRecoveryWithoutSync(t *testing.T) {
+	t.Parallel()
+	p := Grammar{
+		"stmts": Any(Seq{CutPoint{S("x")}, S(";")}),
+	}.Compile(nil)
+	_, err := p.Parse("stmts", NewScanner("x;x"), WithRecovery())
+	assert.IsType(t, FatalError{}, err)
+}
@@ -13,6 +13,19 @@ func (t S) Resolve(oldRule, newRule Rule) Term {
 	return t
 }
 
+func (t SI) Resolve(oldRule, newRule Rule) Term {
+	return t
+}
+
+func (t Position) Resolve(oldRule, newRule Rule) Term {
+	return t
+}
+
+func (t Token) Resolve(oldRule, newRule Rule) Term {
+	t.Rule = t.Rule.Resolve(oldRule, newRule).(Rule)
+	return t
+}
+
 func (t RE) Resolve(oldRule, newRule Rule) Term {
 	return t
 }
@@ -56,13 +69,29 @@ func (t LookAhead) Resolve(oldRule, newRule Rule) Term {
 	return t
 }
 
+func (t NotLookAhead) Resolve(oldRule, newRule Rule) Term {
+	t.Term = t.Term.Resolve(oldRule, newRule)
+	return t
+}
+
+func (t LookBehind) Resolve(oldRule, newRule Rule) Term {
+	t.Term = t.Term.Resolve(oldRule, newRule)
+	return t
+}
+
+func (t NotLookBehind) Resolve(oldRule, newRule Rule) Term {
+	t.Term = t.Term.Resolve(oldRule, newRule)
+	return t
+}
+
 func (t Named) Resolve(oldRule, newRule Rule) Term {
 	t.Term = t.Term.Resolve(oldRule, newRule)
 	return t
 }
 
 func (t ScopedGrammar) Resolve(oldRule, newRule Rule) Term {
-	panic(errors.Inconceivable)
+	t.Term = t.Term.Resolve(oldRule, newRule)
+	return t
 }
 
 func (t CutPoint) Resolve(oldRule, newRule Rule) Term {
@@ -3,14 +3,19 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type Scanner struct {
 	src         source // the source the scanner is drawing from
 	sliceStart  int    // the start of the slice visible to the scanner, based on the original src
 	sliceLength int    // the length of the slice visible to the scanner, based on the original src
+	leading     int    // the length of the trivia before the slice (see WithTrivia)
+	trailing    int    // the length of the trivia after the slice (see WithTrivia)
 }
 
 type source interface {
@@ -18,29 +23,74 @@ type source interface {
 	slice(i, length int) string // the string of the given slice
 	filename() string           // the name of the file from which the source is derived (or empty if none)
 	stripSource(i, length int) source
+	line(i int) (line, start int)                             // the 1-indexed line of offset i and the offset of its start
+	context(start, end, limitLines int) (above, below string) // the text around the given slice
+}
+
+// runeSource is implemented by sources that match regexps via an io.RuneReader
+// rather than a string.
+type runeSource interface {
+	runeReader(i, length int) io.RuneReader
 }
 
 type stringSource struct {
-	origin string // the entire source string
-	f      string // the source filename
+	origin string     // the entire source string
+	f      string     // the source filename
+	lines  *lineIndex // the lines of origin, shared by copies of the source
+}
+
+func newStringSource(origin, filename string) stringSource {
+	return stringSource{origin: origin, f: filename, lines: &lineIndex{}}
+}
+
+// sameSource reports whether two sources hold the same input.
+func sameSource(a, b source) bool {
+	if h, ok := a.(heldSource); ok {
+		a = h.src
+	}
+	if h, ok := b.(heldSource); ok {
+		b = h.src
+	}
+	if a, ok := a.(stringSource); ok {
+		b, ok := b.(stringSource)
+		return ok && a.origin == b.origin && a.f == b.f
+	}
+	return a == b
+}
+
+func (s stringSource) String() string {
+	return fmt.Sprintf("{%s %s}", s.origin, s.f)
 }
 
 func NewScanner(str string) *Scanner {
-	return &Scanner{stringSource{origin: str}, 0, len(str)}
+	return &Scanner{src: newStringSource(str, ""), sliceLength: len(str)}
 }
 
 func NewScannerWithFilename(str, filename string) *Scanner {
-	return &Scanner{stringSource{str, filename}, 0, len(str)}
+	return &Scanner{src: newStringSource(str, filename), sliceLength: len(str)}
 }
 
 func NewScannerAt(str string, offset, size int) *Scanner {
-	return &Scanner{stringSource{origin: str}, offset, size}
+	return &Scanner{src: newStringSource(str, ""), sliceStart: offset, sliceLength: size}
 }
 
 // - Scanner
 
+// Replace returns a scanner for text in place of the scanner's slice, within its
+// source as it reads with the slice replaced, so that it keeps the filename and
+// position of the slice. The source isn't copied. MergeScanners treats it as
+// the slice it replaced.
+func (s Scanner) Replace(text string) *Scanner {
+	if s.src == nil {
+		return NewScanner(text)
+	}
+	src := replacedSource{src: s.src, at: s.sliceStart, n: s.sliceLength, text: text}
+	return &Scanner{src: src, sliceStart: s.sliceStart, sliceLength: len(text)}
+}
+
 func (s Scanner) StripSource() Scanner {
 	s.src = s.src.stripSource(s.sliceStart, s.sliceLength)
+	s.leading, s.trailing = 0, 0
 	return s
 }
 
@@ -74,7 +124,7 @@ var (
 )
 
 func (s Scanner) Contains(sn Scanner) bool {
-	if s.Filename() != sn.Filename() || s.src != sn.src {
+	if s.Filename() != sn.Filename() || !sameSource(s.src, sn.src) {
 		return false
 	}
 
@@ -86,18 +136,7 @@ func (s Scanner) Context(limitLines int) string {
 	end := s.sliceStart + s.sliceLength
 	lineno, colno := s.Position()
 
-	aboveCxt := s.src.slice(0, s.sliceStart)
-	belowCxt := s.src.slice(end, s.src.length()-end)
-	if limitLines != NoLimit {
-		a := strings.Split(aboveCxt, "\n")
-		if len(a) > limitLines {
-			aboveCxt = strings.Join(a[len(a)-limitLines-1:], "\n")
-		}
-		b := strings.Split(belowCxt, "\n")
-		if len(b) > limitLines {
-			belowCxt = strings.Join(b[:limitLines], "\n")
-		}
-	}
+	aboveCxt, belowCxt := s.src.context(s.sliceStart, end, limitLines)
 
 	return fmt.Sprintf("\n\033[1;37m%s:%d:%d:\033[0m\n%s\033[1;31m%s\033[0m%s",
 		s.Filename(),
@@ -109,14 +148,53 @@ func (s Scanner) Context(limitLines int) string {
 	)
 }
 
+// Leading returns the trivia before the scanner's slice: the text that .wrapRE
+// consumed ahead of the token. It is only recorded by a parse WithTrivia and is
+// otherwise empty.
+func (s Scanner) Leading() Scanner {
+	if s.leading == 0 {
+		return Scanner{}
+	}
+	return Scanner{src: s.src, sliceStart: s.sliceStart - s.leading, sliceLength: s.leading}
+}
+
+// Trailing returns the trivia after the scanner's slice: the text that .wrapRE
+// consumed behind the token. It is only recorded by a parse WithTrivia and is
+// otherwise empty.
+func (s Scanner) Trailing() Scanner {
+	if s.trailing == 0 {
+		return Scanner{}
+	}
+	return Scanner{src: s.src, sliceStart: s.sliceStart + s.sliceLength, sliceLength: s.trailing}
+}
+
 // The position of the start of the scanner within the original source.
 func (s Scanner) Offset() int {
 	return s.sliceStart
 }
 
 // The 1-indexed line and column number of the start of the scanner within the original source.
+// The column counts bytes.
 func (s Scanner) Position() (int, int) {
-	return lineColumn(s.src.slice(0, s.sliceStart), s.sliceStart)
+	line, start := s.src.line(s.sliceStart)
+	return line, s.sliceStart - start + 1
+}
+
+// RunePosition is like Position, but the column counts runes.
+func (s Scanner) RunePosition() (int, int) {
+	line, start := s.src.line(s.sliceStart)
+	return line, utf8.RuneCountInString(s.src.slice(start, s.sliceStart-start)) + 1
+}
+
+// UTF16Position is like Position, but the column counts UTF-16 code units, as
+// the Language Server Protocol does.
+func (s Scanner) UTF16Position() (int, int) {
+	line, start := s.src.line(s.sliceStart)
+	col := 1
+	for _, r := range s.src.slice(start, s.sliceStart-start) {
+		col += utf16.RuneLen(r)
+	}
+	return line, col
 }
 
 // The slice that is visible to the scanner
@@ -124,14 +202,84 @@ func (s Scanner) slice() string {
 	return s.src.slice(s.sliceStart, s.sliceLength)
 }
 
+// peek returns up to the next n bytes visible to the scanner.
+func (s Scanner) peek(n int) string {
+	if n > s.sliceLength {
+		n = s.sliceLength
+	}
+	return s.src.slice(s.sliceStart, n)
+}
+
+// atEnd reports whether the scanner has no more input.
+func (s Scanner) atEnd() bool {
+	if src, ok := s.src.(*readerSource); ok {
+		return s.sliceLength == 0 || !src.fill(s.sliceStart+1)
+	}
+	return s.slice() == ""
+}
+
+// release lets the source drop the input before the scanner, which the parser
+// will never backtrack to.
+func (s Scanner) release() {
+	if src, ok := s.src.(*readerSource); ok {
+		src.release(s.sliceStart)
+	}
+}
+
+// readError returns the error that stopped the scanner's reader short of the
+// end of its input, if any.
+func (s Scanner) readError() error {
+	if src, ok := s.src.(*readerSource); ok && src.err != io.EOF {
+		return src.err
+	}
+	return nil
+}
+
+// hold keeps the input from the scanner in the window until unhold, since the
+// parser may return to it.
+func (s Scanner) hold() {
+	if src, ok := s.src.(*readerSource); ok {
+		src.hold(s.sliceStart)
+	}
+}
+
+func (s Scanner) unhold() {
+	if src, ok := s.src.(*readerSource); ok {
+		src.unhold()
+	}
+}
+
+// advance moves the last hold up to the scanner, as a repetition does when it
+// completes an item, and lets the source drop the input no longer held, less
+// behind bytes. It returns the start of what the source still holds, or -1
+// if the scanner isn't drawn from a reader.
+func (s Scanner) advance(behind int) int {
+	if src, ok := s.src.(*readerSource); ok {
+		return src.advance(s.sliceStart, behind)
+	}
+	return -1
+}
+
+// held returns the scanner with its own copy of its text and trivia, if it
+// is drawn from a reader, so that it outlives the window.
+func (s Scanner) held() Scanner {
+	if src, ok := s.src.(*readerSource); ok {
+		at := s.sliceStart - s.leading
+		s.src = heldSource{src: src, at: at, text: src.slice(at, s.leading+s.sliceLength+s.trailing)}
+	}
+	return s
+}
+
 func (s Scanner) Slice(a, b int) *Scanner {
-	return &Scanner{s.src, s.sliceStart + a, b - a}
+	return &Scanner{src: s.src, sliceStart: s.sliceStart + a, sliceLength: b - a}
 }
 
 func (s Scanner) Skip(i int) *Scanner {
-	return &Scanner{s.src, s.sliceStart + i, s.sliceLength - i}
+	return &Scanner{src: s.src, sliceStart: s.sliceStart + i, sliceLength: s.sliceLength - i}
 }
 
+// MergeScanners returns a scanner spanning the items. A scanner from Replace
+// counts as the text it replaced, so it merges with the scanners around it.
 func MergeScanners(items ...Scanner) (Scanner, error) {
 	if len(items) == 0 {
 		return Scanner{}, errors.New("needs at least one scanner")
@@ -139,19 +287,26 @@ func MergeScanners(items ...Scanner) (Scanner, error) {
 	if len(items) == 1 {
 		return items[0], nil
 	}
+	items = append(make([]Scanner, 0, len(items)), items...)
+	for i, item := range items {
+		if src, ok := item.src.(replacedSource); ok && item.sliceStart == src.at && item.sliceLength == len(src.text) {
+			items[i] = Scanner{src: src.src, sliceStart: src.at, sliceLength: src.n}
+		}
+	}
 
 	l, r := items[0].sliceStart, items[0].sliceStart+items[0].sliceLength
+	leading, trailing := items[0].leading, items[0].trailing
 	src := items[0].src
 
 	for _, v := range items[1:] {
-		if v.src != src {
+		if !sameSource(v.src, src) {
 			return Scanner{}, fmt.Errorf("scanners' sources are not the same: %s vs %s", src, v.src)
 		}
 		if v.sliceStart < l {
-			l = v.sliceStart
+			l, leading = v.sliceStart, v.leading
 		}
 		if v.sliceStart+v.sliceLength > r {
-			r = v.sliceStart + v.sliceLength
+			r, trailing = v.sliceStart+v.sliceLength, v.trailing
 		}
 	}
 
@@ -159,20 +314,20 @@ func MergeScanners(items ...Scanner) (Scanner, error) {
 		src:         src,
 		sliceStart:  l,
 		sliceLength: r - l,
+		leading:     leading,
+		trailing:    trailing,
 	}, nil
 }
 
 // Eat returns a scanner containing the next i bytes and advances s past them.
 func (s *Scanner) Eat(i int, eaten *Scanner) *Scanner {
-	eaten.src = s.src
-	eaten.sliceStart = s.sliceStart
-	eaten.sliceLength = i
+	*eaten = Scanner{src: s.src, sliceStart: s.sliceStart, sliceLength: i}
 	*s = *s.Skip(i)
 	return s
 }
 
 func (s *Scanner) EatString(str string, eaten *Scanner) bool {
-	if strings.HasPrefix(s.slice(), str) {
+	if len(str) <= s.sliceLength && s.peek(len(str)) == str {
 		s.Eat(len(str), eaten)
 		return true
 	}
@@ -183,7 +338,7 @@ func (s *Scanner) EatString(str string, eaten *Scanner) bool {
 // the whole match and captures (if != nil) with any captured groups. Returns
 // n as the number of captures set and ok iff a match was found.
 func (s *Scanner) EatRegexp(re *regexp.Regexp, match *Scanner, captures []Scanner) (n int, ok bool) {
-	if loc := re.FindStringSubmatchIndex(s.slice()); loc != nil {
+	if loc := s.match(re); loc != nil {
 		if loc[0] != 0 {
 			panic(`re not \A-anchored`)
 		}
@@ -207,11 +362,17 @@ func (s *Scanner) EatRegexp(re *regexp.Regexp, match *Scanner, captures []Scanne
 	return 0, false
 }
 
+func (s *Scanner) match(re *regexp.Regexp) []int {
+	if src, ok := s.src.(runeSource); ok {
+		return re.FindReaderSubmatchIndex(src.runeReader(s.sliceStart, s.sliceLength))
+	}
+	return re.FindStringSubmatchIndex(s.slice())
+}
+
 // - stringSource
 
 func (s stringSource) stripSource(offset, size int) source {
-	s.origin = s.slice(offset, size)
-	return s
+	return newStringSource(s.slice(offset, size), s.f)
 }
 
 func (s stringSource) length() int {
@@ -230,10 +391,124 @@ func (s stringSource) filename() string {
 	return s.f
 }
 
-// The 1-indexed line and column number of the given position within the given string.
-func lineColumn(str string, pos int) (line, col int) {
-	prefix := str[:pos]
-	line = strings.Count(prefix, "\n") + 1
-	col = pos - strings.LastIndex(prefix, "\n")
-	return
+// clamp limits an offset to the bounds of the source.
+func (s stringSource) clamp(i int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i > len(s.origin):
+		return len(s.origin)
+	}
+	return i
+}
+
+func (s stringSource) lineStarts() []int {
+	if s.lines == nil {
+		return lineStarts(s.origin, 0, nil)
+	}
+	return s.lines.get(s.origin)
+}
+
+func (s stringSource) line(i int) (line, start int) {
+	line, start = lineAt(s.lineStarts(), s.clamp(i))
+	return line + 1, start
+}
+
+// context returns the text before start and after end, only as far as
+// limitLines lines either side.
+func (s stringSource) context(start, end, limitLines int) (above, below string) {
+	start, end = s.clamp(start), s.clamp(end)
+	if limitLines == NoLimit {
+		return s.origin[:start], s.origin[end:]
+	}
+	starts := s.lineStarts()
+	from := 0
+	if line, _ := lineAt(starts, start); line > limitLines {
+		from = starts[line-limitLines-1]
+	}
+	to := end
+	if limitLines > 0 {
+		to = len(s.origin)
+		if line, _ := lineAt(starts, end); line+limitLines-1 < len(starts) {
+			to = starts[line+limitLines-1] - 1
+		}
+	}
+	return s.origin[from:start], s.origin[end:to]
+}
+
+// - replacedSource
+
+// replacedSource is a source with the n bytes at offset at replaced by text. It
+// reads through to the source it replaces them in.
+type replacedSource struct {
+	src   source
+	at, n int
+	text  string
+}
+
+func (s replacedSource) length() int {
+	return s.src.length() + len(s.text) - s.n
+}
+
+func (s replacedSource) slice(i, length int) string {
+	end := s.at + len(s.text)
+	switch {
+	case i+length <= s.at:
+		return s.src.slice(i, length)
+	case i >= s.at && i+length <= end:
+		return s.text[i-s.at : i-s.at+length]
+	case i >= end:
+		return s.src.slice(i-end+s.at+s.n, length)
+	}
+	var b strings.Builder
+	if i < s.at {
+		b.WriteString(s.src.slice(i, s.at-i))
+		length -= s.at - i
+		i = s.at
+	}
+	n := end - i
+	if n > length {
+		n = length
+	}
+	b.WriteString(s.text[i-s.at : i-s.at+n])
+	if length > n {
+		b.WriteString(s.src.slice(s.at+s.n, length-n))
+	}
+	return b.String()
+}
+
+func (s replacedSource) filename() string {
+	return s.src.filename()
+}
+
+func (s replacedSource) stripSource(i, length int) source {
+	return newStringSource(s.slice(i, length), s.filename())
+}
+
+func (s replacedSource) line(i int) (line, start int) {
+	end := s.at + len(s.text)
+	switch {
+	case i <= s.at:
+		return s.src.line(i)
+	case i <= end:
+		line, start = s.src.line(s.at)
+		before := s.text[:i-s.at]
+		if n := strings.Count(before, "\n"); n > 0 {
+			line, start = line+n, s.at+strings.LastIndexByte(before, '\n')+1
+		}
+		return line, start
+	}
+	line, start = s.src.line(i - end + s.at + s.n)
+	line += strings.Count(s.text, "\n") - strings.Count(s.src.slice(s.at, s.n), "\n")
+	if start > s.at+s.n {
+		return line, start + len(s.text) - s.n
+	}
+	// The line starts at or before the end of the text.
+	_, start = s.line(end)
+	return line, start
+}
+
+func (s replacedSource) context(start, end, limitLines int) (above, below string) {
+	// Context is only wanted to report errors, so the text is put together.
+	return newStringSource(s.slice(0, s.length()), s.filename()).context(start, end, limitLines)
 }
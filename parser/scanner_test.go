@@ -2,9 +2,11 @@ package parser
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestScannerLineColumn(t *testing.T) {
@@ -40,7 +42,7 @@ func assertLineColumn(t *testing.T, scanner *Scanner, line, column int) {
 
 func TestScannerMerge(t *testing.T) {
 	str := "one\ntwo\nthree\nfour"
-	src := stringSource{origin: str}
+	src := newStringSource(str, "")
 
 	assertMergedScanner(t, src, 0, 5, []Scanner{*NewScannerAt(str, 0, 5)})
 	assertMergedScanner(t, src, 0, len(str), []Scanner{*NewScanner(str), *NewScanner(str)})
@@ -115,3 +117,78 @@ func assertMergedScannerErr(t *testing.T, err error, items []Scanner) {
 	_, e := MergeScanners(items...)
 	assert.Equal(t, err, e)
 }
+
+func TestScannerPositions(t *testing.T) {
+	t.Parallel()
+	str := "ab\nxé😀b\n"
+	for _, s := range []*Scanner{NewScanner(str), NewScannerFromReader(strings.NewReader(str), "")} {
+		b := s.Skip(10)
+		line, col := b.Position()
+		assert.Equal(t, [2]int{2, 8}, [2]int{line, col})
+		line, col = b.RunePosition()
+		assert.Equal(t, [2]int{2, 4}, [2]int{line, col})
+		line, col = b.UTF16Position()
+		assert.Equal(t, [2]int{2, 5}, [2]int{line, col})
+
+		line, col = s.Skip(2).Position()
+		assert.Equal(t, [2]int{1, 3}, [2]int{line, col})
+		line, col = s.Skip(len(str)).Position()
+		assert.Equal(t, [2]int{3, 1}, [2]int{line, col})
+	}
+}
+
+func TestScannerReplace(t *testing.T) {
+	t.Parallel()
+	s := NewScannerWithFilename("a -> b;\nc -> d e;", "x.wbnf").Slice(13, 14)
+	assert.Equal(t, "d", s.String())
+	r := s.Replace("ns.d")
+	assert.Equal(t, "ns.d", r.String())
+	assert.Equal(t, "x.wbnf", r.Filename())
+	line, col := r.Position()
+	assert.Equal(t, [2]int{2, 6}, [2]int{line, col})
+	assert.Equal(t, "d", s.String())
+
+	// It merges with the scanners around it as the slice it replaced.
+	merged, err := MergeScanners(*s.Slice(-5, -4), *r, *s.Slice(2, 3))
+	require.NoError(t, err)
+	assert.Equal(t, "c -> d e", merged.String())
+
+	// The source reads as if it had been copied with the slice replaced.
+	str := "a -> b;\nc -> d\ne;\nf;"
+	for _, c := range []struct {
+		start, end int
+		text       string
+	}{{13, 14, "ns.d"}, {13, 15, "x"}, {13, 14, "x\ny\n"}, {0, 0, "\n"}, {5, 7, ""}, {18, 20, "\n\n"}} {
+		r := NewScanner(str).Slice(c.start, c.end).Replace(c.text).src
+		want := newStringSource(str[:c.start]+c.text+str[c.end:], "")
+		assert.Equal(t, want.length(), r.length(), c.text)
+		for i := 0; i <= want.length(); i++ {
+			for j := i; j <= want.length(); j++ {
+				assert.Equal(t, want.slice(i, j-i), r.slice(i, j-i), "%q %d %d", c.text, i, j)
+			}
+			line, start := r.line(i)
+			wantLine, wantStart := want.line(i)
+			assert.Equal(t, [2]int{wantLine, wantStart}, [2]int{line, start}, "%q %d", c.text, i)
+		}
+	}
+}
+
+func TestScannerContextLines(t *testing.T) {
+	t.Parallel()
+	str := "l1\nl2\nl3\nl4\nl5"
+	for _, s := range []*Scanner{NewScannerAt(str, 6, 2), NewScannerFromReader(strings.NewReader(str), "").Slice(6, 8)} {
+		for _, c := range []struct {
+			limit        int
+			above, below string
+		}{
+			{0, "", ""},
+			{1, "l2\n", ""},
+			{2, "l1\nl2\n", "\nl4"},
+			{NoLimit, "l1\nl2\n", "\nl4\nl5"},
+		} {
+			above, below := s.src.context(6, 8, c.limit)
+			assert.Equal(t, c.above, above, "%d", c.limit)
+			assert.Equal(t, c.below, below, "%d", c.limit)
+		}
+	}
+}
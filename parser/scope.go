@@ -17,6 +17,10 @@ type escape struct {
 
 type Scope struct {
 	m frozen.Map[string, any]
+
+	// st is consulted on every rule entry, so it is kept out of m to avoid
+	// the cost of a map lookup.
+	st *parseState
 }
 
 func (s Scope) String() string {
@@ -28,7 +32,8 @@ func (s Scope) Keys() frozen.Set[string] {
 }
 
 func (s Scope) With(ident string, v any) Scope {
-	return Scope{m: s.m.With(ident, v)}
+	s.m = s.m.With(ident, v)
+	return s
 }
 
 func (s Scope) Has(ident string) bool {
@@ -36,7 +41,11 @@ func (s Scope) Has(ident string) bool {
 }
 
 func (s Scope) Merge(t Scope) Scope {
-	return Scope{m: s.m.Update(t.m)}
+	s.m = s.m.Update(t.m)
+	if s.st == nil {
+		s.st = t.st
+	}
+	return s
 }
 
 type scopeBuilder struct {
@@ -131,6 +140,22 @@ func (s Scope) getParserEscape() *escape {
 	return nil
 }
 
+func (s Scope) withParseState(st *parseState) Scope {
+	s.st = st
+	return s
+}
+
+func (s Scope) getParseState() *parseState {
+	return s.st
+}
+
+// markVolatile notes that the parse in progress depends on more than the input.
+func (s Scope) markVolatile() {
+	if s.st != nil {
+		s.st.volatile++
+	}
+}
+
 type call struct {
 	ident string
 	term  Term
@@ -16,11 +16,32 @@ func NodeRule(e Node) Rule {
 type Grammar map[Rule]Term
 
 // Unparse inverts the action of a parser, taking a generated AST and producing
-// the source it came from. Currently, it doesn't quite do that, and is only
-// being used for quick eyeballing to validate output.
+// the exact source it came from: its tokens, the text between them and the
+// text that .wrapRE consumed before the first and after the last.
+//
+// Unparse works from the tokens alone, so it only reproduces the source of
+// trees whose tokens are in order in one source. Use UnparsePretty for trees
+// that have been modified.
 func (g Grammar) Unparse(e TreeElement, w io.Writer) (n int, err error) {
-	rule := NodeRule(e.(Node))
-	return g[rule].Unparse(g, e, w)
+	tw := newTokenWriter(g, w, false)
+	if _, err := writeTree(tw, e); err != nil {
+		return tw.n, err
+	}
+	return tw.close()
+}
+
+// UnparsePretty regenerates source from a tree, which may have been modified
+// since it was parsed. It follows the grammar, writing the literal text of any
+// string terms whose tokens are nil. Tokens keep the whitespace between them
+// where they are still adjacent in their source; elsewhere they are separated
+// by a single space if the grammar has a .wrapRE, and not at all otherwise.
+func (g Grammar) UnparsePretty(e TreeElement, w io.Writer) (n int, err error) {
+	g = g.withoutStacks()
+	tw := newTokenWriter(g, w, true)
+	if _, err := g[NodeRule(e.(Node))].Unparse(g, e, tw); err != nil {
+		return tw.n, err
+	}
+	return tw.close()
 }
 
 // Parsers holds Parsers generated by Grammar.Compile.
@@ -47,23 +68,99 @@ func (p Parsers) Unparse(e TreeElement, w io.Writer) (n int, err error) {
 	return p.grammar.Unparse(e, w)
 }
 
-// Parse parses some source per a given rule.
-func (p Parsers) ParseWithExternals(rule Rule, input *Scanner, exts ExternalRefs) (TreeElement, error) {
-	scope := Scope{}.WithExternals(exts)
+func (p Parsers) UnparsePretty(e TreeElement, w io.Writer) (n int, err error) {
+	return p.grammar.UnparsePretty(e, w)
+}
+
+// ParseWithExternals parses some source per a given rule, resolving %%refs via
+// exts. The behaviour of the parse may be adjusted with opts.
+func (p Parsers) ParseWithExternals(
+	rule Rule, input *Scanner, exts ExternalRefs, opts ...ParseOption,
+) (TreeElement, error) {
+	st := newParseState(opts)
+	scope := Scope{}.WithExternals(exts).withParseState(st)
 	var e TreeElement
-	if err := p.parsers[rule].Parse(scope, input, &e, nil); err != nil {
+	err := p.parsers[rule].Parse(scope, input, &e, nil)
+	if rerr := input.readError(); rerr != nil {
+		// The parse only saw the input up to the failed read.
+		return nil, StoppedError{cause: rerr, input: *input}
+	}
+	if err != nil {
 		return nil, err
 	}
+	if st.trivia {
+		e = shareTrivia(e)
+	}
 
-	if input.String() == "" {
+	if st.recover {
+		if errs := recoveredErrors(e, nil); len(errs) > 0 {
+			if !input.atEnd() {
+				errs = append(errs, UnconsumedInput(*input, e))
+			}
+			return nil, RecoveryError{errs: errs, tree: e}
+		}
+	}
+
+	if input.atEnd() {
 		return e, nil
 	}
 
 	return nil, UnconsumedInput(*input, e)
 }
 
-func (p Parsers) Parse(rule Rule, input *Scanner) (TreeElement, error) {
-	return p.ParseWithExternals(rule, input, nil)
+// ParseStream parses input as a sequence of rule, calling fn with the tree of
+// each one in turn. It stops at the first error from the parse, from reading
+// the input or from fn.
+//
+// The parser never backtracks into an item that has been completely parsed, so
+// once fn returns, the input that item came from is released from scanners
+// created by NewScannerFromReader, along with the outcomes recorded for it.
+// This bounds memory by the size of an item rather than of the whole input.
+func (p Parsers) ParseStream(
+	rule Rule, input *Scanner, fn func(TreeElement) error, opts ...ParseOption,
+) error {
+	for !input.atEnd() {
+		start := input.Offset()
+		st := newParseState(opts)
+		scope := Scope{}.withParseState(st)
+		var e TreeElement
+		if err := p.parsers[rule].Parse(scope, input, &e, nil); err != nil {
+			if rerr := input.readError(); rerr != nil {
+				return StoppedError{cause: rerr, input: *input}
+			}
+			return err
+		}
+		if st.trivia {
+			e = shareTrivia(e)
+		}
+		if input.Offset() == start {
+			return UnconsumedInput(*input, e)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		input.release()
+	}
+	if err := input.readError(); err != nil {
+		return StoppedError{cause: err, input: *input}
+	}
+	return nil
+}
+
+func recoveredErrors(e TreeElement, errs []error) []error {
+	switch e := e.(type) {
+	case ErrorNode:
+		errs = append(errs, e.Err)
+	case Node:
+		for _, child := range e.Children {
+			errs = recoveredErrors(child, errs)
+		}
+	}
+	return errs
+}
+
+func (p Parsers) Parse(rule Rule, input *Scanner, opts ...ParseOption) (TreeElement, error) {
+	return p.ParseWithExternals(rule, input, nil, opts...)
 }
 
 // MustParse calls Parse and returns the result or panics if an error was
@@ -88,6 +185,11 @@ type Choice int
 
 func (Choice) IsExtra() {}
 
+// Label is the label of the alternative of a token rule that a Token matched.
+type Label string
+
+func (Label) IsExtra() {}
+
 type Associativity int
 
 func NewAssociativity(s string) Associativity {
@@ -123,6 +225,7 @@ const (
 type (
 	Rule string
 	S    string
+	SI   string // like S, but matches case-insensitively
 	RE   string
 	REF  struct {
 		Ident   string
@@ -142,6 +245,33 @@ type (
 	LookAhead struct {
 		Term Term
 	}
+	NotLookAhead struct {
+		Term Term
+	}
+	LookBehind struct {
+		Term Term
+	}
+	NotLookBehind struct {
+		Term Term
+	}
+	// Position matches nothing, producing an empty token where the next token
+	// would start. If Op is set, it only matches if the position's Prop compares
+	// by Op with that of the first token of the value named Ref, or with Value
+	// if Ref is empty.
+	Position struct {
+		Prop  PositionProp
+		Op    string // "=", "!=", "<", "<=", ">" or ">="
+		Ref   string
+		Value int
+	}
+	// Token matches the alternative named Label of the token rule Rule. All the
+	// alternatives of a token rule are tried at once, as a lexer would: the
+	// longest match wins, with earlier alternatives winning ties, and Token
+	// only matches if the winner is named Label.
+	Token struct {
+		Rule  Rule
+		Label string
+	}
 	Quant struct {
 		Term Term
 		Min  int
@@ -208,17 +338,32 @@ func (g Grammar) String() string {
 	return sb.String()
 }
 
-func (t Rule) String() string      { return string(t) }
-func (t S) String() string         { return fmt.Sprintf("%q", string(t)) }
-func (t RE) String() string        { return fmt.Sprintf("/%v/", string(t)) }
-func (t REF) String() string       { return fmt.Sprintf("%%%v=%v", t.Ident, t.Default) }
-func (t ExtRef) String() string    { return string(t) }
-func (t Seq) String() string       { return "(" + join(t, " ") + ")" }
-func (t Oneof) String() string     { return join(t, " | ") }
-func (t Stack) String() string     { return join(t, " > ") }
-func (t Named) String() string     { return fmt.Sprintf("%s=%v", t.Name, t.Term) }
-func (t LookAhead) String() string { return fmt.Sprintf("(?=%v)", t.Term) }
-func (t CutPoint) String() string  { return fmt.Sprintf("cutpoint {%s}", t.Term.String()) }
+func (t Rule) String() string          { return string(t) }
+func (t S) String() string             { return fmt.Sprintf("%q", string(t)) }
+func (t SI) String() string            { return fmt.Sprintf("i%q", string(t)) }
+func (t RE) String() string            { return fmt.Sprintf("/%v/", string(t)) }
+func (t REF) String() string           { return fmt.Sprintf("%%%v=%v", t.Ident, t.Default) }
+func (t ExtRef) String() string        { return string(t) }
+func (t Seq) String() string           { return "(" + join(t, " ") + ")" }
+func (t Oneof) String() string         { return join(t, " | ") }
+func (t Stack) String() string         { return join(t, " > ") }
+func (t Named) String() string         { return fmt.Sprintf("%s=%v", t.Name, t.Term) }
+func (t LookAhead) String() string     { return fmt.Sprintf("(?=%v)", t.Term) }
+func (t NotLookAhead) String() string  { return fmt.Sprintf("(?!%v)", t.Term) }
+func (t LookBehind) String() string    { return fmt.Sprintf("(?<=%v)", t.Term) }
+func (t NotLookBehind) String() string { return fmt.Sprintf("(?<!%v)", t.Term) }
+func (t CutPoint) String() string      { return fmt.Sprintf("cutpoint {%s}", t.Term.String()) }
+func (t Token) String() string         { return fmt.Sprintf("%s::%s", t.Rule, t.Label) }
+
+func (t Position) String() string {
+	switch {
+	case t.Op == "":
+		return fmt.Sprintf("@%s", t.Prop)
+	case t.Ref == "":
+		return fmt.Sprintf("@%s(%s%d)", t.Prop, t.Op, t.Value)
+	}
+	return fmt.Sprintf("@%s(%s%s)", t.Prop, t.Op, t.Ref)
+}
 
 func (t Delim) String() string {
 	leading := ""
@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const tokenTag = "::"
+
+// tokenizer matches the alternatives of a token rule with a single regexp.
+// Every Token referring to the rule from the same grammar shares one. It is
+// built once Compile has linked every rule, since the rule may belong to an
+// enclosing grammar whose parsers don't exist yet when the Token's parser is
+// made.
+type tokenizer struct {
+	name   Rule
+	rule   Parser                 // the token rule
+	wrap   func(re string) string // applies the .wrapRE of the referring grammar
+	refs   []string               // the labels referred to
+	once   sync.Once
+	re     *regexp.Regexp
+	labels []string // the label of each alternative, or "" if it has none
+	groups []int    // the capture group of each alternative
+	err    error    // why the tokenizer couldn't be built, reported by each parse
+}
+
+type tokenKey struct {
+	tok            *tokenizer
+	offset, length int
+}
+
+// tokenMatch is the outcome of a tokenizer at one position.
+type tokenMatch struct {
+	alt   int   // the alternative that won, or -1 if none matched
+	loc   []int // the match, then the token, relative to the position
+	reach int   // how far ahead the match looked, under Reparse
+}
+
+func (c cache) tokenizer(rule Rule) *tokenizer {
+	if tok, has := c.tokenizers[rule]; has {
+		return tok
+	}
+	tok := &tokenizer{
+		name: rule,
+		rule: rule.Parser("", c),
+		wrap: func(re string) string {
+			return applyWrapRE(re, func(re string) string { return "(" + re + ")" }, c)
+		},
+	}
+	c.registerRule(&tok.rule)
+	if c.tokenizers != nil {
+		c.tokenizers[rule] = tok
+	}
+	if c.unbuilt != nil {
+		*c.unbuilt = append(*c.unbuilt, tok)
+	}
+	return tok
+}
+
+func (tok *tokenizer) build() {
+	patterns, labels, err := tokenAlternatives(ruleTerm(tok.rule))
+	if err != nil {
+		tok.err = fmt.Errorf("%s: %w", tok.name, err)
+		return
+	}
+	for _, ref := range tok.refs {
+		if !hasLabel(labels, ref) {
+			tok.err = fmt.Errorf("token rule %s has no alternative named %s", tok.name, ref)
+			return
+		}
+	}
+	alts := make([]string, 0, len(patterns))
+	for i, pattern := range patterns {
+		alts = append(alts, fmt.Sprintf("(?P<_%d>%s)", i, pattern))
+	}
+	tok.re = regexp.MustCompile(`(?m)\A` + tok.wrap(strings.Join(alts, "|")))
+	tok.re.Longest()
+	tok.labels = labels
+	for i := range patterns {
+		tok.groups = append(tok.groups, tok.re.SubexpIndex(fmt.Sprintf("_%d", i)))
+	}
+}
+
+// ruleTerm returns the term of the rule that p parses, as its grammar has it.
+// The term of the parser itself may have lost the label of a lone alternative.
+func ruleTerm(p Parser) Term {
+	switch p := p.(type) {
+	case *entryParser:
+		return p.t
+	case inlineParser:
+		return p.entry.t
+	}
+	return p.AsTerm()
+}
+
+// match finds the alternative that wins at the start of input. The outcome
+// is recorded for the rest of the parse. A tokenizer made outside Compile, as
+// for a backref, is built when first used.
+func (tok *tokenizer) match(scope Scope, input *Scanner) tokenMatch {
+	tok.once.Do(tok.build)
+	if tok.err != nil {
+		return tokenMatch{alt: -1}
+	}
+	st := scope.getParseState()
+	key := tokenKey{tok: tok, offset: input.sliceStart, length: input.sliceLength}
+	if st != nil {
+		if m, has := st.tokens[key]; has {
+			reread(input.src, input.sliceStart, m.reach)
+			return m
+		}
+	}
+	m := tokenMatch{alt: -1}
+	m.reach = reachOf(input.src, input.sliceStart, func() {
+		if loc := input.match(tok.re); loc != nil {
+			m.loc = loc[:4]
+			for i, group := range tok.groups {
+				if loc[2*group] >= 0 {
+					m.alt = i
+					break
+				}
+			}
+		}
+	})
+	if st != nil {
+		if st.tokens == nil {
+			st.tokens = map[tokenKey]tokenMatch{}
+		}
+		st.tokens[key] = m
+	}
+	return m
+}
+
+// TokenLabels returns the labels of the alternatives of the term of a token
+// rule, with "" for those without one. It fails if the term isn't a choice of
+// alternatives that a regexp can match.
+func TokenLabels(term Term) ([]string, error) {
+	_, labels, err := tokenAlternatives(term)
+	return labels, err
+}
+
+func tokenAlternatives(term Term) (patterns, labels []string, err error) {
+	switch t := term.(type) {
+	case CutPoint:
+		return tokenAlternatives(t.Term)
+	case ScopedGrammar:
+		// The tokens are matched with the .wrapRE of the grammar referring to
+		// them, so the rule's own grammar has no bearing.
+		return tokenAlternatives(t.Term)
+	}
+	alts, ok := term.(Oneof)
+	if !ok {
+		alts = Oneof{term}
+	}
+	for _, alt := range alts {
+		if cut, ok := alt.(CutPoint); ok {
+			alt = cut.Term
+		}
+		label := ""
+		if named, ok := alt.(Named); ok {
+			label, alt = named.Name, named.Term
+		}
+		pattern, ok := tokenPattern(alt)
+		if !ok {
+			return nil, nil, fmt.Errorf("not a token rule: %v is not a terminal", alt)
+		}
+		patterns = append(patterns, pattern)
+		labels = append(labels, label)
+	}
+	return patterns, labels, nil
+}
+
+// tokenPattern returns a regexp matching what t matches, if t is made only of
+// terminals.
+func tokenPattern(t Term) (string, bool) {
+	switch t := t.(type) {
+	case S:
+		return regexp.QuoteMeta(string(t)), true
+	case SI:
+		return "(?i:" + regexp.QuoteMeta(string(t)) + ")", true
+	case RE:
+		return "(?:" + string(t) + ")", true
+	case Seq, Oneof:
+		terms, sep := []Term(nil), ""
+		if seq, ok := t.(Seq); ok {
+			terms = seq
+		} else {
+			terms, sep = t.(Oneof), "|"
+		}
+		patterns := make([]string, 0, len(terms))
+		for _, term := range terms {
+			pattern, ok := tokenPattern(term)
+			if !ok {
+				return "", false
+			}
+			patterns = append(patterns, pattern)
+		}
+		return "(?:" + strings.Join(patterns, sep) + ")", true
+	case Quant:
+		pattern, ok := tokenPattern(t.Term)
+		if !ok {
+			return "", false
+		}
+		max := ""
+		if t.Max > 0 {
+			max = fmt.Sprint(t.Max)
+		}
+		return fmt.Sprintf("%s{%d,%s}", pattern, t.Min, max), true
+	case Named:
+		return tokenPattern(t.Term)
+	case CutPoint:
+		return tokenPattern(t.Term)
+	}
+	return "", false
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenParser struct {
+	rule Rule
+	t    Token
+	tok  *tokenizer
+	put  putter
+}
+
+func (p *tokenParser) Parse(scope Scope, input *Scanner, output *TreeElement, stk *call) error {
+	if escaped, err := parseEscape(p, scope, string(p.rule), p.t, input, output); escaped || err != nil {
+		return err
+	}
+	m := p.tok.match(scope, input)
+	if p.tok.err != nil {
+		return StoppedError{cause: p.tok.err, input: *input}
+	}
+	if m.alt < 0 || p.tok.labels[m.alt] != p.t.Label {
+		return ParseError{rule: p.rule, expected: p.t, input: *input}.with(scope.GetCutPoint(),
+			func() error { return fmt.Errorf("expect: %s", NewScanner(p.t.String()).Context(DefaultLimit)) },
+			func() error {
+				if m.alt >= 0 && p.tok.labels[m.alt] != "" {
+					return fmt.Errorf("actual: %s::%s %s",
+						p.t.Rule, p.tok.labels[m.alt], getErrorStrings(input))
+				}
+				return fmt.Errorf("actual: %s", getErrorStrings(input))
+			},
+			func() error { return stk },
+		)
+	}
+	match, token := *input.Slice(m.loc[0], m.loc[1]), *input.Slice(m.loc[2], m.loc[3])
+	noteToken(scope, match, &token)
+	*input = *input.Skip(m.loc[1])
+	return p.put(output, Label(p.t.Label), token)
+}
+
+func (p *tokenParser) AsTerm() Term { return p.t }
+
+func (t Token) Parser(rule Rule, c cache) Parser {
+	tok := c.tokenizer(t.Rule)
+	tok.refs = append(tok.refs, t.Label)
+	return &tokenParser{
+		rule: rule,
+		t:    t,
+		tok:  tok,
+		put:  tag(rule, tokenTag),
+	}
+}
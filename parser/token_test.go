@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenGrammar() Grammar {
+	return Grammar{
+		"tok": Oneof{
+			Eq("kw_if", S("if")),
+			Eq("ident", RE(`[a-z]\w*`)),
+			Eq("number", RE(`\d+(?:\.\d+)?`)),
+			Eq("op", Oneof{S("<"), S("<=")}),
+			S("("),
+		},
+		"stmt": Oneof{
+			Seq{Token{Rule: "tok", Label: "kw_if"}, Rule("expr")},
+			Rule("expr"),
+		},
+		"expr":    Delim{Term: Token{Rule: "tok", Label: "ident"}, Sep: Token{Rule: "tok", Label: "op"}},
+		".wrapRE": RE(`\s*()\s*`),
+	}
+}
+
+func TestToken(t *testing.T) {
+	t.Parallel()
+	g := tokenGrammar().Compile(nil)
+
+	e, err := g.Parse("stmt", NewScanner("if a <= b"))
+	require.NoError(t, err)
+	assert.Equal(t, Choice(0), e.(Node).Extra)
+	seq := e.(Node).Children[0].(Node)
+	kw := seq.Children[0].(Node)
+	assert.Equal(t, Label("kw_if"), kw.Extra)
+	assert.Equal(t, "if", kw.Children[0].(Scanner).String())
+	op := seq.Children[1].(Node).Children[1].(Node)
+	assert.Equal(t, Label("op"), op.Extra)
+	assert.Equal(t, "<=", op.Children[0].(Scanner).String())
+	assert.Equal(t, "if a <= b", unparseString(t, lossless(g.Grammar()), e))
+	assert.Equal(t, "if a <= b", unparseString(t, pretty(g.Grammar()), e))
+
+	// The longest token wins, so iff is an identifier, not if followed by f.
+	e, err = g.Parse("stmt", NewScanner("iff < b"))
+	require.NoError(t, err)
+	assert.Equal(t, Choice(1), e.(Node).Extra)
+
+	// Earlier alternatives win ties, so if is never an identifier.
+	_, err = g.Parse("stmt", NewScanner("a < if"))
+	assert.Error(t, err)
+
+	// An unlabelled alternative can win, but never matches.
+	_, err = g.Parse("stmt", NewScanner("if ("))
+	assert.Error(t, err)
+}
+
+func TestTokenCache(t *testing.T) {
+	t.Parallel()
+	g := tokenGrammar().Compile(nil)
+
+	st := newParseState(nil)
+	var e TreeElement
+	err := g.parsers["stmt"].Parse(Scope{}.withParseState(st), NewScanner("iff < b"), &e, nil)
+	require.NoError(t, err)
+	// tok::kw_if and, twice, tok::ident are tried at the start, but the tokens
+	// there are only matched once.
+	offsets := []int{}
+	for key := range st.tokens {
+		offsets = append(offsets, key.offset)
+	}
+	assert.ElementsMatch(t, []int{0, 4, 6, 7}, offsets)
+}
+
+func TestTokenLabels(t *testing.T) {
+	t.Parallel()
+	labels, err := TokenLabels(tokenGrammar()["tok"])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kw_if", "ident", "number", "op", ""}, labels)
+
+	labels, err = TokenLabels(CutPoint{Eq("x", Seq{S("a"), Quant{Term: RE(`b`), Max: 2}})})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x"}, labels)
+
+	_, err = TokenLabels(tokenGrammar()["stmt"])
+	assert.Error(t, err)
+}
+
+func TestTokenUnknownLabel(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"tok": Oneof{Eq("a", S("a"))},
+		"x":   Token{Rule: "tok", Label: "b"},
+	}
+	_, err := g.Compile(nil).Parse("x", NewScanner("a"))
+	require.IsType(t, StoppedError{}, err)
+	assert.EqualError(t, err, "parse stopped at 1:1: token rule tok has no alternative named b")
+
+	g = Grammar{
+		"tok": Oneof{Eq("a", Rule("y"))},
+		"x":   Token{Rule: "tok", Label: "a"},
+		"y":   S("y"),
+	}
+	_, err = g.Compile(nil).Parse("x", NewScanner("y"))
+	assert.Error(t, err)
+}
+
+func TestTokenLoneLabelledAlternative(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"tok": Eq("a", S("a")),
+		"x":   Token{Rule: "tok", Label: "a"},
+	}
+	for _, opts := range [][]CompileOption{nil, {WithoutOptimization()}} {
+		v, err := g.Compile(nil, opts...).Parse("x", NewScanner("a"))
+		require.NoError(t, err)
+		assert.Equal(t, Label("a"), v.(Node).Extra)
+	}
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceEventKind identifies the step of a parse that a TraceEvent reports.
+type TraceEventKind int
+
+const (
+	// TraceEnter reports that a rule is about to be parsed at Start.
+	TraceEnter TraceEventKind = iota
+
+	// TraceExit reports that a rule entered at Start has finished at End. Err
+	// is set if it failed.
+	TraceExit
+
+	// TraceBacktrack reports that an attempt which got as far as End failed
+	// without committing the parse, so it resumes from Start. This happens when
+	// an alternative of a oneof fails, or a repetition stops.
+	TraceBacktrack
+
+	// TraceCut reports that a cutpoint matched from Start to End, committing
+	// the enclosing rule to the current alternative.
+	TraceCut
+
+	// TraceExternal reports that an external ref (%%name) was invoked at Start
+	// and returned at End. Err is set if it failed.
+	TraceExternal
+)
+
+var traceEventKinds = []string{"enter", "exit", "backtrack", "cut", "external"}
+
+func (k TraceEventKind) String() string {
+	if int(k) < len(traceEventKinds) {
+		return traceEventKinds[k]
+	}
+	return fmt.Sprintf("TraceEventKind(%d)", int(k))
+}
+
+// TraceEvent reports a step of a parse to a Tracer.
+type TraceEvent struct {
+	Kind  TraceEventKind
+	Rule  Rule  // the innermost rule being parsed; for TraceExternal, the name of the ref
+	Start int   // the offset the step started at
+	End   int   // the offset the step ended at
+	Err   error // the reason for failure, if any
+	Depth int   // the number of rules entered and not yet exited
+}
+
+// Tracer receives the steps of a parse run WithTracer.
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// WithTracer reports the steps of the parse to t as they happen. It may be
+// given more than once to report to several tracers.
+func WithTracer(t Tracer) ParseOption {
+	return func(st *parseState) {
+		if st.tracer != nil {
+			st.tracer = tracers{st.tracer, t}
+		} else {
+			st.tracer = t
+		}
+	}
+}
+
+type tracers []Tracer
+
+func (ts tracers) Trace(e TraceEvent) {
+	for _, t := range ts {
+		t.Trace(e)
+	}
+}
+
+// trace reports an event to the tracer of the parse, if any.
+func (s Scope) trace(kind TraceEventKind, rule Rule, start, end int, err error) {
+	st := s.st
+	if st == nil || st.tracer == nil {
+		return
+	}
+	if kind == TraceExit {
+		st.rules = st.rules[:len(st.rules)-1]
+	}
+	if rule == "" && len(st.rules) > 0 {
+		// Terms within a rule's definition are anonymous.
+		rule = st.rules[len(st.rules)-1]
+	}
+	st.tracer.Trace(TraceEvent{Kind: kind, Rule: rule, Start: start, End: end, Err: err, Depth: len(st.rules)})
+	if kind == TraceEnter {
+		st.rules = append(st.rules, rule)
+	}
+}
+
+func (s Scope) tracing() bool {
+	return s.st != nil && s.st.tracer != nil
+}
+
+// traceMessage returns a one-line description of why a step failed.
+func traceMessage(err error) string {
+	var pe ParseError
+	switch err := err.(type) {
+	case ParseError:
+		pe = err
+	case FatalError:
+		pe = err.ParseError
+	}
+	if pe.Expected() != nil {
+		return "expected " + pe.Expected().String()
+	}
+	if msg := pe.Message(); msg != "" {
+		return msg
+	}
+	msg := strings.TrimSpace(err.Error())
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	return msg
+}
+
+// NewTextTracer returns a Tracer that writes each event to w on its own line,
+// indented by its depth.
+func NewTextTracer(w io.Writer) Tracer {
+	return textTracer{w}
+}
+
+type textTracer struct {
+	w io.Writer
+}
+
+func (t textTracer) Trace(e TraceEvent) {
+	var line string
+	switch e.Kind {
+	case TraceEnter:
+		line = fmt.Sprintf("--> %s @%d", e.Rule, e.Start)
+	case TraceExit:
+		line = fmt.Sprintf("<-- %s @%d-%d", e.Rule, e.Start, e.End)
+	case TraceBacktrack:
+		line = fmt.Sprintf("<<< %s @%d <- %d", e.Rule, e.Start, e.End)
+	case TraceCut:
+		line = fmt.Sprintf("!!! %s @%d-%d", e.Rule, e.Start, e.End)
+	case TraceExternal:
+		line = fmt.Sprintf("%%%% %s @%d-%d", e.Rule, e.Start, e.End)
+	default:
+		line = fmt.Sprintf("%s %s @%d-%d", e.Kind, e.Rule, e.Start, e.End)
+	}
+	if e.Err != nil {
+		line += " failed: " + traceMessage(e.Err)
+	}
+	fmt.Fprintf(t.w, "%s%s\n", strings.Repeat("    ", e.Depth), line)
+}
+
+// NewJSONTracer returns a Tracer that writes each event to w as a line of JSON.
+func NewJSONTracer(w io.Writer) Tracer {
+	return jsonTracer{json.NewEncoder(w)}
+}
+
+type jsonTracer struct {
+	enc *json.Encoder
+}
+
+type jsonTraceEvent struct {
+	Event string `json:"event"`
+	Rule  string `json:"rule"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Depth int    `json:"depth"`
+	Error string `json:"error,omitempty"`
+}
+
+func (t jsonTracer) Trace(e TraceEvent) {
+	je := jsonTraceEvent{Event: e.Kind.String(), Rule: string(e.Rule), Start: e.Start, End: e.End, Depth: e.Depth}
+	if e.Err != nil {
+		je.Error = traceMessage(e.Err)
+	}
+	_ = t.enc.Encode(je)
+}
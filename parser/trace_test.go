@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type traceRecorder []string
+
+func (r *traceRecorder) Trace(e TraceEvent) {
+	s := fmt.Sprintf("%d %s %s %d-%d", e.Depth, e.Kind, e.Rule, e.Start, e.End)
+	if e.Err != nil {
+		s += " " + traceMessage(e.Err)
+	}
+	*r = append(*r, s)
+}
+
+var traceGrammar = Grammar{
+	"stmt":    Oneof{Seq{CutPoint{S("let")}, Rule("name"), S("="), Rule("value")}, Seq{S("do"), ExtRef("ext")}},
+	"name":    RE(`[a-z]+`),
+	"value":   Oneof{RE(`\d+`), Rule("name")},
+	".wrapRE": RE(`\s*()\s*`),
+}.Compile(nil)
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+	var r traceRecorder
+	_, err := traceGrammar.Parse("stmt", NewScanner("let a = b"), WithTracer(&r))
+	require.NoError(t, err)
+	assert.Equal(t, traceRecorder{
+		"0 enter stmt 0-0",
+		"1 cut stmt 0-4",
+		"1 enter name 4-4",
+		"1 exit name 4-6",
+		"1 enter value 8-8",
+		"2 backtrack value 8-8 expected /\\d+/",
+		"2 enter name 8-8",
+		"2 exit name 8-9",
+		"1 exit value 8-9",
+		"0 exit stmt 0-9",
+	}, r)
+
+	r = nil
+	_, err = traceGrammar.ParseWithExternals("stmt", NewScanner("do it"), ExternalRefs{
+		"ext": func(scope Scope, input *Scanner) (TreeElement, error) {
+			var eaten Scanner
+			input.Eat(input.sliceLength, &eaten)
+			return eaten, nil
+		},
+	}, WithTracer(&r))
+	require.NoError(t, err)
+	assert.Equal(t, traceRecorder{
+		"0 enter stmt 0-0",
+		"1 backtrack stmt 0-0 could not complete sequence",
+		"1 external ext 3-5",
+		"0 exit stmt 0-5",
+	}, r)
+}
+
+func TestTracerFailure(t *testing.T) {
+	t.Parallel()
+	var r traceRecorder
+	_, err := traceGrammar.Parse("stmt", NewScanner("let 1"), WithTracer(&r))
+	require.Error(t, err)
+	assert.Equal(t, traceRecorder{
+		"0 enter stmt 0-0",
+		"1 cut stmt 0-4",
+		"1 enter name 4-4",
+		"1 exit name 4-4 expected /[a-z]+/",
+		"0 exit stmt 0-0 expected /[a-z]+/",
+	}, r)
+}
+
+func TestTextTracer(t *testing.T) {
+	t.Parallel()
+	var sb strings.Builder
+	_, err := traceGrammar.Parse("stmt", NewScanner("let a = 1"), WithTracer(NewTextTracer(&sb)))
+	require.NoError(t, err)
+	assert.Equal(t, `--> stmt @0
+    !!! stmt @0-4
+    --> name @4
+    <-- name @4-6
+    --> value @8
+    <-- value @8-9
+<-- stmt @0-9
+`, sb.String())
+}
+
+func TestJSONTracer(t *testing.T) {
+	t.Parallel()
+	var sb strings.Builder
+	_, err := traceGrammar.Parse("name", NewScanner("1"), WithTracer(NewJSONTracer(&sb)))
+	require.Error(t, err)
+	assert.Equal(t, `{"event":"enter","rule":"name","start":0,"end":0,"depth":0}
+{"event":"exit","rule":"name","start":0,"end":0,"depth":0,"error":"expected /[a-z]+/"}
+`, sb.String())
+}
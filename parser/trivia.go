@@ -0,0 +1,56 @@
+package parser
+
+import "strings"
+
+// shareTrivia divides the trivia between the tokens of a tree recorded by
+// WithTrivia, so that each token trails the rest of its line and the following
+// token leads with any lines after that. Each token initially holds only what
+// its own .wrapRE match consumed, which for typical patterns, such as
+// `\s*()\s*`, puts all of it behind the earlier token.
+func shareTrivia(e TreeElement) TreeElement {
+	root := []TreeElement{e}
+	var prev []TreeElement // the children holding the previous token, at prevIndex
+	var prevIndex int
+	var visit func(children []TreeElement)
+	visit = func(children []TreeElement) {
+		for i, child := range children {
+			switch child := child.(type) {
+			case Scanner:
+				if prev != nil {
+					last := prev[prevIndex].(Scanner)
+					if child.sliceStart < last.sliceStart+last.sliceLength {
+						// Out of order.
+						continue
+					}
+					if divideTrivia(&last, &child) {
+						prev[prevIndex] = last
+						children[i] = child
+					}
+				}
+				prev, prevIndex = children, i
+			case Node:
+				if !isLookaround(child) {
+					visit(child.Children)
+				}
+			}
+		}
+	}
+	visit(root)
+	return root[0]
+}
+
+// divideTrivia divides the text between two tokens at its first newline if it
+// is all trivia.
+func divideTrivia(prev, next *Scanner) bool {
+	start, end := prev.sliceStart+prev.sliceLength, next.sliceStart
+	if prev.src == nil || !sameSource(prev.src, next.src) || start+prev.trailing != end-next.leading {
+		return false
+	}
+	gap := next.src.slice(start, end-start)
+	prev.trailing = len(gap)
+	if i := strings.IndexByte(gap, '\n'); i >= 0 {
+		prev.trailing = i + 1
+	}
+	next.leading = len(gap) - prev.trailing
+	return true
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var triviaGrammar = Grammar{
+	"stmts":   Any(Rule("stmt")),
+	"stmt":    Seq{S("let"), RE(`[a-z]+`), S("="), RE(`\d+|[a-z]+`), S(";")},
+	".wrapRE": RE(`(?:\s|//[^\n]*)*()(?:\s|//[^\n]*)*`),
+}.Compile(nil)
+
+// trivia lists the leading trivia, text and trailing trivia of every token.
+func trivia(e TreeElement, out [][3]string) [][3]string {
+	switch e := e.(type) {
+	case Scanner:
+		out = append(out, [3]string{e.Leading().String(), e.String(), e.Trailing().String()})
+	case Node:
+		for _, child := range e.Children {
+			out = trivia(child, out)
+		}
+	}
+	return out
+}
+
+func TestTrivia(t *testing.T) {
+	t.Parallel()
+	input := "// head\nlet a = 1; // one\n\n// two\nlet b=a;\n"
+	e, err := triviaGrammar.Parse("stmts", NewScanner(input), WithTrivia())
+	require.NoError(t, err)
+	assert.Equal(t, [][3]string{
+		{"// head\n", "let", " "},
+		{"", "a", " "},
+		{"", "=", " "},
+		{"", "1", ""},
+		{"", ";", " // one\n"},
+		{"\n// two\n", "let", " "},
+		{"", "b", ""},
+		{"", "=", ""},
+		{"", "a", ""},
+		{"", ";", "\n"},
+	}, trivia(e, nil))
+
+	e, err = triviaGrammar.Parse("stmts", NewScanner(input))
+	require.NoError(t, err)
+	for _, token := range trivia(e, nil) {
+		assert.Empty(t, token[0])
+		assert.Empty(t, token[2])
+	}
+}
+
+func TestTriviaMergeScanners(t *testing.T) {
+	t.Parallel()
+	input := "let a = 1; // one\n// two\nlet b = 2; // three\n"
+	e, err := triviaGrammar.Parse("stmts", NewScanner(input), WithTrivia())
+	require.NoError(t, err)
+	stmt := e.(Node).Children[1].(Node)
+	var tokens []Scanner
+	for _, child := range stmt.Children {
+		tokens = append(tokens, child.(Scanner))
+	}
+	s, err := MergeScanners(tokens...)
+	require.NoError(t, err)
+	assert.Equal(t, "let b = 2;", s.String())
+	assert.Equal(t, "// two\n", s.Leading().String())
+	assert.Equal(t, " // three\n", s.Trailing().String())
+	assert.Empty(t, s.StripSource().Leading().String())
+}
+
+func TestTriviaStream(t *testing.T) {
+	t.Parallel()
+	input := "let a = 1; // one\n// two\nlet b = 2;"
+	var stmts [][][3]string
+	require.NoError(t, triviaGrammar.ParseStream("stmt", NewScannerFromReader(strings.NewReader(input), ""),
+		func(e TreeElement) error {
+			stmts = append(stmts, trivia(e, nil))
+			return nil
+		}, WithTrivia()))
+	require.Len(t, stmts, 2)
+	assert.Equal(t, [3]string{"", ";", " // one\n// two\n"}, stmts[0][4])
+	assert.Equal(t, [3]string{"", "let", " "}, stmts[1][0])
+}
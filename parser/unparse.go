@@ -2,26 +2,60 @@ package parser
 
 import (
 	"io"
+	"regexp"
+	"strings"
 
 	"github.com/arr-ai/wbnf/errors"
 )
 
 // The following methods assume a valid parse. Call (Term).ValidateParse first if
-// unsure.
+// unsure. They write tokens through writeToken so that, under UnparsePretty,
+// tokens are separated appropriately.
 
 func (t S) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
-	return w.Write([]byte(e.(Scanner).String()))
+	if s, ok := e.(Scanner); ok {
+		return writeToken(w, s)
+	}
+	// The token was left out of a hand-built tree.
+	return writeToken(w, *NewScanner(string(t)))
+}
+
+func (t SI) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	if s, ok := e.(Scanner); ok {
+		return writeToken(w, s)
+	}
+	return writeToken(w, *NewScanner(string(t)))
+}
+
+func (t Position) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	if s, ok := e.(Scanner); ok {
+		return writeToken(w, s)
+	}
+	return 0, nil
+}
+
+func (t Token) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	return writeToken(w, e.(Node).Children[0].(Scanner))
 }
 
 func (t RE) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
-	return w.Write([]byte(e.(Scanner).String()))
+	return writeToken(w, e.(Scanner))
 }
+
 func (t REF) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
-	return w.Write([]byte("\\" + e.(Scanner).String()))
+	return writeTree(w, e)
 }
 
 func unparse(g Grammar, term Term, e TreeElement, w io.Writer, N *int) error {
-	n, err := term.Unparse(g, e, w)
+	var n int
+	var err error
+	switch e := e.(type) {
+	case Empty:
+	case ErrorNode:
+		n, err = writeToken(w, e.Skipped)
+	default:
+		n, err = term.Unparse(g, e, w)
+	}
 	if err == nil {
 		*N += n
 	}
@@ -54,13 +88,32 @@ func (t Delim) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error)
 	return
 }
 
+// Lookarounds consume nothing, so they have nothing to write.
+
 func (t LookAhead) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
-	for _, child := range e.(Node).Children {
-		if err = unparse(g, t.Term, child, w, &n); err != nil {
-			return
-		}
+	return 0, nil
+}
+
+func (t NotLookAhead) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	return 0, nil
+}
+
+func (t LookBehind) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	return 0, nil
+}
+
+func (t NotLookBehind) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	return 0, nil
+}
+
+// isLookaround reports whether a node was produced by a lookaround term, whose
+// tokens, if any, belong to the terms around it.
+func isLookaround(n Node) bool {
+	switch n.Tag {
+	case lookaheadTag, notAheadTag, lookbehindTag, notBehindTag:
+		return true
 	}
-	return
+	return false
 }
 
 func (t Quant) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
@@ -94,13 +147,205 @@ func (t Named) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error)
 //-----------------------------------------------------------------------------
 
 func (t ScopedGrammar) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
-	panic(errors.Inconceivable)
+	scoped := g.clone()
+	for rule, term := range t.Grammar.withoutStacks() {
+		scoped[rule] = term
+	}
+	return t.Term.Unparse(scoped, e, w)
 }
 
 func (t CutPoint) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
 	return t.Term.Unparse(g, e, w)
 }
 
-func (t ExtRef) Unparse(g Grammar, te TreeElement, w io.Writer) (n int, err error) {
-	panic("implement me")
+func (t ExtRef) Unparse(g Grammar, e TreeElement, w io.Writer) (n int, err error) {
+	return writeTree(w, e)
+}
+
+//-----------------------------------------------------------------------------
+
+// writeToken writes a token, separated from the previous one if w is a
+// tokenWriter.
+func writeToken(w io.Writer, s Scanner) (int, error) {
+	if tw, ok := w.(*tokenWriter); ok {
+		return tw.token(s)
+	}
+	return io.WriteString(w, s.String())
+}
+
+// writeTree writes the tokens of a tree without reference to the grammar that
+// produced it. Elements other than those produced by the parser, such as the
+// results of external refs, are written as their Scanner if they have one.
+func writeTree(w io.Writer, e TreeElement) (n int, err error) {
+	var visit func(e TreeElement) error
+	visit = func(e TreeElement) error {
+		var m int
+		var err error
+		switch e := e.(type) {
+		case Scanner:
+			m, err = writeToken(w, e)
+		case ErrorNode:
+			m, err = writeToken(w, e.Skipped)
+		case Node:
+			if isLookaround(e) {
+				return nil
+			}
+			for _, child := range e.Children {
+				if err := visit(child); err != nil {
+					return err
+				}
+			}
+		case *Node:
+			return visit(*e)
+		case interface{ Scanner() Scanner }:
+			m, err = writeToken(w, e.Scanner())
+		}
+		n += m
+		return err
+	}
+	err = visit(e)
+	return n, err
+}
+
+// wrapping holds regexps derived from .wrapRE to match the text it consumes
+// before and after a token.
+type wrapping struct {
+	before *regexp.Regexp // the text consumed before a token, at the end of a string
+	after  *regexp.Regexp // the text consumed after a token, at the start of a string
+	gap    *regexp.Regexp // the text consumed between two tokens
+}
+
+// leading returns the end of text that .wrapRE would consume before a token.
+func (r *wrapping) leading(text string) string {
+	if loc := r.before.FindStringIndex(text); loc != nil {
+		return text[loc[0]:]
+	}
+	return ""
+}
+
+// trailing returns the text that .wrapRE would consume after a token ending at
+// offset i of src.
+func (r *wrapping) trailing(src source, i int) string {
+	if src, ok := src.(interface{ buffered(i int) string }); ok {
+		// Don't read more of the stream than the parser did.
+		text := src.buffered(i)
+		if loc := r.after.FindStringIndex(text); loc != nil {
+			return text[:loc[1]]
+		}
+		return ""
+	}
+	for size := 256; ; size *= 2 {
+		rest := src.length() - i
+		last := size >= rest
+		if last {
+			size = rest
+		}
+		text := src.slice(i, size)
+		loc := r.after.FindStringIndex(text)
+		if loc == nil {
+			return ""
+		}
+		if last || loc[1] < len(text) {
+			return text[:loc[1]]
+		}
+	}
+}
+
+func newWrapping(g Grammar) *wrapping {
+	pre, post, ok := wrapParts(g)
+	if !ok {
+		return nil
+	}
+	before, err1 := regexp.Compile(`(?:` + pre + `)\z`)
+	after, err2 := regexp.Compile(`\A(?:` + post + `)`)
+	gap, err3 := regexp.Compile(`\A(?:` + post + `)(?:` + pre + `)\z`)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil
+	}
+	return &wrapping{before: before, after: after, gap: gap}
+}
+
+// tokenWriter writes tokens along with the text between them.
+type tokenWriter struct {
+	w      io.Writer
+	n      int
+	prev   *Scanner  // the last token written
+	wrap   *wrapping // nil if the grammar has no .wrapRE
+	pretty bool
+}
+
+func newTokenWriter(g Grammar, w io.Writer, pretty bool) *tokenWriter {
+	return &tokenWriter{w: w, wrap: newWrapping(g), pretty: pretty}
+}
+
+func (w *tokenWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	return n, err
+}
+
+func (w *tokenWriter) token(s Scanner) (int, error) {
+	if w.pretty && s.sliceLength == 0 {
+		return 0, nil
+	}
+	var sb strings.Builder
+	if w.prev == nil {
+		if w.wrap != nil && s.src != nil {
+			before := s.src.slice(0, s.sliceStart)
+			// Under UnparsePretty, only keep text that started the source.
+			if leading := w.wrap.leading(before); !w.pretty || len(leading) == len(before) {
+				sb.WriteString(leading)
+			}
+		}
+	} else {
+		sb.WriteString(w.separator(*w.prev, s))
+	}
+	sb.WriteString(s.String())
+	w.prev = &s
+	return w.Write([]byte(sb.String()))
+}
+
+// separator returns the text to write between two tokens. The source text
+// between them is kept if they are in order in the same source; under
+// UnparsePretty, only whitespace that .wrapRE would consume is kept, and tokens
+// without any are separated by a space.
+func (w *tokenWriter) separator(prev, s Scanner) string {
+	var gap string
+	adjacent := prev.src != nil && sameSource(prev.src, s.src) && s.sliceStart >= prev.sliceStart+prev.sliceLength
+	if adjacent {
+		start := prev.sliceStart + prev.sliceLength
+		gap = s.src.slice(start, s.sliceStart-start)
+	}
+	if !w.pretty {
+		return gap
+	}
+	if w.wrap == nil {
+		return ""
+	}
+	if adjacent {
+		if w.wrap.gap.MatchString(gap) {
+			return gap
+		}
+		// Tokens between them have been removed, so keep just the text before s.
+		if before := w.wrap.leading(gap); before != "" {
+			return before
+		}
+	}
+	return " "
+}
+
+// close writes the text consumed by .wrapRE after the last token. Under
+// UnparsePretty, the text is only kept if it ended the source.
+func (w *tokenWriter) close() (int, error) {
+	if w.prev != nil && w.wrap != nil && w.prev.src != nil {
+		end := w.prev.sliceStart + w.prev.sliceLength
+		trailing := w.wrap.trailing(w.prev.src, end)
+		if w.pretty && end+len(trailing) < w.prev.src.length() {
+			return w.n, nil
+		}
+		if _, err := io.WriteString(w, trailing); err != nil {
+			return w.n, err
+		}
+	}
+	return w.n, nil
 }
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unparseString(t *testing.T, unparse func(TreeElement, *strings.Builder) (int, error), e TreeElement) string {
+	t.Helper()
+	var sb strings.Builder
+	n, err := unparse(e, &sb)
+	require.NoError(t, err)
+	assert.Equal(t, sb.Len(), n)
+	return sb.String()
+}
+
+func lossless(g Grammar) func(TreeElement, *strings.Builder) (int, error) {
+	return func(e TreeElement, sb *strings.Builder) (int, error) { return g.Unparse(e, sb) }
+}
+
+func pretty(g Grammar) func(TreeElement, *strings.Builder) (int, error) {
+	return func(e TreeElement, sb *strings.Builder) (int, error) { return g.UnparsePretty(e, sb) }
+}
+
+func TestUnparseLossless(t *testing.T) {
+	t.Parallel()
+	for _, input := range []string{
+		"let a = 1;",
+		"  let a=1;let b = 2 +\n\ta;\n\n",
+	} {
+		e, err := letGrammar.Parse("stmts", NewScanner(input))
+		require.NoError(t, err)
+		assert.Equal(t, input, unparseString(t, lossless(letGrammar.Grammar()), e), "%q", input)
+	}
+
+	// Without a .wrapRE, there is nothing around the tokens to reproduce.
+	g := Grammar{"a": Seq{S("x"), RE(`\s+`), S("y")}}.Compile(nil)
+	e, err := g.Parse("a", NewScanner("x \n y"))
+	require.NoError(t, err)
+	assert.Equal(t, "x \n y", unparseString(t, lossless(g.Grammar()), e))
+}
+
+func TestUnparseTerms(t *testing.T) {
+	t.Parallel()
+	g := Grammar{
+		"a": Seq{
+			Eq("x", RE(`\w+`)),
+			ScopedGrammar{Term: Rule("b"), Grammar: Grammar{"b": Stack{Seq{S("("), At, S(")")}, S("-")}}},
+			REF{Ident: "x"},
+			ExtRef("ext"),
+		},
+		".wrapRE": RE(`\s*()\s*`),
+	}.Compile(nil)
+	input := "foo ( - ) foo<ext>"
+	e, err := g.ParseWithExternals("a", NewScanner(input), ExternalRefs{
+		"ext": func(scope Scope, input *Scanner) (TreeElement, error) {
+			var eaten Scanner
+			input.Eat(5, &eaten)
+			return eaten, nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, input, unparseString(t, lossless(g.Grammar()), e))
+	assert.Equal(t, input, unparseString(t, pretty(g.Grammar()), e))
+}
+
+func TestUnparsePretty(t *testing.T) {
+	t.Parallel()
+	input := "let a = 1;\n  let b = 2 + a;\nlet c = b;\n"
+	e, err := letGrammar.Parse("stmts", NewScanner(input))
+	require.NoError(t, err)
+	stmts := e.(Node)
+	unparse := pretty(letGrammar.Grammar())
+	assert.Equal(t, input, unparseString(t, unparse, stmts))
+
+	// Rename b.
+	stmt := stmts.Children[1].(Node)
+	children := append([]TreeElement{}, stmt.Children...)
+	children[1] = *NewScanner("bee")
+	stmts.Children[1] = Node{Tag: stmt.Tag, Extra: stmt.Extra, Children: children}
+	assert.Equal(t, "let a = 1;\n  let bee = 2 + a;\nlet c = b;\n", unparseString(t, unparse, stmts))
+
+	// Drop the first statement and let the second regenerate its keyword.
+	children[0] = nil
+	stmts.Children = stmts.Children[1:]
+	assert.Equal(t, "let bee = 2 + a;\nlet c = b;\n", unparseString(t, unparse, stmts))
+
+	// Drop the middle statement, keeping the whitespace before the last.
+	e, err = letGrammar.Parse("stmts", NewScanner(input))
+	require.NoError(t, err)
+	stmts = e.(Node)
+	stmts.Children = []TreeElement{stmts.Children[0], stmts.Children[2]}
+	assert.Equal(t, "let a = 1;\nlet c = b;\n", unparseString(t, unparse, stmts))
+
+	// Swap them.
+	stmts.Children = []TreeElement{stmts.Children[1], stmts.Children[0]}
+	assert.Equal(t, "let c = b; let a = 1;", unparseString(t, unparse, stmts))
+}
@@ -2,7 +2,9 @@ package wbnf
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -19,6 +21,32 @@ func unfakeBackquote(s string) string {
 	return strings.ReplaceAll(s, "‵", "`")
 }
 
+func buildPosition(pos PosNode) parser.Term {
+	t := parser.Position{
+		Prop: parser.PositionProp(strings.TrimPrefix(pos.OneProp(), "@")),
+		Op:   pos.OneOp(),
+	}
+	if ident := pos.OneIdent(); ident != nil {
+		t.Ref = ident.String()
+	} else if n := pos.OneInt(); n != nil {
+		value, err := strconv.Atoi(n.String())
+		if err != nil {
+			panic(err)
+		}
+		t.Value = value
+	}
+	return t
+}
+
+// stringTerm returns the term for a string literal, which matches
+// case-insensitively if it is prefixed with i.
+func stringTerm(lit string) parser.Term {
+	if strings.HasPrefix(lit, "i") {
+		return parser.SI(parseString(lit[1:]))
+	}
+	return parser.S(parseString(lit))
+}
+
 func parseString(s string) string {
 	var sb strings.Builder
 	quote, s := s[0], s[1:len(s)-1]
@@ -98,7 +126,11 @@ type grammarBuilder struct {
 
 func (gb grammarBuilder) expandMacro(node MacrocallNode) parser.Term {
 	name := node.OneName().String()
-	macro := gb.macros[name]
+	macro, has := gb.macros[name]
+	if !has || len(macro.AllArgs()) != len(node.AllTerm()) {
+		// Validation reports it.
+		return parser.Seq{}
+	}
 	g := parser.Grammar{parser.Rule(name): gb.buildTerm(*macro.OneTerm())}
 
 	newg := rebuildGrammar(g, func(t parser.Term) parser.Term {
@@ -116,10 +148,10 @@ func (gb grammarBuilder) expandMacro(node MacrocallNode) parser.Term {
 }
 
 func (gb grammarBuilder) buildAtom(atom AtomNode) parser.Term {
-	x, _ := ast.Which(atom.Node.(ast.Branch), "RE", "STR", "macrocall", "ExtRef", "IDENT", "REF", "lookahead", "term")
+	x, _ := ast.Which(atom.Node.(ast.Branch), "RE", "STR", "macrocall", "ExtRef", "IDENT", "REF", "lookahead", "notlookahead", "lookbehind", "notlookbehind", "term", "pos", "tokref")
 	name := ""
 	switch x {
-	case "lookahead", "term", "REF", "ExtRef", "macrocall", "":
+	case "lookahead", "notlookahead", "lookbehind", "notlookbehind", "term", "pos", "tokref", "REF", "ExtRef", "macrocall", "":
 	default:
 		name = atom.One(x).Scanner().String()
 	}
@@ -128,7 +160,7 @@ func (gb grammarBuilder) buildAtom(atom AtomNode) parser.Term {
 	case "IDENT":
 		return parser.Rule(name)
 	case "STR":
-		return parser.S(parseString(name))
+		return stringTerm(name)
 	case "RE":
 		s := whitespaceRE.ReplaceAllString(name, "")
 		// Do this twice to cover adjacent escaped spaces `\_\_`.
@@ -145,7 +177,7 @@ func (gb grammarBuilder) buildAtom(atom AtomNode) parser.Term {
 			Default: nil,
 		}
 		if defTerm := refNode.OneDefault().String(); defTerm != "" {
-			ref.Default = parser.S(parseString(defTerm))
+			ref.Default = stringTerm(defTerm)
 		}
 		return ref
 	case "ExtRef":
@@ -155,8 +187,25 @@ func (gb grammarBuilder) buildAtom(atom AtomNode) parser.Term {
 		return parser.LookAhead{
 			Term: gb.buildTerm(*atom.OneLookahead()),
 		}
+	case "notlookahead":
+		return parser.NotLookAhead{
+			Term: gb.buildTerm(*atom.OneNotlookahead()),
+		}
+	case "lookbehind":
+		return parser.LookBehind{
+			Term: gb.buildTerm(*atom.OneLookbehind()),
+		}
+	case "notlookbehind":
+		return parser.NotLookBehind{
+			Term: gb.buildTerm(*atom.OneNotlookbehind()),
+		}
 	case "term":
 		return gb.buildTerm(*atom.OneTerm())
+	case "pos":
+		return buildPosition(*atom.OnePos())
+	case "tokref":
+		tokref := atom.OneTokref()
+		return parser.Token{Rule: parser.Rule(tokref.OneIdent().String()), Label: tokref.OneLabel().String()}
 	case "macrocall":
 		return gb.expandMacro(*atom.OneMacrocall())
 	}
@@ -237,8 +286,15 @@ func (gb grammarBuilder) buildTerm(t TermNode) parser.Term {
 		}
 		if len(terms) == 1 {
 			if sg != nil {
-				sg.Term = terms[0]
-				return *sg
+				if stack, ok := terms[0].(parser.Stack); ok {
+					// Each level of a stack is scoped, so it stays a stack.
+					scoped := make(parser.Stack, 0, len(stack))
+					for _, level := range stack {
+						scoped = append(scoped, scopeTerm(*sg, level))
+					}
+					return scoped
+				}
+				return scopeTerm(*sg, terms[0])
 			}
 			return terms[0]
 		}
@@ -258,6 +314,18 @@ func (gb grammarBuilder) buildTerm(t TermNode) parser.Term {
 	return next
 }
 
+// scopeTerm returns term within the scope of sg. A rule defined outside the
+// scope is parsed in its own scope, so a reference to it isn't scoped.
+func scopeTerm(sg parser.ScopedGrammar, term parser.Term) parser.Term {
+	if rule, ok := term.(parser.Rule); ok {
+		if _, local := sg.Grammar[rule]; !local {
+			return rule
+		}
+	}
+	sg.Term = term
+	return sg
+}
+
 func (gb grammarBuilder) buildProd(p ProdNode) parser.Term {
 	children := p.AllTerm()
 	if len(children) == 1 {
@@ -270,18 +338,56 @@ func (gb grammarBuilder) buildProd(p ProdNode) parser.Term {
 	return seq
 }
 
+// buildGrammar builds the rules defined with -> and then, in order, applies the
+// overrides (=) and extensions (|=) of rules.
 func (gb grammarBuilder) buildGrammar(node ast.Node) parser.Grammar {
 	g := parser.Grammar{}
 	tree := NewGrammarNode(node)
+	var edits []ProdNode
 	for _, stmt := range tree.AllStmt() {
 		if prod := stmt.OneProd(); prod != nil {
+			if prodOp(*prod) != defineOp {
+				edits = append(edits, *prod)
+				continue
+			}
 			g[parser.Rule(prod.OneIdent().String())] = gb.buildProd(*prod)
 		}
 	}
+	for _, prod := range edits {
+		rule := parser.Rule(prod.OneIdent().String())
+		term, has := g[rule]
+		switch {
+		case !has:
+			// Validation reports it.
+		case prodOp(prod) == overrideOp:
+			g[rule] = gb.buildProd(prod)
+		default:
+			g[rule] = extendTerm(term, gb.buildProd(prod))
+		}
+	}
 	return g
 }
 
-func NewFromAst(node ast.Node) parser.Grammar {
+// extendTerm adds the alternatives of ext to term. Those of a precedence stack
+// are added to its last level.
+func extendTerm(term, ext parser.Term) parser.Term {
+	if stack, ok := term.(parser.Stack); ok {
+		stack = append(parser.Stack{}, stack...)
+		stack[len(stack)-1] = extendTerm(stack[len(stack)-1], ext)
+		return stack
+	}
+	alts, ok := term.(parser.Oneof)
+	if !ok {
+		alts = parser.Oneof{term}
+	}
+	alts = append(parser.Oneof{}, alts...)
+	if more, ok := ext.(parser.Oneof); ok {
+		return append(alts, more...)
+	}
+	return append(alts, ext)
+}
+
+func newGrammarBuilder(node ast.Node) grammarBuilder {
 	gb := grammarBuilder{
 		macros: map[string]PragmaMacrodefNode{},
 	}
@@ -291,14 +397,28 @@ func NewFromAst(node ast.Node) parser.Grammar {
 			return nil
 		},
 	}.Walk(NewGrammarNode(node))
-	g := gb.buildGrammar(node)
+	return gb
+}
+
+func NewFromAst(node ast.Node) parser.Grammar {
+	g := newGrammarBuilder(node).buildGrammar(node)
 
 	return insertCutPoints(g)
 }
 
-func mergeGrammarNodes(a, b ast.Branch) ast.Node {
-	a["stmt"] = append(a["stmt"].(ast.Many), b.Many("stmt")...)
-	return a
+// mergeGrammarNodes returns grammar a preceded by the statements of the grammars
+// it imports, so that a's overrides and extensions apply after theirs.
+func mergeGrammarNodes(a ast.Branch, imported ...ast.Branch) ast.Node {
+	var stmts ast.Many
+	for _, b := range imported {
+		stmts = append(stmts, b.Many("stmt")...)
+	}
+	out := ast.Branch{}
+	for key, children := range a {
+		out[key] = children
+	}
+	out["stmt"] = append(stmts, a.Many("stmt")...)
+	return out
 }
 
 type ImportResolver interface {
@@ -306,16 +426,59 @@ type ImportResolver interface {
 	Resolve(from, path string) string
 }
 
+// ImportReader is an ImportResolver that also reads the files it resolves.
+// The files resolved by other ImportResolvers are read from the OS.
+type ImportReader interface {
+	ImportResolver
+	// ReadFile returns the contents of the file at a path returned by Resolve.
+	ReadFile(path string) ([]byte, error)
+}
+
+// FSResolver resolves imports relative to the importing file in a file system,
+// such as an embed.FS, and reads them from it.
+type FSResolver struct {
+	FS fs.FS
+}
+
+func (r FSResolver) Resolve(from, p string) string {
+	return path.Join(path.Dir(filepath.ToSlash(from)), filepath.ToSlash(p))
+}
+
+func (r FSResolver) ReadFile(p string) ([]byte, error) {
+	return fs.ReadFile(r.FS, filepath.ToSlash(p))
+}
+
 type compiler struct {
 	imports  map[string]GrammarNode
 	resolver ImportResolver
+	loading  []string // the chain of imports being loaded
+	findings []error  // the problems found composing imports
+
+	// The names that qualify the hidden rules of each imported file, and the
+	// names taken.
+	hidden      map[string]string
+	hiddenTaken map[string]bool
+}
+
+func newCompiler(resolver ImportResolver) *compiler {
+	return &compiler{
+		imports:     map[string]GrammarNode{},
+		resolver:    resolver,
+		hidden:      map[string]string{},
+		hiddenTaken: map[string]bool{},
+	}
 }
 
 func (c *compiler) makeGrammar(filename, text string) (GrammarNode, error) {
+	if filename != "" {
+		c.loading = append(c.loading, filename)
+		defer func() { c.loading = c.loading[:len(c.loading)-1] }()
+	}
 	node, err := Parse(parser.NewScannerWithFilename(text, filename))
 	if err != nil {
 		return GrammarNode{}, err
 	}
+	var imported []ast.Branch
 	WalkerOps{
 		EnterPragmaImportNode: func(impNode PragmaImportNode) Stopper {
 			importPath := filepath.Join(impNode.OnePath().AllToken()...)
@@ -328,8 +491,12 @@ func (c *compiler) makeGrammar(filename, text string) (GrammarNode, error) {
 				return &aborter{}
 			}
 			if nested.Node != nil {
-				x := mergeGrammarNodes(node.Node.(ast.Branch), nested.Node.(ast.Branch))
-				node = GrammarNode{Node: x}
+				var findings []error
+				nested, findings = composeImport(impNode, c.hiddenName(importPath), nested)
+				c.findings = append(c.findings, findings...)
+			}
+			if nested.Node != nil {
+				imported = append(imported, nested.Node.(ast.Branch))
 			}
 			return nil
 		},
@@ -337,13 +504,26 @@ func (c *compiler) makeGrammar(filename, text string) (GrammarNode, error) {
 	if err != nil {
 		return GrammarNode{}, err
 	}
+	if len(imported) > 0 {
+		node = GrammarNode{Node: mergeGrammarNodes(node.Node.(ast.Branch), imported...)}
+	}
 	return node, nil
 }
 
 func (c *compiler) loadGrammarFile(filename string) (GrammarNode, error) {
 	filename = filepath.Clean(filename)
+	for _, loading := range c.loading {
+		if loading == filename {
+			return GrammarNode{}, fmt.Errorf("import cycle: %s",
+				strings.Join(append(append([]string{}, c.loading...), filename), " -> "))
+		}
+	}
 	if _, has := c.imports[filename]; !has {
-		text, err := os.ReadFile(filename)
+		read := os.ReadFile
+		if reader, ok := c.resolver.(ImportReader); ok {
+			read = reader.ReadFile
+		}
+		text, err := read(filename)
 		if err != nil {
 			return GrammarNode{}, err
 		}
@@ -356,23 +536,45 @@ func (c *compiler) loadGrammarFile(filename string) (GrammarNode, error) {
 	return c.imports[filename], nil
 }
 
-func Compile(grammar string, resolver ImportResolver) (parser.Parsers, error) {
-	c := compiler{
-		imports:  map[string]GrammarNode{},
-		resolver: resolver,
-	}
+// Load parses grammar and merges in the grammars it imports, without validating
+// or compiling the result. The problems found applying the imports, such as a
+// rename of a rule the imported grammar lacks, are returned as findings, which
+// Validate and Lint report along with their own. The error is only set if the
+// grammar or an import can't be read or parsed.
+func Load(grammar string, resolver ImportResolver) (GrammarNode, []error, error) {
+	c := newCompiler(resolver)
 	node, err := c.makeGrammar("", grammar)
+	return node, c.findings, err
+}
+
+func Compile(grammar string, resolver ImportResolver, opts ...parser.CompileOption) (parser.Parsers, error) {
+	node, findings, err := Load(grammar, resolver)
 	if err != nil {
 		return parser.Parsers{}, err
 	}
-	if err := validate(node); err != nil {
+	return compile(node, findings, opts)
+}
+
+// CompileFS compiles the grammar in the file at path in fsys, such as an
+// embed.FS, from which the files it imports are read too.
+func CompileFS(fsys fs.FS, path string, opts ...parser.CompileOption) (parser.Parsers, error) {
+	c := newCompiler(FSResolver{FS: fsys})
+	node, err := c.loadGrammarFile(path)
+	if err != nil {
+		return parser.Parsers{}, err
+	}
+	return compile(node, c.findings, opts)
+}
+
+func compile(node GrammarNode, findings []error, opts []parser.CompileOption) (parser.Parsers, error) {
+	if err := validate(node, findings...); err != nil {
 		return parser.Parsers{}, err
 	}
-	return NewFromAst(node).Compile(node), nil
+	return NewFromAst(node).Compile(node, opts...), nil
 }
 
-func MustCompile(grammar string, resolver ImportResolver) parser.Parsers {
-	p, err := Compile(grammar, resolver)
+func MustCompile(grammar string, resolver ImportResolver, opts ...parser.CompileOption) parser.Parsers {
+	p, err := Compile(grammar, resolver, opts...)
 	if err != nil {
 		panic(err)
 	}
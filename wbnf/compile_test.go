@@ -0,0 +1,59 @@
+package wbnf
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arr-ai/wbnf/parser"
+)
+
+func TestCompileFS(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"grammars/main.wbnf": {Data: []byte(`.import lib/expr.wbnf
+			doc -> expr:";";
+		`)},
+		"grammars/lib/expr.wbnf": {Data: []byte(`.import ../common/int.wbnf
+			expr -> @:op="+" > INT;
+			.wrapRE -> /{\s*()\s*};
+		`)},
+		"grammars/common/int.wbnf": {Data: []byte(`INT -> \d+;`)},
+	}
+
+	p, err := CompileFS(fsys, "grammars/main.wbnf")
+	require.NoError(t, err)
+	_, err = p.Parse("doc", parser.NewScanner("1 + 2; 3"))
+	assert.NoError(t, err)
+
+	p, err = Compile(`.import grammars/lib/expr.wbnf`, FSResolver{FS: fsys})
+	require.NoError(t, err)
+	_, err = p.Parse("expr", parser.NewScanner("1 + 2"))
+	assert.NoError(t, err)
+
+	_, err = CompileFS(fsys, "grammars/missing.wbnf")
+	assert.Error(t, err)
+}
+
+func TestImportCycle(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"main.wbnf": {Data: []byte(".import a.wbnf\nx -> a;")},
+		"a.wbnf":    {Data: []byte(".import b.wbnf\na -> b;")},
+		"b.wbnf":    {Data: []byte(".import a.wbnf\nb -> 'b';")},
+	}
+	_, err := CompileFS(fsys, "main.wbnf")
+	assert.EqualError(t, err, "import cycle: main.wbnf -> a.wbnf -> b.wbnf -> a.wbnf")
+
+	// Importing a file twice, but not in a cycle, is fine.
+	fsys = fstest.MapFS{
+		"main.wbnf": {Data: []byte(".import a.wbnf\n.import b.wbnf (b)\nx -> a b;")},
+		"a.wbnf":    {Data: []byte(".import c.wbnf (c)\na -> c;")},
+		"b.wbnf":    {Data: []byte(".import c.wbnf as c\nb -> c.c;")},
+		"c.wbnf":    {Data: []byte("c -> 'c';")},
+	}
+	_, err = CompileFS(fsys, "main.wbnf")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,275 @@
+package wbnf
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/arr-ai/wbnf/ast"
+)
+
+// The operators of a prod.
+const (
+	defineOp   = "->"
+	extendOp   = "|="
+	overrideOp = "="
+)
+
+// prodOp returns the operator of a prod.
+func prodOp(prod ProdNode) string {
+	if op := prod.OneOp(); op != nil {
+		return op.OneToken()
+	}
+	return defineOp
+}
+
+// isMagicRule reports whether a rule, such as .wrapRE, configures the parser
+// rather than matching anything. They are never renamed or hidden. Those of a
+// namespaced or selective import only apply to its own rules.
+func isMagicRule(rule string) bool {
+	return strings.HasPrefix(rule, ".")
+}
+
+// hiddenRule returns the name under which a rule not exported by an imported
+// file is merged, given the file's hidden name. It can't be written as an
+// identifier, so the importing grammar can neither refer to it nor clash with
+// it.
+func hiddenRule(file, rule string) string {
+	return file + "#" + rule
+}
+
+// hiddenName returns the name that qualifies the hidden rules of an imported
+// file: its base name, numbered if a different file with that base name was
+// imported before it.
+func (c *compiler) hiddenName(filename string) string {
+	filename = filepath.Clean(filename)
+	if name, has := c.hidden[filename]; has {
+		return name
+	}
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	name := base
+	for n := 2; c.hiddenTaken[name]; n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	c.hidden[filename], c.hiddenTaken[name] = name, true
+	return name
+}
+
+// composeImport applies the namespace, selection, prefix and renames of an
+// import, and the exports of the imported grammar, to the rules of the imported
+// grammar so that it can be merged into the importing one. The rules it hides
+// are qualified by file. It also returns the problems it found, leaving out the
+// exports, selections and renames at fault.
+func composeImport(imp PragmaImportNode, file string, nested GrammarNode) (GrammarNode, []error) {
+	namespace := ""
+	if ns := imp.OneNamespace(); ns != nil {
+		namespace = ns.String()
+	}
+	selected := imp.AllRules()
+	// The rules of a namespaced or selective import are embedded in the
+	// importing grammar, so its magic rules are scoped to them.
+	embedded := namespace != "" || len(selected) > 0
+	var magic ast.Many
+
+	rules := map[string]bool{}
+	var exports []IdentNode
+	stmts := make([]ast.Node, 0, len(nested.AllStmt()))
+	for _, stmt := range nested.AllStmt() {
+		switch {
+		case stmt.OneProd() != nil:
+			prod := *stmt.OneProd()
+			if isMagicRule(prod.OneIdent().String()) {
+				if embedded {
+					magic = append(magic, stmt.Node)
+					continue
+				}
+			} else if prodOp(prod) == defineOp {
+				rules[prod.OneIdent().String()] = true
+			}
+		case stmt.OnePragma() != nil && stmt.OnePragma().OneMacrodef() != nil:
+			rules[stmt.OnePragma().OneMacrodef().OneName().String()] = true
+		case stmt.OnePragma() != nil && stmt.OnePragma().OneExport() != nil:
+			// Exports only concern the grammar importing this one.
+			exports = append(exports, stmt.OnePragma().OneExport().AllIdent()...)
+			continue
+		}
+		stmts = append(stmts, stmt.Node)
+	}
+
+	var errs []error
+	exported := map[string]bool{}
+	for _, ident := range exports {
+		if !rules[ident.String()] {
+			errs = append(errs, validationError{s: ident.Scanner(),
+				msg: "identifier '%s' is exported but is not a defined rule", kind: UnknownRule})
+			continue
+		}
+		exported[ident.String()] = true
+	}
+	importable := func(ident IdentNode) error {
+		switch {
+		case !rules[ident.String()]:
+			return validationError{s: ident.Scanner(),
+				msg: "identifier '%s' is not a rule of the imported grammar", kind: UnknownRule}
+		case len(exported) > 0 && !exported[ident.String()]:
+			return validationError{s: ident.Scanner(),
+				msg: "rule '%s' is not exported by the imported grammar", kind: UnknownRule}
+		}
+		return nil
+	}
+	visible, restricted := exported, len(exported) > 0
+	if len(selected) > 0 {
+		restricted = true
+		visible = map[string]bool{}
+		for _, ident := range selected {
+			if err := importable(ident); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			visible[ident.String()] = true
+		}
+	}
+
+	prefix := ""
+	if p := imp.OnePrefix(); p != nil {
+		prefix = p.String()
+	}
+	local := map[string]string{}
+	for _, rename := range imp.AllRename() {
+		from := rename.AllFrom()[0]
+		if err := importable(from); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		local[from.String()] = rename.AllTo()[0].String()
+	}
+	names := map[string]string{}
+	for rule := range rules {
+		name, renamed := local[rule]
+		switch {
+		case restricted && !visible[rule]:
+			names[rule] = hiddenRule(file, rule)
+			continue
+		case !renamed:
+			name = prefix + rule
+		}
+		if namespace != "" {
+			name = namespace + "." + name
+		}
+		if name != rule {
+			names[rule] = name
+		}
+	}
+	if len(names) == 0 && len(exports) == 0 && len(stmts) == len(nested.AllStmt()) {
+		return nested, errs
+	}
+
+	out := ast.Branch{}
+	for key, children := range nested.Node.(ast.Branch) {
+		out[key] = children
+	}
+	for i, stmt := range magic {
+		magic[i] = renameRules("stmt", stmt, names)
+	}
+	renamed := make(ast.Many, 0, len(stmts))
+	for _, stmt := range stmts {
+		stmt = renameRules("stmt", stmt, names)
+		if prod := (StmtNode{stmt}).OneProd(); prod != nil && len(magic) > 0 {
+			scoped := ast.Branch{}
+			for key, children := range stmt.(ast.Branch) {
+				scoped[key] = children
+			}
+			scoped["prod"] = ast.One{Node: scopeProd(prod.Node, magic)}
+			stmt = scoped
+		}
+		renamed = append(renamed, stmt)
+	}
+	out["stmt"] = renamed
+	return GrammarNode{Node: out}, errs
+}
+
+// scopeProd returns a copy of a prod whose terms are enclosed in a scoped
+// grammar of stmts, as if written `rule -> (terms) { stmts };`.
+func scopeProd(prod ast.Node, stmts ast.Many) ast.Node {
+	b := prod.(ast.Branch)
+	seq := make(ast.Many, 0, len(b.Many("term")))
+	for _, term := range b.Many("term") {
+		atom := ast.Branch{"term": ast.One{Node: term}}
+		seq = append(seq, ast.Branch{"named": ast.One{Node: ast.Branch{"atom": ast.One{Node: atom}}}})
+	}
+	// A term nests a level for each of its operators: scope, |, then sequence.
+	scoped := ast.Branch{
+		"term":    ast.Many{ast.Branch{"term": ast.Many{ast.Branch{"term": seq}}}},
+		"grammar": ast.Many{ast.Branch{"stmt": stmts}},
+	}
+	out := make(ast.Branch, len(b))
+	for key, children := range b {
+		out[key] = children
+	}
+	out["term"] = ast.Many{scoped}
+	return out
+}
+
+// renameRules returns a copy of the tree of a grammar with the rules and macros
+// in names renamed, both where they are defined and where they are referred to.
+// Rules of scoped grammars and macro args shadow those in names.
+func renameRules(kind string, node ast.Node, names map[string]string) ast.Node {
+	b, ok := node.(ast.Branch)
+	if !ok || len(names) == 0 {
+		return node
+	}
+	var locals []string
+	switch kind {
+	case "term":
+		for _, g := range b.Many("grammar") {
+			for _, stmt := range NewGrammarNode(g).AllStmt() {
+				if prod := stmt.OneProd(); prod != nil {
+					locals = append(locals, prod.OneIdent().String())
+				}
+			}
+		}
+	case "macrodef":
+		for _, arg := range b.Many("args") {
+			locals = append(locals, arg.Scanner().String())
+		}
+	}
+	if len(locals) > 0 {
+		shadowed := make(map[string]string, len(names))
+		for from, to := range names {
+			shadowed[from] = to
+		}
+		for _, local := range locals {
+			delete(shadowed, local)
+		}
+		names = shadowed
+	}
+
+	out := make(ast.Branch, len(b))
+	for name, children := range b {
+		rename := func(n ast.Node) ast.Node { return renameRules(name, n, names) }
+		switch {
+		case name == "IDENT" && (kind == "prod" || kind == "atom" || kind == "tokref" || kind == "export"),
+			name == "name" && (kind == "macrodef" || kind == "macrocall"):
+			rename = func(n ast.Node) ast.Node { return renameIdent(n, names) }
+		}
+		switch children := children.(type) {
+		case ast.One:
+			out[name] = ast.One{Node: rename(children.Node)}
+		case ast.Many:
+			many := make(ast.Many, 0, len(children))
+			for _, child := range children {
+				many = append(many, rename(child))
+			}
+			out[name] = many
+		}
+	}
+	return out
+}
+
+func renameIdent(ident ast.Node, names map[string]string) ast.Node {
+	s := ident.Scanner()
+	if to, has := names[s.String()]; has {
+		return ast.Branch{"": ast.One{Node: ast.Leaf(*s.Replace(to))}}
+	}
+	return ident
+}
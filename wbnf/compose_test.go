@@ -0,0 +1,210 @@
+package wbnf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arr-ai/wbnf/parser"
+)
+
+// writeGrammars writes grammar files to a new directory and returns a resolver
+// for imports from it.
+func writeGrammars(t *testing.T, files map[string]string) dirResolver {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(text), 0o600))
+	}
+	return dirResolver(dir)
+}
+
+const exprLib = `
+expr -> @:op="+" > term;
+term -> INT;
+INT  -> \d+;
+.wrapRE -> /{\s*()\s*};
+`
+
+func TestImportOverrideAndExtend(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{"expr.wbnf": exprLib})
+
+	p, err := Compile(`.import expr.wbnf
+		term |= "(" expr ")";
+		INT = /{\d+(?:_\d+)*};
+	`, resolver)
+	require.NoError(t, err)
+	g := p.Grammar()
+	assert.Equal(t,
+		parser.Oneof{parser.Rule("INT"),
+			parser.Seq{parser.CutPoint{Term: parser.S("(")}, parser.Rule("expr"), parser.S(")")}},
+		g["term"])
+	assert.Equal(t, parser.RE(`\d+(?:_\d+)*`), g["INT"])
+
+	_, err = p.Parse("expr", parser.NewScanner("(1 + 2) + 3_000"))
+	assert.NoError(t, err)
+
+	// Extending a precedence stack extends its last level.
+	p, err = Compile(`.import expr.wbnf
+		expr |= "-" expr;
+	`, resolver)
+	require.NoError(t, err)
+	_, err = p.Parse("expr", parser.NewScanner("1 + -2"))
+	assert.NoError(t, err)
+}
+
+func TestImportPrefixRenameAndExport(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{
+		"expr.wbnf": exprLib,
+		"list.wbnf": `.export list;
+			list -> "[" NUM:"," "]";
+			NUM  -> \d+;`,
+	})
+
+	p, err := Compile(`.import expr.wbnf prefix x_
+		.import list.wbnf rename(list -> nums)
+		doc -> x_expr ";" nums NUM;
+		NUM -> [a-z]+;
+		.wrapRE = /{\s*()};
+	`, resolver)
+	require.NoError(t, err)
+	g := p.Grammar()
+	for _, rule := range []parser.Rule{"doc", "NUM", "x_expr", "x_term", "x_INT", "nums", "list#NUM"} {
+		assert.Contains(t, g, rule)
+	}
+	assert.NotContains(t, g, parser.Rule("expr"))
+	assert.NotContains(t, g, parser.Rule("list"))
+	_, err = p.Parse("doc", parser.NewScanner("1 + 2; [1, 2] abc"))
+	assert.NoError(t, err)
+
+	// Hidden rules can't be referred to.
+	_, err = Compile(`.import list.wbnf
+		doc -> list NUM;
+	`, resolver)
+	assert.EqualError(t, err, "2:15: identifier 'NUM' is not a defined rule")
+
+	// Problems applying an import are reported along with the rest.
+	_, err = Compile(`.import list.wbnf rename(NUM -> n, nope -> m)
+		doc -> nums;
+	`, resolver)
+	assert.EqualError(t, err, "1:26: rule 'NUM' is not exported by the imported grammar\n"+
+		"1:36: identifier 'nope' is not a rule of the imported grammar\n"+
+		"2:10: identifier 'nums' is not a defined rule")
+}
+
+func TestImportHiddenRulesOfSameNamedFiles(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{
+		"a/lib.wbnf": `.export a; a -> NUM; NUM -> \d+;`,
+		"b/lib.wbnf": `.export b; b -> NUM; NUM -> [a-z]+;`,
+	})
+
+	p, err := Compile(`.import a/lib.wbnf
+		.import b/lib.wbnf
+		doc -> a b;
+		.wrapRE -> /{\s*()\s*};
+	`, resolver)
+	require.NoError(t, err)
+	assert.Contains(t, p.Grammar(), parser.Rule("lib#NUM"))
+	assert.Contains(t, p.Grammar(), parser.Rule("lib2#NUM"))
+	_, err = p.Parse("doc", parser.NewScanner("1 x"))
+	assert.NoError(t, err)
+}
+
+func TestImportConflicts(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{"expr.wbnf": exprLib})
+
+	_, err := Compile(`.import expr.wbnf
+		term -> "x";
+	`, resolver)
+	require.Error(t, err)
+	assert.Equal(t, DuplicatedRule, err.(*validator).err[0].(validationError).Kind())
+	assert.EqualError(t, err,
+		"2:3: rule 'term' is defined multiple times, first at "+filepath.Join(string(resolver), "expr.wbnf")+":3:1")
+
+	_, err = Compile(`.import expr.wbnf
+		factor |= "x";
+	`, resolver)
+	assert.Error(t, err)
+}
+
+func TestImportNamespaces(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{
+		"json.wbnf": `value -> STR | NUM | "[" value:"," "]";
+			STR -> /{"[^"]*"};
+			NUM -> \d+;
+			.wrapRE -> /{\s*()\s*};`,
+		"csv.wbnf": `row -> NUM:",";
+			NUM -> \d+;
+			.wrapRE -> /{\t*()};`,
+	})
+
+	p, err := Compile(`.import json.wbnf as json;
+		.import csv.wbnf as csv;
+		doc -> json.value ";" csv.row;
+		json.value |= "null";
+		.wrapRE -> /{\s*()\s*};
+	`, resolver)
+	require.NoError(t, err)
+	g := p.Grammar()
+	for _, rule := range []parser.Rule{"doc", "json.value", "json.STR", "json.NUM", "csv.row", "csv.NUM"} {
+		assert.Contains(t, g, rule)
+	}
+	// Each import's rules keep its own .wrapRE, and the extension the
+	// importer's.
+	_, err = p.Parse("doc", parser.NewScanner("[1, \"a\", null]; 1,\t2"))
+	assert.NoError(t, err)
+	_, err = p.Parse("doc", parser.NewScanner(`[1, "a", null]; 1, 2`))
+	assert.Error(t, err)
+
+	// So they parse as they would in a plain import.
+	for imp, value := range map[string]string{
+		".import json.wbnf;":         "value",
+		".import json.wbnf as json;": "json.value",
+		".import json.wbnf (value);": "value",
+	} {
+		p, err := Compile(imp+" doc -> "+value+` ";";`, resolver)
+		require.NoError(t, err, imp)
+		_, err = p.Parse("doc", parser.NewScanner(`[1, "a" ];`))
+		assert.NoError(t, err, imp)
+	}
+
+	_, err = Compile(`.import json.wbnf as json;
+		doc -> jsn.value;
+	`, resolver)
+	assert.EqualError(t, err, "2:10: identifier 'jsn.value' refers to namespace jsn, which no import defines")
+}
+
+func TestImportSelective(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{"expr.wbnf": exprLib})
+
+	p, err := Compile(`.import expr.wbnf (expr)
+		doc -> expr ";" INT;
+		INT -> [a-z]+;
+		.wrapRE -> /{\s*()\s*};
+	`, resolver)
+	require.NoError(t, err)
+	assert.Contains(t, p.Grammar(), parser.Rule("expr#INT"))
+	_, err = p.Parse("doc", parser.NewScanner("1 + 2; x"))
+	assert.NoError(t, err)
+
+	p, err = Compile(`.import expr.wbnf as e (expr) rename(expr -> sum)
+		.wrapRE -> /{\s*()\s*};
+	`, resolver)
+	require.NoError(t, err)
+	assert.Contains(t, p.Grammar(), parser.Rule("e.sum"))
+	assert.Contains(t, p.Grammar(), parser.Rule("expr#term"))
+
+	_, err = Compile(`.import expr.wbnf (factor)`, resolver)
+	assert.Error(t, err)
+}
@@ -2,8 +2,13 @@ package wbnf
 
 import (
 	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/arr-ai/frozen"
 	"github.com/arr-ai/wbnf/parser"
 )
 
@@ -26,11 +31,32 @@ What is a cutpoint?
 
 Where can cutpoints be added?
 	At any point where it is guaranteed that no other branch could possibly successfully parse the previously
-	consumed text. This will have to be heuristically determined.
+	consumed text.
 
 What does this code attempt to add?
-	1) Any S() token which appears only once in the entire grammar can be safely considered a cutpoint
-	2) .... TBD
+	A cutpoint after the first item of a sequence that consumes input, provided that
+	1) the tokens the sequence can start with (its FIRST set) can't start the same text as any of its rivals: the
+	   tokens that any other parse could match where the sequence starts. These are the FIRST sets of the
+	   alternatives after it in a choice, and what follows a repetition, delimited list or optional term that
+	   could stop or be skipped instead, including those of every choice and repetition enclosing it up to the
+	   start of a rule, and of every place that rule is referred to.
+	2) no choice enclosing it could still try another alternative, because one that started earlier wasn't
+	   decided by its own first token in the same way.
+
+	Tokens are compared by the text they can start with: strings by their text, and regexps by the characters
+	they can start with, unless they match a single string. Tokens that can match nothing, back-references and
+	external references can start anything. Since PEG parsers commit to the first alternative that matches and
+	never backtrack into a completed term, this is enough to know that no other parse can succeed once the
+	sequence has consumed its first item.
+
+	Two refinements keep common grammars from being ruled out:
+	a) alternatives that begin with the same items as the sequence only rival it from where they differ, so
+	   in `"(" x ")" | "(" ")"` the first alternative is cut after x;
+	b) a string or single character rival that the sequence's first string starts with, such as "%" for "%!",
+	   only clashes if what can follow it might match the rest of that string.
+
+	Rules of scoped grammars are analysed in their scope, and precedence stacks level by level. Cutpoints
+	already in the grammar are replaced. DescribeCutPoints reports where cutpoints were placed and why.
 
 The intention of this file is to provide good-enough cutpoints that it is not necessary for grammar authors
 to add their own
@@ -38,85 +64,927 @@ to add their own
 */
 
 func insertCutPoints(g parser.Grammar) parser.Grammar {
-	strings := findUniqueStrings(g)
-	s := strings.Elements()
-	_ = s
-	var callback func(t parser.Term) parser.Term
-	callback = func(t parser.Term) parser.Term {
-		switch t := t.(type) {
-		case parser.S:
-			if strings.Has(string(t)) {
-				return parser.CutPoint{Term: t}
+	g, _ = placeCutPoints(g)
+	return g
+}
+
+// DescribeCutPoints returns a line for each cutpoint that compiling g places,
+// saying where it is and why.
+func DescribeCutPoints(g parser.Grammar) []string {
+	_, placements := placeCutPoints(g)
+	return placements
+}
+
+func placeCutPoints(g parser.Grammar) (parser.Grammar, []string) {
+	a := newCutAnalysis()
+	top := a.addGrammar(g, nil, "")
+	a.solve()
+	out := a.rebuildGrammar(top)
+	sort.Strings(a.placements)
+	return out, a.placements
+}
+
+func newCutAnalysis() *cutAnalysis {
+	return &cutAnalysis{infos: map[string]*tokenInfo{}, tokenFollows: map[string]*termSet{}}
+}
+
+type cutAnalysis struct {
+	rules        []*cutRule
+	infos        map[string]*tokenInfo
+	tokenFollows map[string]*termSet
+	owner        string // the rule whose term is being added
+	placements   []string
+}
+
+// cutGrammar is a grammar or scoped grammar being analysed.
+type cutGrammar struct {
+	scope  *cutScope
+	levels map[parser.Rule][]*cutRule // one per level of a stack, otherwise just the one
+	stacks map[parser.Rule]bool
+}
+
+type cutScope struct {
+	parent *cutScope
+	rules  map[parser.Rule]*cutRule
+	wrap   parser.Term // the .wrapRE in effect
+}
+
+func (s *cutScope) lookup(rule parser.Rule) *cutRule {
+	for ; s != nil; s = s.parent {
+		if r, has := s.rules[rule]; has {
+			return r
+		}
+	}
+	return nil
+}
+
+type cutRule struct {
+	name     string
+	body     *cutNode
+	first    termSet
+	nullable bool
+	ctx      cutContext // the union of the contexts it's referred to in
+}
+
+// cutContext describes where a term is parsed.
+type cutContext struct {
+	rivals termSet // tokens that another parse might match where the term starts
+	follow termSet // tokens that might follow the term
+	open   bool    // whether an enclosing choice could still try another alternative
+	shared []sharedRivals
+}
+
+// sharedRivals are the tokens that later alternatives of a choice, which begin
+// with the same items as a sequence, could match after those items.
+type sharedRivals struct {
+	after  int
+	rivals termSet
+}
+
+// merge merges d into c, except for shared rivals, which only apply to the
+// sequence that is an alternative.
+func (c *cutContext) merge(d cutContext) bool {
+	changed := c.rivals.add(d.rivals)
+	changed = c.follow.add(d.follow) || changed
+	if d.open && !c.open {
+		c.open = true
+		changed = true
+	}
+	return changed
+}
+
+type cutNode struct {
+	term     parser.Term // the term, without cutpoints
+	kids     []*cutNode
+	rule     *cutRule    // what a Rule or Token refers to, if defined
+	grammar  *cutGrammar // the grammar of a ScopedGrammar
+	token    cutToken    // an S, SI or RE
+	owner    string
+	first    termSet
+	nullable bool
+	ctx      cutContext
+}
+
+// addGrammar prepares g for analysis in the scope of parent.
+func (a *cutAnalysis) addGrammar(g parser.Grammar, parent *cutScope, owner string) *cutGrammar {
+	scope := &cutScope{parent: parent, rules: map[parser.Rule]*cutRule{}}
+	if parent != nil {
+		scope.wrap = parent.wrap
+	}
+	if wrap, has := g[parser.WrapRE]; has {
+		scope.wrap = wrap
+	}
+	cg := &cutGrammar{scope: scope, levels: map[parser.Rule][]*cutRule{}, stacks: map[parser.Rule]bool{}}
+	name := func(rule string) string {
+		if owner != "" {
+			return fmt.Sprintf("%s{%s}", owner, rule)
+		}
+		return rule
+	}
+	for rule, term := range g {
+		n := 1
+		if stack, ok := term.(parser.Stack); ok {
+			n = len(stack)
+			cg.stacks[rule] = true
+		}
+		levels := make([]*cutRule, 0, n)
+		for i := 0; i < n; i++ {
+			level := string(rule)
+			if i > 0 {
+				level = fmt.Sprintf("%s%s%d", rule, parser.StackDelim, i)
 			}
-		case parser.ScopedGrammar:
-			t.Grammar = rebuildGrammar(t.Grammar, callback)
-			return t
+			r := &cutRule{name: name(level)}
+			levels = append(levels, r)
+			scope.rules[parser.Rule(level)] = r
+			a.rules = append(a.rules, r)
+		}
+		cg.levels[rule] = levels
+	}
+	outer := a.owner
+	defer func() { a.owner = outer }()
+	for rule, levels := range cg.levels {
+		if cg.stacks[rule] {
+			for i, layer := range g[rule].(parser.Stack) {
+				a.owner = levels[i].name
+				levels[i].body = a.build(layer, scope, levels[(i+1)%len(levels)])
+			}
+		} else {
+			a.owner = levels[0].name
+			levels[0].body = a.build(g[rule], scope, nil)
+		}
+	}
+	return cg
+}
+
+// build prepares a term for analysis. at is the level of a stack that @ refers
+// to, if any.
+func (a *cutAnalysis) build(t parser.Term, scope *cutScope, at *cutRule) *cutNode {
+	n := &cutNode{term: t, owner: a.owner}
+	kids := func(terms ...parser.Term) {
+		for _, term := range terms {
+			n.kids = append(n.kids, a.build(term, scope, at))
+		}
+	}
+	switch t := t.(type) {
+	case parser.CutPoint:
+		return a.build(t.Term, scope, at)
+	case parser.S, parser.SI, parser.RE:
+		n.token = cutToken{term: t, wrap: scope.wrap}
+	case parser.Rule:
+		if t == parser.At && at != nil {
+			n.rule = at
+		} else {
+			n.rule = scope.lookup(t)
+		}
+	case parser.Token:
+		n.rule = scope.lookup(t.Rule)
+	case parser.Seq:
+		kids(t...)
+	case parser.Oneof:
+		kids(t...)
+	case parser.Delim:
+		kids(t.Term, t.Sep)
+	case parser.Quant:
+		kids(t.Term)
+	case parser.Named:
+		kids(t.Term)
+	case parser.LookAhead:
+		kids(t.Term)
+	case parser.NotLookAhead:
+		kids(t.Term)
+	case parser.LookBehind:
+		kids(t.Term)
+	case parser.NotLookBehind:
+		kids(t.Term)
+	case parser.ScopedGrammar:
+		n.grammar = a.addGrammar(t.Grammar, scope, a.owner)
+		n.kids = []*cutNode{a.build(t.Term, n.grammar.scope, at)}
+	}
+	return n
+}
+
+// solve computes the FIRST set of every rule and then the contexts in which
+// every term is parsed.
+func (a *cutAnalysis) solve() {
+	for changed := true; changed; {
+		changed = false
+		for _, r := range a.rules {
+			a.computeFirst(r.body)
+			changed = r.first.add(r.body.first) || changed
+			if r.body.nullable && !r.nullable {
+				r.nullable = true
+				changed = true
+			}
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range a.rules {
+			changed = a.propagate(r.body, r.ctx) || changed
 		}
-		return t
 	}
-	return rebuildGrammar(g, callback)
 }
 
-func findUniqueStrings(g parser.Grammar) frozen.Set[string] {
-	mergeFn := func(_ string, a, b int) int {
-		return a + b
+func (a *cutAnalysis) computeFirst(n *cutNode) {
+	for _, kid := range n.kids {
+		a.computeFirst(kid)
 	}
-	var forTerm func(t parser.Term) frozen.Map[string, int]
-	forTerm = func(t parser.Term) frozen.Map[string, int] {
-		out := frozen.NewMap[string, int]()
-		if t == nil {
-			return out
+	n.first, n.nullable = termSet{}, false
+	switch t := n.term.(type) {
+	case parser.S, parser.SI, parser.RE:
+		if info := a.info(n.token); !info.empty {
+			n.first.addToken(n.token)
 		}
-		switch t := t.(type) {
-		case parser.S:
-			return out.With(string(t), 1)
+		n.nullable = a.info(n.token).nullable
+	case parser.Rule, parser.Token:
+		if n.rule == nil {
+			n.first.any, n.nullable = true, true
+		} else {
+			n.first.add(n.rule.first)
+			n.nullable = n.rule.nullable
+		}
+	case parser.Seq:
+		n.first, n.nullable = seqFirst(n.kids)
+	case parser.Oneof:
+		for _, kid := range n.kids {
+			n.first.add(kid.first)
+			n.nullable = n.nullable || kid.nullable
+		}
+	case parser.Delim:
+		n.first.add(n.kids[0].first)
+		if t.CanStartWithSep {
+			n.first.add(n.kids[1].first)
+		}
+		n.nullable = n.kids[0].nullable
+	case parser.Quant:
+		n.first.add(n.kids[0].first)
+		n.nullable = t.Min == 0 || n.kids[0].nullable
+	case parser.Named, parser.ScopedGrammar:
+		n.first.add(n.kids[0].first)
+		n.nullable = n.kids[0].nullable
+	case parser.LookAhead, parser.NotLookAhead, parser.LookBehind, parser.NotLookBehind, parser.Position:
+		n.nullable = true
+	default:
+		// Back-references, external references and anything else whose
+		// matches aren't known.
+		n.first.any, n.nullable = true, true
+	}
+}
+
+func seqFirst(kids []*cutNode) (termSet, bool) {
+	var first termSet
+	for _, kid := range kids {
+		first.add(kid.first)
+		if !kid.nullable {
+			return first, false
+		}
+	}
+	return first, true
+}
+
+// rivalGroups returns the rivals of a sequence, those of alternatives that
+// begin the same way being apart from the others.
+func (a *cutAnalysis) rivalGroups(n *cutNode) []sharedRivals {
+	return append([]sharedRivals{{rivals: n.ctx.rivals}}, n.ctx.shared...)
+}
+
+// ruledOut reports whether a sequence rules out the rivals of g by what it
+// must consume after the items they share.
+func (a *cutAnalysis) ruledOut(n *cutNode, g sharedRivals) bool {
+	first, nullable := seqFirst(n.kids[g.after:])
+	return !nullable && !a.clash(first, g.rivals)
+}
+
+func mayConsume(kids []*cutNode) bool {
+	for _, kid := range kids {
+		if !kid.first.empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// seqItems returns the items of an alternative that is a sequence, or else the
+// alternative itself.
+func seqItems(n *cutNode) []*cutNode {
+	for {
+		switch n.term.(type) {
+		case parser.Named:
+			n = n.kids[0]
+			continue
 		case parser.Seq:
-			for _, t := range t {
-				out = out.Merge(forTerm(t), mergeFn)
+			return n.kids
+		}
+		return []*cutNode{n}
+	}
+}
+
+func sharedPrefix(a, b []*cutNode) int {
+	i := 0
+	for i < len(a) && i < len(b) && termsEqual(a[i].term, b[i].term) {
+		i++
+	}
+	return i
+}
+
+// follows returns the tokens that might follow a token wherever it appears.
+func (a *cutAnalysis) follows(t cutToken) *termSet {
+	key := t.key()
+	f, has := a.tokenFollows[key]
+	if !has {
+		f = &termSet{}
+		a.tokenFollows[key] = f
+	}
+	return f
+}
+
+// propagate records the context of n and its descendants, and merges those of
+// the rules they refer to, reporting whether any of those changed.
+func (a *cutAnalysis) propagate(n *cutNode, ctx cutContext) bool {
+	n.ctx = ctx
+	changed := false
+	switch t := n.term.(type) {
+	case parser.Rule:
+		if n.rule != nil {
+			changed = n.rule.ctx.merge(ctx)
+		}
+	case parser.S, parser.SI, parser.RE:
+		changed = a.follows(n.token).add(ctx.follow)
+	case parser.Seq:
+		// Once an item has consumed input, the rivals of the sequence are
+		// out of the running, unless what it consumed doesn't rule them out.
+		groups := a.rivalGroups(n)
+		for i, kid := range n.kids {
+			follow, nullable := seqFirst(n.kids[i+1:])
+			kidCtx := cutContext{follow: follow, open: ctx.open}
+			if nullable {
+				kidCtx.follow.add(ctx.follow)
 			}
-		case parser.Stack:
-			for _, t := range t {
-				out = out.Merge(forTerm(t), mergeFn)
+			for _, g := range groups {
+				switch {
+				case g.after > i:
+					// The rivals parse this item the same way.
+				case !mayConsume(n.kids[g.after:i]):
+					kidCtx.rivals.add(g.rivals)
+				case !a.ruledOut(n, g):
+					kidCtx.open = true
+				}
 			}
-		case parser.Oneof:
-			for _, t := range t {
-				out = out.Merge(forTerm(t), mergeFn)
+			changed = a.propagate(kid, kidCtx) || changed
+		}
+	case parser.Oneof:
+		// A PEG parser only tries the alternatives after one that fails, and
+		// those that begin with the same items fail or succeed with them.
+		for i, kid := range n.kids {
+			kidCtx := cutContext{follow: ctx.follow, open: ctx.open}
+			kidCtx.rivals.add(ctx.rivals)
+			items := seqItems(kid)
+			for _, later := range n.kids[i+1:] {
+				rest := seqItems(later)
+				k := sharedPrefix(items, rest)
+				if k == 0 {
+					kidCtx.rivals.add(later.first)
+					if later.nullable {
+						kidCtx.rivals.add(ctx.follow)
+					}
+					continue
+				}
+				first, nullable := seqFirst(rest[k:])
+				if nullable {
+					first.add(ctx.follow)
+				}
+				kidCtx.shared = append(kidCtx.shared, sharedRivals{after: k, rivals: first})
 			}
-		case parser.Delim:
-			out = out.Merge(forTerm(t.Term), mergeFn)
-			out = out.Merge(forTerm(t.Sep), mergeFn)
-			if t.CanStartWithSep {
-				// ensure that a starting sep will never be a cutpoint
-				out = out.Merge(forTerm(t.Sep), mergeFn)
+			changed = a.propagate(kid, kidCtx) || changed
+		}
+	case parser.Delim:
+		term, sep := n.kids[0], n.kids[1]
+		// A failed term after a sep ends the list before the sep.
+		termCtx := cutContext{open: ctx.open || a.clash(sep.first, ctx.follow)}
+		termCtx.rivals.add(ctx.rivals)
+		if t.CanStartWithSep {
+			termCtx.rivals.add(sep.first)
+		}
+		if sep.nullable || t.CanEndWithSep {
+			termCtx.rivals.add(ctx.follow)
+		}
+		termCtx.follow.add(sep.first)
+		termCtx.follow.add(ctx.follow)
+		if sep.nullable {
+			termCtx.follow.add(term.first)
+		}
+		sepCtx := cutContext{open: ctx.open}
+		sepCtx.rivals.add(ctx.follow)
+		if t.CanStartWithSep {
+			sepCtx.rivals.add(ctx.rivals)
+		}
+		sepCtx.follow.add(term.first)
+		if t.CanEndWithSep {
+			sepCtx.follow.add(ctx.follow)
+		}
+		changed = a.propagate(term, termCtx) || changed
+		changed = a.propagate(sep, sepCtx) || changed
+	case parser.Quant:
+		// A failed iteration ends the repetition, unless too few preceded it.
+		body := n.kids[0]
+		bodyCtx := cutContext{open: ctx.open || t.Min > 1 && a.clash(n.first, ctx.rivals)}
+		bodyCtx.rivals.add(ctx.rivals)
+		if t.Min == 0 || t.Max != 1 {
+			bodyCtx.rivals.add(ctx.follow)
+		}
+		bodyCtx.follow.add(ctx.follow)
+		if t.Max != 1 {
+			bodyCtx.follow.add(body.first)
+		}
+		changed = a.propagate(body, bodyCtx) || changed
+	case parser.Named, parser.ScopedGrammar:
+		changed = a.propagate(n.kids[0], ctx) || changed
+	case parser.LookAhead, parser.NotLookAhead, parser.LookBehind, parser.NotLookBehind:
+		// A cut within a lookaround would outlive it.
+		changed = a.propagate(n.kids[0], cutContext{open: true}) || changed
+	}
+	return changed
+}
+
+func (a *cutAnalysis) rebuildGrammar(cg *cutGrammar) parser.Grammar {
+	g := parser.Grammar{}
+	for rule, levels := range cg.levels {
+		if cg.stacks[rule] {
+			stack := make(parser.Stack, 0, len(levels))
+			for _, level := range levels {
+				stack = append(stack, a.rebuild(level.body))
 			}
-		case parser.Quant:
-			out = out.Merge(forTerm(t.Term), mergeFn)
-		case parser.Named:
-			out = out.Merge(forTerm(t.Term), mergeFn)
-		case parser.ScopedGrammar:
-			out = out.Merge(forTerm(t.Term), mergeFn)
-			incoming := frozen.NewMapFromKeys(
-				findUniqueStrings(t.Grammar),
-				func(string) int { return 1 })
-			out = out.Merge(incoming, mergeFn)
-		case parser.REF:
-			out = out.Merge(forTerm(t.Default), mergeFn)
-		case parser.CutPoint:
-			out = out.Merge(forTerm(t.Term), mergeFn)
-		case parser.RE, parser.Rule, parser.ExtRef, parser.LookAhead: // do nothing
-		default:
-			panic(fmt.Errorf("findUniqueStrings: unexpected term type: %v %[1]T", t))
+			g[rule] = stack
+		} else {
+			g[rule] = a.rebuild(levels[0].body)
 		}
-		return out
 	}
+	return g
+}
+
+func (a *cutAnalysis) rebuild(n *cutNode) parser.Term {
+	kids := make([]parser.Term, 0, len(n.kids))
+	for _, kid := range n.kids {
+		kids = append(kids, a.rebuild(kid))
+	}
+	switch t := n.term.(type) {
+	case parser.Seq:
+		seq := append(parser.Seq{}, kids...)
+		if i := a.cutAt(n); i >= 0 {
+			seq[i] = parser.CutPoint{Term: seq[i]}
+			a.placements = append(a.placements, fmt.Sprintf("%s: %v: %s", n.owner, seq, a.cutReason(n)))
+		}
+		return seq
+	case parser.Oneof:
+		return append(parser.Oneof{}, kids...)
+	case parser.Delim:
+		t.Term, t.Sep = kids[0], kids[1]
+		return t
+	case parser.Quant:
+		t.Term = kids[0]
+		return t
+	case parser.Named:
+		t.Term = kids[0]
+		return t
+	case parser.LookAhead:
+		t.Term = kids[0]
+		return t
+	case parser.NotLookAhead:
+		t.Term = kids[0]
+		return t
+	case parser.LookBehind:
+		t.Term = kids[0]
+		return t
+	case parser.NotLookBehind:
+		t.Term = kids[0]
+		return t
+	case parser.ScopedGrammar:
+		t.Term = kids[0]
+		t.Grammar = a.rebuildGrammar(n.grammar)
+		return t
+	}
+	return n.term
+}
+
+// cutAt returns the index of the item of a sequence after which to cut, or -1.
+func (a *cutAnalysis) cutAt(n *cutNode) int {
+	if n.ctx.open {
+		return -1
+	}
+	at := -1
+	for _, g := range a.rivalGroups(n) {
+		if g.after >= len(n.kids) || !a.ruledOut(n, g) {
+			return -1
+		}
+		i := g.after
+		for n.kids[i].nullable {
+			i++
+		}
+		if i > at {
+			at = i
+		}
+	}
+	if at == len(n.kids)-1 {
+		// Nothing is left to commit to.
+		return -1
+	}
+	return at
+}
+
+func (a *cutAnalysis) cutReason(n *cutNode) string {
+	var reasons []string
+	for _, g := range a.rivalGroups(n) {
+		if g.rivals.empty() {
+			continue
+		}
+		first, _ := seqFirst(n.kids[g.after:])
+		if g.after == 0 {
+			reasons = append(reasons, fmt.Sprintf(
+				"its first tokens %v can't start the same text as its rivals %v", first, g.rivals))
+		} else {
+			reasons = append(reasons, fmt.Sprintf(
+				"after its first %d item(s), its tokens %v can't start the same text as %v, "+
+					"which alternatives that begin the same way continue with", g.after, first, g.rivals))
+		}
+	}
+	if len(reasons) == 0 {
+		return "nothing else can be parsed where it starts"
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// cutToken is an S, SI or RE term, with the .wrapRE it's matched with.
+type cutToken struct {
+	term parser.Term
+	wrap parser.Term
+}
+
+func (t cutToken) key() string {
+	wrap := ""
+	if t.wrap != nil {
+		wrap = t.wrap.String()
+	}
+	return wrap + "\x00" + t.term.String()
+}
+
+// termSet is a set of tokens, or of anything if any is set.
+type termSet struct {
+	tokens map[string]cutToken
+	any    bool
+}
+
+func (s termSet) empty() bool { return !s.any && len(s.tokens) == 0 }
+
+func (s *termSet) addToken(t cutToken) bool {
+	if s.tokens == nil {
+		s.tokens = map[string]cutToken{}
+	}
+	key := t.key()
+	if _, has := s.tokens[key]; has {
+		return false
+	}
+	s.tokens[key] = t
+	return true
+}
+
+func (s *termSet) add(t termSet) bool {
+	changed := false
+	if t.any && !s.any {
+		s.any = true
+		changed = true
+	}
+	for _, token := range t.tokens {
+		changed = s.addToken(token) || changed
+	}
+	return changed
+}
+
+func (s termSet) String() string {
+	if s.any {
+		return "{anything}"
+	}
+	tokens := make([]string, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t.term.String())
+	}
+	sort.Strings(tokens)
+	return "{" + strings.Join(tokens, ", ") + "}"
+}
+
+// clash reports whether a token in s might start the same text as one in t,
+// which are its rivals.
+func (a *cutAnalysis) clash(s, t termSet) bool {
+	if s.empty() || t.empty() {
+		return false
+	}
+	if s.any || t.any {
+		return true
+	}
+	for _, x := range s.tokens {
+		for _, y := range t.tokens {
+			if a.tokensClash(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tokensClash reports whether y might start the same text as x. A literal or
+// single rune y that x's text merely starts with only does if what follows y
+// might match the rest of that text.
+func (a *cutAnalysis) tokensClash(x, y cutToken) bool {
+	if !a.tokensOverlap(x, y) {
+		return false
+	}
+	ix, iy := a.info(x), a.info(y)
+	if x.key() == y.key() || !ix.literal || ix.nullable || iy.nullable {
+		return true
+	}
+	s, fold := ix.text, ix.fold
+	switch {
+	case iy.literal:
+		t := iy.text
+		if fold = fold || iy.fold; fold {
+			s, t = foldString(s), foldString(t)
+		}
+		if len(t) >= len(s) {
+			return true
+		}
+		s = s[len(t):]
+	case iy.single:
+		_, n := utf8.DecodeRuneInString(s)
+		if n == len(s) {
+			return true
+		}
+		s = s[n:]
+	default:
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	if iy.after.intersects(foldRune(r, fold)) {
+		return true
+	}
+	follows := a.follows(y)
+	if follows.any {
+		return true
+	}
+	var rest parser.Term = parser.S(s)
+	if fold {
+		rest = parser.SI(s)
+	}
+	for _, z := range follows.tokens {
+		if a.tokensOverlap(cutToken{term: rest, wrap: y.wrap}, z) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokensOverlap reports whether x and y might start the same text.
+func (a *cutAnalysis) tokensOverlap(x, y cutToken) bool {
+	if x.key() == y.key() {
+		return true
+	}
+	if !termsEqual(x.wrap, y.wrap) {
+		return true
+	}
+	ix, iy := a.info(x), a.info(y)
+	switch {
+	case ix.unknown || iy.unknown, ix.nullable || iy.nullable:
+		return true
+	case ix.wrap.intersects(ix.first) || iy.wrap.intersects(iy.first):
+		// Either might start within what the other's .wrapRE skips.
+		return true
+	case ix.literal && iy.literal:
+		s, t := ix.text, iy.text
+		if ix.fold || iy.fold {
+			s, t = foldString(s), foldString(t)
+		}
+		return strings.HasPrefix(s, t) || strings.HasPrefix(t, s)
+	}
+	return ix.first.intersects(iy.first)
+}
+
+func termsEqual(a, b parser.Term) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
+
+// tokenInfo describes the text a token can match.
+type tokenInfo struct {
+	first    runeSet // the runes that a match can start with
+	wrap     runeSet // the runes that its .wrapRE can skip before it
+	after    runeSet // the runes that its .wrapRE can skip after it
+	nullable bool    // whether it can match ""
+	empty    bool    // whether it only matches ""
+	literal  bool    // whether it only matches text
+	single   bool    // whether it always matches exactly one rune
+	text     string
+	fold     bool // whether text is matched ignoring case
+	unknown  bool // whether it couldn't be analysed
+}
+
+func (a *cutAnalysis) info(t cutToken) *tokenInfo {
+	key := t.key()
+	if info, has := a.infos[key]; has {
+		return info
+	}
+	info := &tokenInfo{}
+	a.infos[key] = info
+	raw := ""
+	switch term := t.term.(type) {
+	case parser.S:
+		raw = string(term)
+		info.literal, info.text = true, raw
+	case parser.SI:
+		raw = string(term)
+		info.literal, info.text, info.fold = true, raw, true
+	case parser.RE:
+		raw = string(term)
+		re, err := regexp.Compile(raw)
+		if err != nil {
+			info.unknown = true
+			return info
+		}
+		info.text, info.literal = re.LiteralPrefix()
+	}
+	if info.literal {
+		r, _ := utf8.DecodeRuneInString(info.text)
+		info.first, info.nullable, info.empty = foldRune(r, info.fold), info.text == "", info.text == ""
+	} else {
+		re, err := syntax.Parse(raw, syntax.Perl)
+		if err != nil {
+			info.unknown = true
+			return info
+		}
+		re = re.Simplify()
+		info.first, info.nullable = reFirst(re)
+		info.empty = info.nullable && len(info.first) == 0
+		for re.Op == syntax.OpCapture {
+			re = re.Sub[0]
+		}
+		switch re.Op {
+		case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			info.single = true
+		}
+	}
+
+	prefix, suffix, ok := wrapParts(t.wrap, raw)
+	if !ok {
+		info.unknown = true
+		return info
+	}
+	for _, part := range []struct {
+		re    string
+		runes *runeSet
+	}{{prefix, &info.wrap}, {suffix, &info.after}} {
+		if part.re == "" {
+			continue
+		}
+		re, err := syntax.Parse(part.re, syntax.Perl)
+		if err != nil {
+			info.unknown = true
+			return info
+		}
+		*part.runes = reRunes(re)
+	}
+	return info
+}
 
-	strings := frozen.NewMap[string, int]()
-	for _, t := range g {
-		strings = strings.Merge(forTerm(t), mergeFn)
+// wrapParts returns the parts of a .wrapRE before and after the token it
+// wraps, which are "" if the token is excluded from wrapping.
+func wrapParts(wrap parser.Term, raw string) (prefix, suffix string, ok bool) {
+	if wrap == nil {
+		return "", "", true
 	}
-	return strings.Where(func(key string, val int) bool {
-		return val == 1
-	}).Keys()
+	if oneof, ok := wrap.(parser.Oneof); ok && len(oneof) > 0 {
+		for _, t := range oneof[:len(oneof)-1] {
+			switch t := t.(type) {
+			case parser.S:
+				if string(t) == raw {
+					return "", "", true
+				}
+			case parser.RE:
+				if string(t) == raw {
+					return "", "", true
+				}
+			}
+		}
+		wrap = oneof[len(oneof)-1]
+	}
+	re, ok := wrap.(parser.RE)
+	if !ok {
+		return "", "", false
+	}
+	if i := strings.Index(string(re), "()"); i >= 0 {
+		return string(re)[:i], string(re)[i+2:], true
+	}
+	return "", "", true
+}
+
+// runeSet is a set of runes as pairs of inclusive bounds, like the Rune field
+// of a syntax.Regexp character class.
+type runeSet []rune
+
+var anyRune = runeSet{0, unicode.MaxRune}
+
+func (s runeSet) intersects(t runeSet) bool {
+	for i := 0; i+1 < len(s); i += 2 {
+		for j := 0; j+1 < len(t); j += 2 {
+			if s[i] <= t[j+1] && t[j] <= s[i+1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// foldRune returns r and, if fold, the other cases of it.
+func foldRune(r rune, fold bool) runeSet {
+	set := runeSet{r, r}
+	if fold {
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			set = append(set, f, f)
+		}
+	}
+	return set
+}
+
+// reFirst returns the runes a match of re can start with, and whether it can
+// match "".
+func reFirst(re *syntax.Regexp) (runeSet, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return nil, true
+		}
+		return foldRune(re.Rune[0], re.Flags&syntax.FoldCase != 0), false
+	case syntax.OpCharClass:
+		return runeSet(re.Rune), false
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return anyRune, false
+	case syntax.OpCapture, syntax.OpPlus:
+		return reFirst(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		first, _ := reFirst(re.Sub[0])
+		return first, true
+	case syntax.OpRepeat:
+		first, nullable := reFirst(re.Sub[0])
+		return first, nullable || re.Min == 0
+	case syntax.OpConcat:
+		var first runeSet
+		for _, sub := range re.Sub {
+			f, nullable := reFirst(sub)
+			first = append(first, f...)
+			if !nullable {
+				return first, false
+			}
+		}
+		return first, true
+	case syntax.OpAlternate:
+		var first runeSet
+		nullable := false
+		for _, sub := range re.Sub {
+			f, n := reFirst(sub)
+			first = append(first, f...)
+			nullable = nullable || n
+		}
+		return first, nullable
+	case syntax.OpNoMatch:
+		return nil, false
+	}
+	// Empty matches and assertions.
+	return nil, true
+}
+
+// reRunes returns every rune that a match of re can contain.
+func reRunes(re *syntax.Regexp) runeSet {
+	switch re.Op {
+	case syntax.OpLiteral:
+		var runes runeSet
+		for _, r := range re.Rune {
+			runes = append(runes, foldRune(r, re.Flags&syntax.FoldCase != 0)...)
+		}
+		return runes
+	case syntax.OpCharClass:
+		return runeSet(re.Rune)
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return anyRune
+	}
+	var runes runeSet
+	for _, sub := range re.Sub {
+		runes = append(runes, reRunes(sub)...)
+	}
+	return runes
+}
+
+// foldString returns a canonical case of s, which is the same for strings that
+// differ only in case.
+func foldString(s string) string {
+	return strings.ToLower(strings.ToUpper(s))
 }
 
 func rebuildGrammar(input parser.Grammar, callback func(t parser.Term) parser.Term) parser.Grammar {
@@ -163,7 +1031,8 @@ func fixTerm(term parser.Term, callback func(t parser.Term) parser.Term) parser.
 	case parser.CutPoint:
 		t.Term = fixTerm(t.Term, callback)
 		return callback(t)
-	case parser.S, parser.REF, parser.RE, parser.Rule, parser.ExtRef, parser.LookAhead:
+	case parser.S, parser.SI, parser.REF, parser.RE, parser.Rule, parser.ExtRef, parser.Position, parser.Token,
+		parser.LookAhead, parser.NotLookAhead, parser.LookBehind, parser.NotLookBehind:
 		return callback(term)
 	default:
 		panic(fmt.Errorf("fixTerm: unexpected term type: %v %[1]T", t))
@@ -3,37 +3,140 @@ package wbnf
 import (
 	"testing"
 
-	"github.com/arr-ai/frozen"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/arr-ai/wbnf/parser"
 )
 
-func TestStringCutpoints(t *testing.T) {
-	g := parser.Grammar{"a": parser.Seq{parser.S("hello"), parser.S("A"), parser.S("b"), parser.S("A")}}
+func cutPoints(t *testing.T, grammar string) []string {
+	t.Helper()
+	p, err := Compile(grammar, nil)
+	require.NoError(t, err)
+	return DescribeCutPoints(p.Grammar())
+}
+
+func TestCutPointsAfterDistinctFirstTokens(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(`x -> "(" y ")" | "[" y "]"; y -> "a";`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, parser.Oneof{
+		parser.Seq{parser.CutPoint{Term: parser.S("(")}, parser.Rule("y"), parser.S(")")},
+		parser.Seq{parser.CutPoint{Term: parser.S("[")}, parser.Rule("y"), parser.S("]")},
+	}, p.Grammar()["x"])
+	assert.Equal(t, []string{
+		`x: (cutpoint {"("} y ")"): its first tokens {"("} can't start the same text as its rivals {"["}`,
+		`x: (cutpoint {"["} y "]"): nothing else can be parsed where it starts`,
+	}, DescribeCutPoints(p.Grammar()))
+
+	_, err = p.Parse("x", parser.NewScanner("(a]"))
+	assert.IsType(t, parser.FatalError{}, err)
+}
+
+func TestCutPointsAfterSharedPrefix(t *testing.T) {
+	t.Parallel()
 
-	idents := findUniqueStrings(g)
+	p, err := Compile(`x -> "(" y ")" | "(" ")"; y -> "a";`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, parser.Oneof{
+		parser.Seq{parser.S("("), parser.CutPoint{Term: parser.Rule("y")}, parser.S(")")},
+		parser.Seq{parser.CutPoint{Term: parser.S("(")}, parser.S(")")},
+	}, p.Grammar()["x"])
+	assert.Contains(t, DescribeCutPoints(p.Grammar()),
+		`x: ("(" cutpoint {y} ")"): after its first 1 item(s), its tokens {"a"} can't start the same text `+
+			`as {")"}, which alternatives that begin the same way continue with`)
 
-	assert.EqualValues(
-		t,
-		frozen.NewSet("hello", "b").OrderedElements(stringLess),
-		idents.OrderedElements(stringLess),
-	)
+	for _, input := range []string{"(a)", "()"} {
+		_, err = p.Parse("x", parser.NewScanner(input))
+		assert.NoError(t, err, input)
+	}
 }
 
-func TestStringCutpointsInNestedGrammar(t *testing.T) {
-	// a -> foo "->"  { foo -> "a" "->"; };
-	// "a" is unique, "->" is not
-	g := parser.Grammar{"a": parser.ScopedGrammar{
-		Term:    parser.Seq{parser.Rule("foo"), parser.S("->")},
-		Grammar: parser.Grammar{"foo": parser.Seq{parser.S("a"), parser.S("->")}},
-	}}
+func TestCutPointsRivalLiterals(t *testing.T) {
+	t.Parallel()
 
-	idents := findUniqueStrings(g)
+	// Nothing that follows "%" can start "!", so "%!" rules it out.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"%!"} y "."): its first tokens {"%!"} can't start the same text as its rivals {"%"}`,
+		`x: (cutpoint {"%"} y): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> "%!" y "." | "%" y; y -> /{[a-z]+};`))
 
-	assert.EqualValues(t, frozen.NewSet("a").Elements(), idents.Elements())
+	// But here it can.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"%"} z): nothing else can be parsed where it starts`,
+		`z: (cutpoint {"!"} y): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> "%!" y "." | "%" z; y -> /{[a-z]+}; z -> "!" y;`))
+
+	// Nor can a shorter literal rule out a longer one.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"%!"} y): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> "%" y "." | "%!" y; y -> /{[a-z]+};`))
+}
+
+func TestCutPointsRegexps(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		`x: (cutpoint {"1"} "t"): its first tokens {"1"} can't start the same text as its rivals {/[a-z]+/}`,
+		`x: (cutpoint {/[a-z]+/} "u"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> /{\d+} "s" | "1" "t" | /{[a-z]+} "u";`))
+
+	// Case-insensitive strings clash with regexps that match either case.
+	assert.Equal(t, []string{
+		`x: (cutpoint {/[A-Z]+/} "b"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> i"a" "c" | /{[A-Z]+} "b";`))
 }
 
-func stringLess(a, b string) bool {
-	return a < b
+func TestCutPointsLoops(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		`x: (cutpoint {"do"} y): its first tokens {"do"} can't start the same text as its rivals {"end"}`,
+	}, cutPoints(t, `x -> ("do" y)* "end"; y -> /{\d+};`))
+
+	// "do" might be the start of "done", which follows the loop.
+	assert.Empty(t, cutPoints(t, `x -> ("do" y)* "done"; y -> /{\d+};`))
+
+	// A failed term after a separator ends a delimited list before it, where
+	// the term couldn't have started.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"do"} y): nothing else can be parsed where it starts`,
+		`x: (cutpoint {(cutpoint {"do"} y):","} "end"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> ("do" y):"," "end"; y -> /{\d+};`))
+}
+
+func TestCutPointsOpenChoices(t *testing.T) {
+	t.Parallel()
+
+	// Once "a" is parsed, x might still parse its second alternative if y
+	// fails, so nothing within y may cut, even though z has no rivals.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"a"} "(" "w"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> "a" y "b" | "a" "(" "w"; y -> "(" z; z -> "[" "q" "]";`))
+
+	// Nor within a lookaround.
+	assert.Equal(t, []string{
+		`x: ((?=("a" "b")) cutpoint {"a"} "b" "c"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> (?="a" "b") "a" "b" "c";`))
+}
+
+func TestCutPointsScopedGrammarsAndStacks(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		`x{y}: (cutpoint {"<"} z ">"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `x -> y {y -> "<" z ">"; z -> "q";};`))
+
+	// Tokens wrapped differently might start the same text.
+	assert.Equal(t, []string{
+		`x: (cutpoint {"<"} "r"): nothing else can be parsed where it starts`,
+	}, cutPoints(t, `
+		x -> (y {y -> "<" "q"; .wrapRE -> /{()};}) | "<" "r";
+		.wrapRE -> /{\s*()\s*};
+	`))
+
+	assert.Equal(t, []string{
+		`expr@5: (cutpoint {"("} @ ")"): nothing else can be parsed where it starts`,
+	}, DescribeCutPoints(exprGrammar))
 }
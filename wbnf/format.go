@@ -0,0 +1,405 @@
+package wbnf
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arr-ai/wbnf/ast"
+	"github.com/arr-ai/wbnf/parser"
+)
+
+// formatWidth is the column beyond which the alternatives of a production are
+// put on separate lines.
+const formatWidth = 80
+
+// Format parses a grammar and prints it in canonical layout.
+func Format(grammar string) (string, error) {
+	tree, err := Core().Parse("grammar", parser.NewScanner(grammar))
+	if err != nil {
+		return "", err
+	}
+	return FormatNode(NewGrammarNode(ast.FromParserNode(Core().Grammar(), tree))), nil
+}
+
+// FormatNode prints a grammar in canonical layout:
+//   - the arrows of adjacent productions are aligned;
+//   - the levels of a stack, and the alternatives of a oneof that doesn't fit
+//     on one line, are put on separate lines under the arrow;
+//   - strings are double-quoted unless single quotes avoid escaping;
+//   - nested grammars are indented.
+//
+// Comments, and single blank lines between statements, are kept.
+func FormatNode(tree GrammarNode) string {
+	var p printer
+	p.grammar(tree, "")
+	p.WriteString("\n")
+	return p.String()
+}
+
+type printer struct {
+	strings.Builder
+	base int // the column at which the printer's output starts
+}
+
+func (p *printer) column() int {
+	s := p.String()
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return utf8.RuneCountInString(s[i+1:])
+	}
+	return p.base + utf8.RuneCountInString(s)
+}
+
+// trial returns what render would print at the current column.
+func (p *printer) trial(render func(q *printer)) string {
+	q := &printer{base: p.column()}
+	render(q)
+	return q.String()
+}
+
+// stmtLines returns the first and last lines of a statement in its source.
+func stmtLines(stmt StmtNode) (first, last int) {
+	s := stmt.Scanner()
+	first, _ = s.Position()
+	return first, first + strings.Count(s.String(), "\n")
+}
+
+func (p *printer) grammar(g GrammarNode, indent string) {
+	stmts := g.AllStmt()
+	widths := alignments(stmts)
+	prevLast := 0
+	for i, stmt := range stmts {
+		first, last := stmtLines(stmt)
+		switch {
+		case i == 0:
+			p.WriteString(indent)
+		case stmt.OneComment() != nil && first == prevLast:
+			p.WriteString(" ")
+		case first > prevLast+1:
+			p.WriteString("\n\n" + indent)
+		default:
+			p.WriteString("\n" + indent)
+		}
+		p.stmt(stmt, widths[i], indent)
+		prevLast = last
+	}
+}
+
+// alignments returns the width to which each production's name is padded so
+// that the arrows of a run of productions line up. Blank lines, comments on
+// their own lines and pragmas end a run.
+func alignments(stmts []StmtNode) []int {
+	widths := make([]int, len(stmts))
+	start := 0
+	flush := func(end int) {
+		width := 0
+		for _, stmt := range stmts[start:end] {
+			if prod := stmt.OneProd(); prod != nil {
+				if n := utf8.RuneCountInString(prod.OneIdent().String()); n > width {
+					width = n
+				}
+			}
+		}
+		for i := start; i < end; i++ {
+			widths[i] = width
+		}
+		start = end
+	}
+	prevLast := 0
+	for i, stmt := range stmts {
+		first, last := stmtLines(stmt)
+		trailing := stmt.OneComment() != nil && first == prevLast
+		if !trailing && (stmt.OneProd() == nil || first > prevLast+1) {
+			flush(i)
+		}
+		prevLast = last
+	}
+	flush(len(stmts))
+	return widths
+}
+
+func (p *printer) stmt(stmt StmtNode, width int, indent string) {
+	switch {
+	case stmt.OneComment() != nil:
+		p.WriteString(strings.TrimRight(stmt.OneComment().String(), " \t"))
+	case stmt.OneProd() != nil:
+		p.prod(*stmt.OneProd(), width, indent)
+	case stmt.OnePragma().OneImport() != nil:
+		imp := stmt.OnePragma().OneImport()
+		p.WriteString(".import " + strings.Join(imp.OnePath().AllToken(), ""))
+		if namespace := imp.OneNamespace(); namespace != nil {
+			p.WriteString(" as " + namespace.String())
+		}
+		if rules := imp.AllRules(); len(rules) > 0 {
+			names := make([]string, 0, len(rules))
+			for _, rule := range rules {
+				names = append(names, rule.String())
+			}
+			p.WriteString(" (" + strings.Join(names, ", ") + ")")
+		}
+		if prefix := imp.OnePrefix(); prefix != nil {
+			p.WriteString(" prefix " + prefix.String())
+		}
+		if renames := imp.AllRename(); len(renames) > 0 {
+			pairs := make([]string, 0, len(renames))
+			for _, rename := range renames {
+				pairs = append(pairs, rename.AllFrom()[0].String()+" -> "+rename.AllTo()[0].String())
+			}
+			p.WriteString(" rename(" + strings.Join(pairs, ", ") + ")")
+		}
+	case stmt.OnePragma().OneExport() != nil:
+		idents := stmt.OnePragma().OneExport().AllIdent()
+		names := make([]string, 0, len(idents))
+		for _, ident := range idents {
+			names = append(names, ident.String())
+		}
+		p.WriteString(".export " + strings.Join(names, ", "))
+	default:
+		macro := stmt.OnePragma().OneMacrodef()
+		args := make([]string, 0, len(macro.AllArgs()))
+		for _, arg := range macro.AllArgs() {
+			args = append(args, arg.String())
+		}
+		p.WriteString(".macro " + macro.OneName().String() + "(" + strings.Join(args, ", ") + ") { ")
+		p.term(*macro.OneTerm(), indent)
+		p.WriteString(" }")
+	}
+}
+
+func (p *printer) prod(prod ProdNode, width int, indent string) {
+	name := prod.OneIdent().String()
+	op := prodOp(prod)
+	p.WriteString(name + strings.Repeat(" ", width-utf8.RuneCountInString(name)) + " " + op + " ")
+	col := p.column() - 2
+	for i, t := range prod.AllTerm() {
+		if i > 0 {
+			p.WriteString(" ")
+		}
+		p.body(t, col, indent)
+	}
+	p.WriteString(";")
+}
+
+// body prints the top-level term of a production, whose arrow ends at col.
+func (p *printer) body(t TermNode, col int, indent string) {
+	if t.OneOp() == ">" || t.OneNamed() == nil && len(t.AllTerm()) == 1 {
+		p.terms(t, "\n"+strings.Repeat(" ", col)+"> ", indent, func(child TermNode) {
+			p.alts(child, col, indent)
+		})
+		return
+	}
+	p.term(t, indent)
+}
+
+// alts prints a level of a production's stack, putting each alternative on its
+// own line if they don't fit on one.
+func (p *printer) alts(t TermNode, col int, indent string) {
+	if t.OneOp() != "|" {
+		p.term(t, indent)
+		return
+	}
+	line := p.trial(func(q *printer) { q.term(t, indent) })
+	if !strings.Contains(line, "\n") && p.column()+utf8.RuneCountInString(line)+1 <= formatWidth {
+		p.WriteString(line)
+		return
+	}
+	p.terms(t, "\n"+strings.Repeat(" ", col)+"| ", indent, func(child TermNode) {
+		p.term(child, indent)
+	})
+}
+
+func (p *printer) term(t TermNode, indent string) {
+	if named := t.OneNamed(); named != nil {
+		p.named(*named, indent)
+		for _, q := range t.AllQuant() {
+			p.quant(q, indent)
+		}
+		return
+	}
+	sep := " "
+	switch t.OneOp() {
+	case "|":
+		sep = " | "
+	case ">":
+		sep = " > "
+	}
+	p.terms(t, sep, indent, func(child TermNode) { p.term(child, indent) })
+}
+
+// terms prints the children of a term with render, each followed by the
+// nested grammar that is scoped to it, if any.
+func (p *printer) terms(t TermNode, sep, indent string, render func(child TermNode)) {
+	children := t.AllTerm()
+	grammars := t.AllGrammar()
+	for i, child := range children {
+		if i > 0 {
+			p.WriteString(sep)
+		}
+		render(child)
+		for _, g := range grammars {
+			offset := g.Scanner().Offset()
+			if offset > child.Scanner().Offset() &&
+				(i+1 == len(children) || offset < children[i+1].Scanner().Offset()) {
+				p.WriteString(" {\n")
+				p.grammar(g, indent+"    ")
+				p.WriteString("\n" + indent + "}")
+			}
+		}
+	}
+}
+
+func (p *printer) named(n NamedNode, indent string) {
+	if ident := n.OneIdent().String(); ident != "" {
+		p.WriteString(ident + "=")
+	}
+	p.atom(*n.OneAtom(), indent)
+}
+
+func (p *printer) quant(q QuantNode, indent string) {
+	switch q.Choice() {
+	case 0:
+		p.WriteString(q.OneOp())
+	case 1:
+		p.WriteString("{" + q.OneMin().String() + "," + q.OneMax().String() + "}")
+	case 2:
+		p.WriteString(q.OneOp() + q.OneOptLeading())
+		p.named(*q.OneNamed(), indent)
+		p.WriteString(q.OneOptTrailing())
+	}
+}
+
+func (p *printer) atom(a AtomNode, indent string) {
+	x, _ := ast.Which(a.Node.(ast.Branch), "RE", "STR", "macrocall", "ExtRef", "IDENT", "REF", "lookahead", "notlookahead", "lookbehind", "notlookbehind", "term", "pos", "tokref")
+	switch x {
+	case "IDENT":
+		p.WriteString(a.OneIdent().String())
+	case "STR":
+		p.WriteString(formatString(a.OneStr().String()))
+	case "RE":
+		p.regex(*a.OneRe())
+	case "macrocall":
+		call := a.OneMacrocall()
+		p.WriteString("%!" + call.OneName().String() + "(")
+		for i, arg := range call.AllTerm() {
+			if i > 0 {
+				p.WriteString(", ")
+			}
+			p.term(arg, indent)
+		}
+		p.WriteString(")")
+	case "ExtRef":
+		p.WriteString("%%" + a.OneExtRef().OneIdent().String())
+	case "REF":
+		ref := a.OneRef()
+		p.WriteString("%" + ref.OneIdent().String())
+		if def := ref.OneDefault().String(); def != "" {
+			p.WriteString("=" + formatString(def))
+		}
+	case "lookahead":
+		p.WriteString("(?=")
+		p.term(*a.OneLookahead(), indent)
+		p.WriteString(")")
+	case "notlookahead":
+		p.WriteString("(?!")
+		p.term(*a.OneNotlookahead(), indent)
+		p.WriteString(")")
+	case "lookbehind":
+		p.WriteString("(?<=")
+		p.term(*a.OneLookbehind(), indent)
+		p.WriteString(")")
+	case "notlookbehind":
+		p.WriteString("(?<!")
+		p.term(*a.OneNotlookbehind(), indent)
+		p.WriteString(")")
+	case "term":
+		p.WriteString("(")
+		p.term(*a.OneTerm(), indent)
+		p.WriteString(")")
+	case "pos":
+		p.WriteString(buildPosition(*a.OnePos()).String())
+	case "tokref":
+		tokref := a.OneTokref()
+		p.WriteString(tokref.OneIdent().String() + "::" + tokref.OneLabel().String())
+	default:
+		p.WriteString("()")
+	}
+}
+
+// regex prints a regex as written, shifting the indentation of any further
+// lines by as much as the regex itself has moved.
+func (p *printer) regex(re ReNode) {
+	_, col := re.Scanner().Position()
+	shift := p.column() - (col - 1)
+	for i, line := range strings.Split(re.String(), "\n") {
+		if i > 0 {
+			p.WriteString("\n")
+			if shift > 0 {
+				line = strings.Repeat(" ", shift) + line
+			} else {
+				trimmed := strings.TrimLeft(line, " ")
+				if len(line)-len(trimmed) > -shift {
+					trimmed = line[-shift:]
+				}
+				line = trimmed
+			}
+		}
+		p.WriteString(line)
+	}
+}
+
+var stringEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+	'\\': '\\', '\'': '\'', '"': '"',
+}
+
+// formatString requotes a string literal, preferring double quotes unless the
+// string contains them and no single quotes. Literals using other escapes are
+// left as they are.
+func formatString(lit string) string {
+	if strings.HasPrefix(lit, "i") {
+		return "i" + formatString(lit[1:])
+	}
+	quote, body := lit[0], lit[1:len(lit)-1]
+	var value strings.Builder
+	if quote == '`' {
+		value.WriteString(strings.ReplaceAll(body, "``", "`"))
+	} else {
+		for i := 0; i < len(body); i++ {
+			c := body[i]
+			if c == '\\' {
+				i++
+				e, ok := stringEscapes[body[i]]
+				if !ok || e == '"' && quote != '"' {
+					return lit
+				}
+				c = e
+			}
+			value.WriteByte(c)
+		}
+	}
+
+	s := value.String()
+	quote = '"'
+	if strings.Contains(s, `"`) && !strings.Contains(s, `'`) {
+		quote = '\''
+	}
+	var sb strings.Builder
+	sb.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote || c == '\\':
+			sb.WriteByte('\\')
+		case c < ' ':
+			for e, v := range stringEscapes {
+				if v == c {
+					sb.WriteByte('\\')
+					c = e
+					break
+				}
+			}
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte(quote)
+	return sb.String()
+}
@@ -0,0 +1,127 @@
+package wbnf
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/arr-ai/wbnf/parser/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertFormat(t *testing.T, expected, input string) {
+	t.Helper()
+	actual, err := Format(input)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestFormat(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, "a   -> b c;\nbcd -> \"x\";\n", "a->b  c ;\n  bcd ->'x';")
+	assertFormat(t, "a -> (b | c)* d:\",\"+ e{1,3} %!m(f, g) %%x %r=\"y\" (?=z) ();\n",
+		"a -> ( b|c )* d:\",\"+ e{1,3} %!m(f,g) %%x %r=`y` (?= z ) ( );")
+	assertFormat(t, "a -> (?!b) (?<=c d) (?<!\"e\") f;\n", "a -> (?! b )(?<=c  d)(?<! 'e' ) f;")
+	assertFormat(t, "a -> b:op=\">\"\n   > c | d\n   > @+;\n", "a -> b:op=\">\" > c | d > @+;")
+	assertFormat(t, "a -> 'a\"b' \"c'd\" \"\\\\\\n\" \"`\" '\\x41';\n",
+		"a -> \"a\\\"b\" 'c\\'d' `\\\n` ```` '\\x41';")
+}
+
+func TestFormatStatements(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, `// header
+a   -> b; // trailing
+bcd -> c;
+// own line
+e -> f;
+
+.import ../x/y.wbnf
+.macro M(p, q) { p q }
+`, `// header
+a -> b;   // trailing
+bcd -> c;
+// own line
+e -> f;
+
+
+.import ../x / y.wbnf;
+.macro M ( p,q ) { p  q };`)
+}
+
+func TestFormatComposition(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, `.import x.wbnf prefix p_ rename(a -> b, c -> d)
+.export a, b
+a      |= b;
+longer = c
+       > d;
+`, `.import x.wbnf prefix p_ rename(a->b,c->d); .export a,b; a |= b; longer = c > d;`)
+	assertFormat(t, `.import x.wbnf as x (a, b)
+y -> x.a x.b;
+`, `.import x.wbnf as x(a,b); y -> x.a x.b;`)
+}
+
+func TestFormatLongOneof(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, `atom -> IDENT
+      | STR
+      | "a very long alternative that goes on"
+      | "another very long alternative";
+`, `atom -> IDENT | STR | "a very long alternative that goes on" | "another very long alternative";`)
+}
+
+func TestFormatNestedGrammar(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, `pragma -> import | macrodef {
+    import   -> ".import" path;
+    macrodef -> ".macro" {
+        x -> y;
+    };
+};
+`, `pragma -> import | macrodef { import -> ".import" path; macrodef -> ".macro" { x -> y; }; };`)
+}
+
+func TestFormatRegex(t *testing.T) {
+	t.Parallel()
+	assertFormat(t, `STR -> /{ " (?: \\. | [^\\"] )* "
+       | ' (?: \\. | [^\\'] )* '
+       };
+`, `STR       -> /{ " (?: \\. | [^\\"] )* "
+             | ' (?: \\. | [^\\'] )* '
+             };
+`)
+}
+
+// dirResolver resolves the imports of a grammar in the given directory.
+type dirResolver string
+
+func (r dirResolver) Resolve(from, path string) string {
+	if from == "" {
+		return filepath.Join(string(r), path)
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+func TestFormatExamples(t *testing.T) {
+	t.Parallel()
+	for _, filename := range append(memoExamples, "../examples/xml.wbnf", "../examples/indents.wbnf") {
+		text := loadExample(t, filename)
+		formatted, err := Format(text)
+		require.NoError(t, err, filename)
+
+		again, err := Format(formatted)
+		require.NoError(t, err, filename)
+		assert.Equal(t, formatted, again, filename)
+
+		// Some examples are only parts of a grammar, imported by others.
+		resolver := dirResolver(filepath.Dir(filename))
+		before, err := Compile(text, resolver)
+		if err != nil {
+			continue
+		}
+		after, err := Compile(formatted, resolver)
+		require.NoError(t, err, filename)
+		d := diff.Grammars(before.Grammar(), after.Grammar())
+		assert.True(t, d.Equal(), "%s: %v", filename, d)
+	}
+}
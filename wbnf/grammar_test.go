@@ -36,10 +36,10 @@ var exprGrammar = parser.Grammar{
 	expr: parser.Stack{
 		parser.Delim{Term: parser.At, Sep: parser.RE(`[-+]`)},
 		parser.Delim{Term: parser.At, Sep: parser.RE(`[*/]`)},
-		parser.Seq{parser.Opt(parser.CutPoint{Term: parser.S("-")}), parser.At},
+		parser.Seq{parser.Opt(parser.S("-")), parser.At},
 		parser.Oneof{parser.RE(`\d+`), parser.At},
-		parser.R2L(parser.At, parser.CutPoint{Term: parser.S("**")}),
-		parser.Seq{parser.CutPoint{Term: parser.S("(")}, parser.At, parser.CutPoint{Term: parser.S(")")}},
+		parser.R2L(parser.At, parser.S("**")),
+		parser.Seq{parser.CutPoint{Term: parser.S("(")}, parser.At, parser.S(")")},
 	},
 }
 
@@ -109,7 +109,7 @@ func TestParseNamedTerm(t *testing.T) {
 		stack(`_`).z(stack(`term@1`, parser.NonAssociative).a(`term@2`).a(`term@3`).z(
 			stack(`named`).z(
 				stack(`?`).a(`_`).z(*r.Slice(0, 3), *r.Slice(3, 4)),
-				stack(`atom`, parser.Choice(1)).z(*r.Slice(4, 6)),
+				stack(`atom`, parser.Choice(0)).z(*r.Slice(4, 6)),
 			), stack(`?`).z(),
 		), stack(`?`).z(),
 		))
@@ -122,14 +122,14 @@ func TestParseNamedTermInDelim(t *testing.T) {
 		stack(`_`).z(stack(`term@1`, parser.NonAssociative).a(`term@2`).a(`term@3`).z(
 			stack(`named`).z(
 				stack(`?`).z(),
-				stack(`atom`, parser.Choice(1)).z(*r.Slice(0, 3)),
+				stack(`atom`, parser.Choice(0)).z(*r.Slice(0, 3)),
 			),
 			stack(`?`).a(`quant`, parser.Choice(2)).a(`_`).z(
 				*r.Slice(3, 4),
 				stack(`?`).z(),
 				stack(`named`).z(
 					stack(`?`).a(`_`).z(*r.Slice(4, 6), *r.Slice(6, 7)),
-					stack(`atom`, parser.Choice(1)).z(*r.Slice(7, 10)),
+					stack(`atom`, parser.Choice(0)).z(*r.Slice(7, 10)),
 				),
 				stack(`?`).z(),
 			),
@@ -181,17 +181,7 @@ func TestExprGrammarGrammar(t *testing.T) {
 	v, err := parsers.Parse("grammar", r)
 	require.NoError(t, err, "r=%v\nv=%v", r.Context(parser.NoLimit), v)
 	require.Equal(t, len(exprGrammarSrc), r.Offset(), "r=%v\nv=%v", r.Context(parser.NoLimit), v)
-	assertUnparse(t,
-		`// Simple expression grammar`+
-			`expr->@:[-+]`+
-			`>@:[*/]`+
-			`>"-"?@`+
-			`>\d+|@`+
-			`>@<:"**"`+
-			`>"("@")";`,
-		parsers,
-		v,
-	)
+	assertUnparse(t, exprGrammarSrc, parsers, v)
 }
 
 func TestGrammarSnippet(t *testing.T) {
@@ -202,7 +192,7 @@ func TestGrammarSnippet(t *testing.T) {
 	v, err := parsers.Parse("term", r)
 	require.NoError(t, err)
 	assert.Equal(t,
-		`term║:[_[term@1║:[term@2[term@3[named[?[], atom║0[prod]], ?[quant║0[+]]]]], ?[]]]`,
+		`term║:[_[term@1║:[term@2[term@3[named[?[], atom║2[prod]], ?[quant║0[+]]]]], ?[]]]`,
 		fmt.Sprintf("%v", v),
 	)
 	assertUnparse(t, "prod+", parsers, v)
@@ -212,7 +202,7 @@ func TestTinyGrammarGrammarGrammar(t *testing.T) {
 	t.Parallel()
 
 	tiny := parser.Rule("tiny")
-	tinyGrammar := parser.Grammar{tiny: parser.CutPoint{Term: parser.S("x")}}
+	tinyGrammar := parser.Grammar{tiny: parser.S("x")}
 	tinyGrammarSrc := `tiny -> "x";`
 
 	parsers := Core()
@@ -283,6 +273,157 @@ func TestLookAhead(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCaseInsensitiveStrings(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(`
+		query -> i"select" i:"," i"from" i;
+		i -> \w+;
+		.wrapRE -> /{\s*()\s*};
+	`, nil)
+	require.NoError(t, err)
+
+	_, err = p.Parse("query", parser.NewScanner("SELECT a, b From c"))
+	assert.NoError(t, err)
+
+	seq := p.Grammar()["query"].(parser.Seq)
+	assert.Equal(t, parser.CutPoint{Term: parser.SI("select")}, seq[0])
+	assert.Equal(t, parser.SI("from"), seq[2])
+
+	assertFormat(t, "a -> i\"x\" i\"y'\";\n", "a -> i'x' i`y'`;")
+}
+
+func TestIndentation(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(loadExample(t, "../examples/indents.wbnf"), nil)
+	require.NoError(t, err)
+
+	for _, input := range []string{
+		"print a",
+		"if a:\n  print b\nprint c",
+		"if a:\n    while b:\n\tprint c\n    print d\nprint e",
+		"if a:\n\n  print b\n\n  print c",
+	} {
+		_, err := p.Parse("block", parser.NewScanner(input))
+		assert.NoError(t, err, "%q", input)
+	}
+	for _, input := range []string{
+		"if a:\nprint b",
+		"if a:\n  print b\n print c",
+		"print a\n  print b",
+	} {
+		_, err := p.Parse("block", parser.NewScanner(input))
+		assert.Error(t, err, "%q", input)
+	}
+
+	// The outer block resumes after the nested one.
+	v, err := p.Parse("block", parser.NewScanner("if a:\n  print b\n  print c\nprint d"))
+	require.NoError(t, err)
+	tree := ast.FromParserNode(p.Grammar(), v)
+	assert.Len(t, tree.Many("stmt"), 2)
+}
+
+func TestPositions(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(`
+		stmt   -> ln=@line "return" @line(=ln) IDENT;
+		first  -> @col(=0) IDENT;
+		IDENT  -> \w+;
+		.wrapRE -> /{\s*()\s*};
+	`, nil)
+	require.NoError(t, err)
+
+	_, err = p.Parse("stmt", parser.NewScanner("return x"))
+	assert.NoError(t, err)
+	_, err = p.Parse("stmt", parser.NewScanner("return\nx"))
+	assert.Error(t, err)
+	_, err = p.Parse("first", parser.NewScanner("\nx"))
+	assert.NoError(t, err)
+	_, err = p.Parse("first", parser.NewScanner(" x"))
+	assert.Error(t, err)
+
+	seq := p.Grammar()["stmt"].(parser.Seq)
+	assert.Equal(t, parser.Named{Name: "ln", Term: parser.Position{Prop: parser.PosLine}}, seq[0])
+	assert.Equal(t, parser.Position{Prop: parser.PosLine, Op: "=", Ref: "ln"}, seq[2])
+	assert.Equal(t, "@col(=0)", p.Grammar()["first"].(parser.Seq)[0].String())
+
+	assertFormat(t, "a -> x=@col @col(>x) @offset(!=3) b;\n", "a -> x = @col @col( > x ) @offset(!=3)b;")
+}
+
+func TestTokenRefs(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(`
+		stmt -> tok::kw_if tok::lparen expr tok::rparen stmt | expr;
+		expr -> tok::ident:tok::op;
+		tok  -> kw_if="if"
+		      | ident=/{[a-z]\w*}
+		      | op=("+" | "++")
+		      | lparen="("
+		      | rparen=")" { .wrapRE -> /{()}; };
+		.wrapRE -> /{\s*()\s*};
+	`, nil)
+	require.NoError(t, err)
+
+	e, err := p.Parse("stmt", parser.NewScanner("if (iff ++ x) y"))
+	require.NoError(t, err)
+	stmt := ast.FromParserNode(p.Grammar(), e)
+	toks := stmt.Many("tok")
+	require.Len(t, toks, 3)
+	assert.Equal(t, "kw_if", ast.Label(toks[0]))
+	assert.Equal(t, "(", toks[1].One("").Scanner().String())
+	assert.Equal(t, "rparen", ast.Label(toks[2]))
+	expr := stmt.One("expr")
+	assert.Equal(t, "iff", ast.First(expr, "tok").One("").Scanner().String())
+	assert.Equal(t, []string{"ident", "op", "ident"}, []string{
+		ast.Label(expr.Many("tok")[0]), ast.Label(expr.Many("tok")[1]), ast.Label(expr.Many("tok")[2]),
+	})
+	assert.Equal(t, e, ast.ToParserNode(p.Grammar(), stmt))
+
+	_, err = p.Parse("stmt", parser.NewScanner("if (if) y"))
+	assert.Error(t, err)
+
+	assert.Equal(t, parser.Token{Rule: "tok", Label: "ident"}, p.Grammar()["expr"].(parser.Delim).Term)
+	assertFormat(t, "a -> tok::x b;\n", "a -> tok :: x b;")
+
+	// A grammar that validates compiles, even if its token rule has one
+	// alternative.
+	node, err := ParseString("a -> t::x; t -> x='x';")
+	require.NoError(t, err)
+	require.Empty(t, Validate(node))
+	p, err = Compile("a -> t::x; t -> x='x';", nil)
+	require.NoError(t, err)
+	_, err = p.Parse("a", parser.NewScanner("x"))
+	assert.NoError(t, err)
+}
+
+func TestLookArounds(t *testing.T) {
+	t.Parallel()
+
+	p, err := Compile(`
+		stmt  -> ident ("." ident)*;
+		ident -> (?!keyword) (?<!"$") /{[a-z]+};
+		keyword -> /{(?:if|else)\b};
+		money -> "$" (?<="$") /{\d+};
+		.wrapRE -> /{\s*()\s*};
+	`, nil)
+	require.NoError(t, err)
+
+	_, err = p.Parse("stmt", parser.NewScanner("a . iffy.b"))
+	assert.NoError(t, err)
+	_, err = p.Parse("stmt", parser.NewScanner("a.if.b"))
+	assert.Error(t, err)
+	_, err = p.Parse("money", parser.NewScanner("$ 10"))
+	assert.NoError(t, err)
+
+	g := p.Grammar()
+	assert.Equal(t, parser.NotLookAhead{Term: parser.Rule("keyword")}, g["ident"].(parser.Seq)[0])
+	assert.Equal(t, parser.NotLookBehind{Term: parser.S("$")}, g["ident"].(parser.Seq)[1])
+	assert.Equal(t, parser.LookBehind{Term: parser.S("$")}, g["money"].(parser.Seq)[1])
+}
+
 func TestScopeGrammar(t *testing.T) {
 	t.Parallel()
 	g := parser.Grammar{
@@ -326,3 +467,36 @@ func TestScopeGrammarwithWrapping(t *testing.T) {
 
 	parser.AssertEqualNodes(t, te.(parser.Node), te2.(parser.Node))
 }
+
+func TestParseWithRecovery(t *testing.T) {
+	t.Parallel()
+	p := MustCompile(`
+		stmts -> stmt*;
+		stmt  -> "let" IDENT "=" INT ";";
+		IDENT -> [a-z]+;
+		INT   -> \d+;
+		.sync -> ";";
+		.wrapRE -> /{\s*()\s*};
+	`, nil)
+
+	_, err := p.Parse("stmts", parser.NewScanner("let a = 1; let b = ?; let c = 3;"), parser.WithRecovery())
+	require.IsType(t, parser.RecoveryError{}, err)
+	re := err.(parser.RecoveryError)
+	require.Len(t, re.Errors(), 1)
+
+	tree := ast.FromParserNode(p.Grammar(), re.Result())
+	assert.Len(t, tree.Many("stmt"), 2)
+	errs := ast.Errors(tree)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "let b = ?; ", errs[0].String())
+}
+
+func TestUnparseExamples(t *testing.T) {
+	t.Parallel()
+	for _, filename := range append(memoExamples, "../examples/xml.wbnf", "../examples/indents.wbnf") {
+		text := loadExample(t, filename)
+		v, err := Core().Parse("grammar", parser.NewScanner(text))
+		require.NoError(t, err, filename)
+		assertUnparse(t, text, Core(), v)
+	}
+}
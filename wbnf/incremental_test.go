@@ -0,0 +1,53 @@
+package wbnf
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/arr-ai/wbnf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReparseMatchesParse(t *testing.T) {
+	t.Parallel()
+	const snippets = "abc ->;|()\"\n/"
+	rng := rand.New(rand.NewSource(1))
+	for _, filename := range memoExamples {
+		text := loadExample(t, filename)
+		tree, s, err := Core().Reparse("grammar", nil, parser.NewScanner(text), nil)
+		require.NoError(t, err, filename)
+		for i := 0; i < 30; i++ {
+			edit := parser.Edit{Offset: rng.Intn(len(text))}
+			switch rng.Intn(3) {
+			case 0:
+				edit.Text = snippets[rng.Intn(len(snippets)):][:1]
+			case 1:
+				edit.Length = 1
+			default:
+				// Duplicate a character.
+				edit.Text = text[edit.Offset:][:1]
+			}
+			tree, s, err = Core().Reparse("grammar", tree, s, []parser.Edit{edit})
+			text = s.String()
+
+			expected, expectedErr := Core().Parse("grammar", parser.NewScanner(text))
+			if expectedErr != nil {
+				// Some failures have error trees too deep to print, so compare
+				// where they occurred.
+				require.Error(t, err, "%s %v", filename, edit)
+				assert.IsType(t, expectedErr, err, "%s %v", filename, edit)
+				if expectedErr, ok := expectedErr.(interface{ Offset() int }); ok {
+					assert.Equal(t, expectedErr.Offset(), err.(interface{ Offset() int }).Offset(), "%s %v", filename, edit)
+				}
+				// Start over from the original text.
+				text = loadExample(t, filename)
+				tree, s, err = Core().Reparse("grammar", nil, parser.NewScanner(text), nil)
+				require.NoError(t, err)
+				continue
+			}
+			require.NoError(t, err, "%s %v", filename, edit)
+			assert.Equal(t, expected.(parser.Node).String(), tree.(parser.Node).String(), "%s %v", filename, edit)
+		}
+	}
+}
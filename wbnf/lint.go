@@ -0,0 +1,356 @@
+package wbnf
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/arr-ai/wbnf/ast"
+	"github.com/arr-ai/wbnf/parser"
+)
+
+// Lint returns the problems Validate finds in a grammar, given the findings Load
+// returned for it, and, if there are no errors among them, warnings about
+// constructs that are valid but likely mistakes. If startRule is "", the first
+// rule of the grammar, rather than of those it imports, is the start.
+func Lint(tree GrammarNode, startRule string, findings ...error) []error {
+	findings = Validate(tree, findings...)
+	for _, err := range findings {
+		// Errors of other kinds count as errors.
+		var v validationError
+		if !errors.As(err, &v) || v.severity == SeverityError {
+			return findings
+		}
+	}
+	l := &linter{
+		g:    newGrammarBuilder(tree.Node).buildGrammar(tree.Node),
+		gb:   newGrammarBuilder(tree.Node),
+		defs: map[string]parser.Scanner{},
+	}
+	stmts := tree.AllStmt()
+	// Imported rules are merged ahead of the grammar's own, so its own file is
+	// that of its last statement.
+	file := ""
+	if len(stmts) > 0 {
+		file = stmts[len(stmts)-1].Scanner().Filename()
+	}
+	for _, stmt := range stmts {
+		if prod := stmt.OneProd(); prod != nil && prodOp(*prod) == defineOp {
+			rule := prod.OneIdent().String()
+			l.defs[rule] = prod.OneIdent().Scanner()
+			if startRule == "" && !isMagicRule(rule) && prod.Scanner().Filename() == file {
+				startRule = rule
+			}
+		}
+	}
+	l.nullables = parser.NullableRules(l.g)
+	l.lintRules(startRule)
+	l.walk(tree)
+	for _, w := range l.warnings {
+		findings = append(findings, w)
+	}
+	return findings
+}
+
+type linter struct {
+	g         parser.Grammar
+	gb        grammarBuilder
+	defs      map[string]parser.Scanner // where each top-level rule is defined
+	nullables map[parser.Rule]bool      // the rules that can match without consuming input
+	warnings  []validationError
+}
+
+func (l *linter) warn(s parser.Scanner, kind ValidationErrorKind, msg string, args ...any) {
+	l.warnings = append(l.warnings, validationError{s: s, msg: msg, args: args, kind: kind, severity: SeverityWarning})
+}
+
+// lintRules reports rules that aren't used by any other rule and those that
+// can't be reached from start.
+func (l *linter) lintRules(start string) {
+	used := map[parser.Rule]bool{}
+	for rule, term := range l.g {
+		ruleRefs(term, func(ref parser.Rule) {
+			if ref != rule {
+				used[ref] = true
+			}
+		})
+	}
+	if _, has := l.g[parser.Rule(start)]; !has {
+		l.warnings = append(l.warnings, validationError{
+			msg: fmt.Sprintf("start rule '%s' is not a defined rule", start), kind: UnknownRule})
+		return
+	}
+	reachable := map[parser.Rule]bool{}
+	var reach func(rule parser.Rule)
+	reach = func(rule parser.Rule) {
+		if term, has := l.g[rule]; has && !reachable[rule] {
+			reachable[rule] = true
+			ruleRefs(term, reach)
+		}
+	}
+	reach(parser.Rule(start))
+
+	rules := make([]string, 0, len(l.g))
+	for rule := range l.g {
+		rules = append(rules, string(rule))
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		if isMagicRule(rule) || rule == start {
+			continue
+		}
+		switch {
+		case !used[parser.Rule(rule)]:
+			l.warn(l.defs[rule], UnusedRule, "rule '%s' is not used by any other rule")
+		case !reachable[parser.Rule(rule)]:
+			l.warn(l.defs[rule], UnreachableRule, "rule '%s' can't be reached from %s", start)
+		}
+	}
+}
+
+// ruleRefs calls visit with each rule that t refers to.
+func ruleRefs(t parser.Term, visit func(parser.Rule)) {
+	switch t := t.(type) {
+	case parser.Rule:
+		visit(t)
+	case parser.Token:
+		visit(t.Rule)
+	case parser.Seq:
+		for _, term := range t {
+			ruleRefs(term, visit)
+		}
+	case parser.Oneof:
+		for _, term := range t {
+			ruleRefs(term, visit)
+		}
+	case parser.Stack:
+		for _, term := range t {
+			ruleRefs(term, visit)
+		}
+	case parser.Delim:
+		ruleRefs(t.Term, visit)
+		ruleRefs(t.Sep, visit)
+	case parser.Quant:
+		ruleRefs(t.Term, visit)
+	case parser.Named:
+		ruleRefs(t.Term, visit)
+	case parser.CutPoint:
+		ruleRefs(t.Term, visit)
+	case parser.LookAhead:
+		ruleRefs(t.Term, visit)
+	case parser.NotLookAhead:
+		ruleRefs(t.Term, visit)
+	case parser.LookBehind:
+		ruleRefs(t.Term, visit)
+	case parser.NotLookBehind:
+		ruleRefs(t.Term, visit)
+	case parser.ScopedGrammar:
+		// Rules of the scoped grammar that shadow outer ones are counted as
+		// uses of the outer ones, which at worst hides a warning.
+		ruleRefs(t.Term, visit)
+		for _, term := range t.Grammar {
+			ruleRefs(term, visit)
+		}
+	}
+}
+
+func (l *linter) walk(tree GrammarNode) {
+	// The .wrapRE of each enclosing scope, innermost last.
+	wraps := []parser.Term{l.g[".wrapRE"]}
+	// How many negative lookarounds enclose the current node.
+	negated := 0
+	WalkerOps{
+		EnterTermNode: func(node TermNode) Stopper {
+			if grammars := node.AllGrammar(); len(grammars) > 0 {
+				wrap := wraps[len(wraps)-1]
+				for _, stmt := range grammars[0].AllStmt() {
+					if prod := stmt.OneProd(); prod != nil && prod.OneIdent().String() == ".wrapRE" {
+						wrap = l.gb.buildProd(*prod)
+						// The rules of a namespaced or selective import are
+						// scoped to its .wrapRE without braces, and meant to be.
+						written := ast.First(node.Node, "") != nil
+						if written && wrap.String() != termString(wraps[len(wraps)-1]) {
+							l.warn(prod.OneIdent().Scanner(), WrapREOverride,
+								"'%s' of a scoped grammar differs from that of the enclosing grammar, "+
+									"so tokens are wrapped differently within it")
+						}
+					}
+				}
+				wraps = append(wraps, wrap)
+			}
+			if node.OneOp() == "|" {
+				l.lintAlternatives(node)
+			}
+			if named := node.OneNamed(); named != nil {
+				l.lintLoops(node)
+			}
+			return nil
+		},
+		ExitTermNode: func(node TermNode) Stopper {
+			if len(node.AllGrammar()) > 0 {
+				wraps = wraps[:len(wraps)-1]
+			}
+			return nil
+		},
+		EnterAtomNode: func(node AtomNode) Stopper {
+			if node.OneNotlookahead() != nil || node.OneNotlookbehind() != nil {
+				negated++
+			}
+			return nil
+		},
+		ExitAtomNode: func(node AtomNode) Stopper {
+			if node.OneNotlookahead() != nil || node.OneNotlookbehind() != nil {
+				negated--
+			}
+			return nil
+		},
+		EnterNamedNode: func(node NamedNode) Stopper {
+			name := node.OneIdent()
+			switch {
+			case name == nil:
+			case negated > 0:
+				l.warn(name.Scanner(), RedundantName,
+					"name '%s' is redundant, since nothing matched by a negative lookaround is captured")
+			case innerName(node) == name.String():
+				l.warn(name.Scanner(), RedundantName,
+					"name '%s' is redundant, since the term it names has the same name")
+			}
+			return nil
+		},
+	}.Walk(tree)
+}
+
+// innerName returns the name of the term that a named term wraps, as in x=(x="a").
+func innerName(node NamedNode) string {
+	term := node.OneAtom().OneTerm()
+	for term != nil {
+		if terms := term.AllTerm(); len(terms) == 1 && len(term.AllGrammar()) == 0 {
+			term = &terms[0]
+			continue
+		}
+		if named := term.OneNamed(); named != nil && len(term.AllQuant()) == 0 {
+			if ident := named.OneIdent(); ident != nil {
+				return ident.String()
+			}
+		}
+		break
+	}
+	return ""
+}
+
+func termString(t parser.Term) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
+// lintAlternatives reports alternatives of an ordered choice that can never
+// match, because an earlier alternative always matches first.
+func (l *linter) lintAlternatives(node TermNode) {
+	children := node.AllTerm()
+	alts := make([]parser.Term, 0, len(children))
+	for _, child := range children {
+		alts = append(alts, l.gb.buildTerm(child))
+	}
+	for j, later := range alts {
+		for i, earlier := range alts[:j] {
+			if l.shadows(earlier, later) {
+				l.warn(children[j].Scanner(), ShadowedAlternative,
+					"alternative %s never matches, since alternative %d (%s) matches first", i+1, children[i].Scanner().String())
+				break
+			}
+		}
+	}
+}
+
+// terminal returns the terminal that t matches, looking through names and
+// rules that are terminals.
+func (l *linter) terminal(t parser.Term) parser.Term {
+	for {
+		switch u := t.(type) {
+		case parser.Named:
+			t = u.Term
+		case parser.CutPoint:
+			t = u.Term
+		case parser.Rule:
+			term, has := l.g[u]
+			if !has {
+				return nil
+			}
+			t = term
+		case parser.S, parser.SI, parser.RE:
+			return t
+		default:
+			return nil
+		}
+	}
+}
+
+// shadows reports whether, wherever later would match, earlier matches instead.
+func (l *linter) shadows(earlier, later parser.Term) bool {
+	earlier, later = l.terminal(earlier), l.terminal(later)
+	if earlier == nil || later == nil {
+		return false
+	}
+	// The text that every match of later starts with.
+	prefix, complete := "", false
+	switch t := later.(type) {
+	case parser.S:
+		prefix, complete = string(t), true
+	case parser.SI:
+		// Other cases of it are matched too.
+		return false
+	case parser.RE:
+		re, err := regexp.Compile(string(t))
+		if err != nil {
+			return false
+		}
+		prefix, complete = re.LiteralPrefix()
+	}
+	switch t := earlier.(type) {
+	case parser.S:
+		return t != "" && strings.HasPrefix(prefix, string(t))
+	case parser.SI:
+		return t != "" && len(prefix) >= len(t) && strings.EqualFold(prefix[:len(t)], string(t))
+	case parser.RE:
+		if !complete {
+			return false
+		}
+		re, err := regexp.Compile(`\A(?:` + string(t) + `)`)
+		if err != nil {
+			return false
+		}
+		loc := re.FindStringIndex(prefix)
+		return loc != nil && loc[1] > 0
+	}
+	return false
+}
+
+// lintLoops reports repetitions whose body can match without consuming input,
+// so that they may loop without progressing.
+func (l *linter) lintLoops(node TermNode) {
+	term := l.gb.buildNamed(*node.OneNamed())
+	quants := node.AllQuant()
+	for i := range quants {
+		q := quants[len(quants)-1-i]
+		next := l.gb.buildQuant(q, term)
+		var empty bool
+		switch next := next.(type) {
+		case parser.Quant:
+			empty = next.Max == 0 && l.nullable(next.Term)
+		case parser.Delim:
+			empty = l.nullable(next.Term) && l.nullable(next.Sep)
+		}
+		if empty {
+			l.warn(q.Scanner(), EmptyLoop, "repetition %s can match nothing each time, so it may loop without progressing")
+		}
+		term = next
+	}
+}
+
+func (l *linter) nullable(t parser.Term) bool {
+	return parser.IsNullable(t, l.nullables)
+}
@@ -0,0 +1,122 @@
+package wbnf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lintFindings(t *testing.T, grammar, start string) []string {
+	t.Helper()
+	node, err := ParseString(grammar)
+	require.NoError(t, err)
+	var findings []string
+	for _, err := range Lint(node, start) {
+		findings = append(findings, err.Error())
+	}
+	return findings
+}
+
+func TestLintShadowedAlternatives(t *testing.T) {
+	t.Parallel()
+	assert.ElementsMatch(t, []string{
+		"1:13: alternative '<=' never matches, since alternative 1 ('<') matches first",
+		"2:22: alternative 'if' never matches, since alternative 1 (/{[a-z]+}) matches first",
+		"3:13: alternative /{abc\\d} never matches, since alternative 1 (\"ab\") matches first",
+	}, lintFindings(t, `op -> '<' | '<=' | '>';
+ident -> /{[a-z]+} | 'if';
+x -> "ab" | /{abc\d} | op | ident;
+`, "x"))
+
+	// Longer literals first is the right way round.
+	assert.Empty(t, lintFindings(t, `op -> '<=' | '<' | /{\d} 'x';`, ""))
+}
+
+func TestLintRules(t *testing.T) {
+	t.Parallel()
+	grammar := `doc -> a b;
+a -> 'a';
+b -> 'b' b?;
+c -> d;
+d -> 'd';
+.wrapRE -> /{\s*()\s*};
+`
+	assert.ElementsMatch(t, []string{
+		"4:1: rule 'c' is not used by any other rule",
+		"5:1: rule 'd' can't be reached from doc",
+	}, lintFindings(t, grammar, "doc"))
+	assert.ElementsMatch(t, []string{
+		"1:1: rule 'doc' is not used by any other rule",
+		"3:1: rule 'b' can't be reached from a",
+		"4:1: rule 'c' is not used by any other rule",
+		"5:1: rule 'd' can't be reached from a",
+	}, lintFindings(t, grammar, "a"))
+	// The first rule is the start by default.
+	assert.Equal(t, lintFindings(t, grammar, "doc"), lintFindings(t, grammar, ""))
+	assert.Equal(t, []string{"start rule 'nope' is not a defined rule"}, lintFindings(t, grammar, "nope"))
+}
+
+func TestLintEmptyLoops(t *testing.T) {
+	t.Parallel()
+	assert.ElementsMatch(t, []string{
+		"1:12: repetition * can match nothing each time, so it may loop without progressing",
+		"1:22: repetition + can match nothing each time, so it may loop without progressing",
+		"1:27: repetition :/{,?} can match nothing each time, so it may loop without progressing",
+	}, lintFindings(t, `x -> ('a'?)* | /{\d*}+ | y:/{,?} | 'a'* | y:',' | ('a'*){0,3};
+y -> 'b'*;
+`, "x"))
+
+	// Back-references may match nothing, as the parser assumes too.
+	assert.Equal(t, []string{
+		"1:16: repetition * can match nothing each time, so it may loop without progressing",
+	}, lintFindings(t, `x -> y=/{a*} %y*;`, "x"))
+}
+
+func TestLintNamesAndWrapRE(t *testing.T) {
+	t.Parallel()
+	assert.ElementsMatch(t, []string{
+		"1:6: name 'a' is redundant, since the term it names has the same name",
+		"1:17: name 'n' is redundant, since nothing matched by a negative lookaround is captured",
+		"3:3: '.wrapRE' of a scoped grammar differs from that of the enclosing grammar, " +
+			"so tokens are wrapped differently within it",
+	}, lintFindings(t, `x -> a=(a=y) (?!n=y) z=(w {
+  w -> 'w';
+  .wrapRE -> /{()};
+});
+y -> 'y';
+.wrapRE -> /{\s*()};
+`, "x"))
+
+	// The same .wrapRE as the parent scope is fine.
+	assert.Empty(t, lintFindings(t, `x -> (y {
+  .wrapRE -> /{\s*()};
+});
+y -> 'y';
+.wrapRE -> /{\s*()};
+`, "x"))
+}
+
+func TestLintOtherFindings(t *testing.T) {
+	t.Parallel()
+	node, err := ParseString("x -> 'a' | 'ab';")
+	require.NoError(t, err)
+	other := errors.New("other")
+	assert.Equal(t, []error{other}, Lint(node, "x", other))
+}
+
+func TestLintImportedWrapRE(t *testing.T) {
+	t.Parallel()
+	resolver := writeGrammars(t, map[string]string{"lib.wbnf": "v -> 'v';\n.wrapRE -> /{()};\n"})
+	// A namespaced import keeps its own .wrapRE, which isn't a mistake.
+	node, findings, err := Load(".import lib.wbnf as lib;\nx -> lib.v;\n.wrapRE -> /{\\s*()};\n", resolver)
+	require.NoError(t, err)
+	assert.Empty(t, Lint(node, "x", findings...))
+}
+
+func TestLintReportsValidationErrorsOnly(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"1:6: identifier 'nope' is not a defined rule"},
+		lintFindings(t, `x -> nope | 'a' | 'ab';`, "x"))
+}
@@ -0,0 +1,62 @@
+package wbnf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arr-ai/wbnf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var memoExamples = []string{
+	"../examples/wbnf.wbnf",
+	"../examples/sysl/sysl.wbnf",
+	"../examples/sysl/stmt.wbnf",
+	"../examples/sysl/endpoints.wbnf",
+	"../examples/sysl/views.wbnf",
+}
+
+func loadExample(t testing.TB, filename string) string {
+	text, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	return string(text)
+}
+
+func TestMemoMatchesPlainParse(t *testing.T) {
+	t.Parallel()
+	for _, filename := range memoExamples {
+		text := loadExample(t, filename)
+		expected, err := Core().Parse("grammar", parser.NewScanner(text))
+		require.NoError(t, err, filename)
+		actual, err := Core().Parse("grammar", parser.NewScanner(text), parser.WithMemo())
+		require.NoError(t, err, filename)
+		assert.Equal(t, expected.(parser.Node).String(), actual.(parser.Node).String(), filename)
+	}
+}
+
+func BenchmarkParseExamples(b *testing.B) {
+	verbatim := Core().Grammar().Compile(nil, parser.WithoutOptimization())
+	for _, filename := range memoExamples {
+		text := loadExample(b, filename)
+		name := filepath.Base(filename)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Core().MustParse("grammar", parser.NewScanner(text))
+			}
+		})
+		b.Run(name+"-verbatim", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				verbatim.MustParse("grammar", parser.NewScanner(text))
+			}
+		})
+		b.Run(name+"-memo", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Core().Parse("grammar", parser.NewScanner(text), parser.WithMemo()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package wbnf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/arr-ai/wbnf/ast"
+	"github.com/arr-ai/wbnf/parser"
+)
+
+func TestOptimizedParseMatchesVerbatim(t *testing.T) {
+	t.Parallel()
+	verbatim := Core().Grammar().Compile(nil, parser.WithoutOptimization())
+	for _, filename := range memoExamples {
+		s := parser.NewScanner(loadExample(t, filename))
+		a, b := *s, *s
+		expected, err := verbatim.Parse("grammar", &a)
+		require.NoError(t, err, filename)
+		actual, err := Core().Parse("grammar", &b)
+		require.NoError(t, err, filename)
+		assert.Equal(t, expected, actual, filename)
+		assert.Equal(t,
+			ast.FromParserNode(verbatim.Grammar(), expected),
+			ast.FromParserNode(Core().Grammar(), actual),
+			filename)
+	}
+}
+
+func TestCompileWithoutOptimization(t *testing.T) {
+	t.Parallel()
+	const grammar = `x -> ("if" | "else" | y) z:","; y -> /{\d+}; z -> "a" "b" | "a" "c";`
+	optimized, err := Compile(grammar, nil)
+	require.NoError(t, err)
+	verbatim, err := Compile(grammar, nil, parser.WithoutOptimization())
+	require.NoError(t, err)
+	assert.Equal(t, verbatim.Grammar(), optimized.Grammar())
+
+	s := parser.NewScanner("42ab,ac")
+	a, b := *s, *s
+	expected, err := verbatim.Parse("x", &a)
+	require.NoError(t, err)
+	actual, err := optimized.Parse("x", &b)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -1,11 +1,12 @@
 package wbnf
 
 import (
-	"fmt"
 	"sort"
 	"strings"
 
 	"github.com/arr-ai/frozen"
+
+	"github.com/arr-ai/wbnf/parser"
 )
 
 /*
@@ -19,19 +20,43 @@ Obvious ones:
 
 	a -> a;
 	a -> "("? a;
+
+The parser grows a seed for such left-recursive rules, so a cycle is only an
+error if some rule on it has no way to match without going around the cycle
+again. Other cycles are reported as information.
 */
-func checkForRecursion(tree GrammarNode) error {
+func prodDangerTerms(node ProdNode) frozen.Set[string] {
+	td := frozen.NewSet[string]()
+	for _, t := range node.AllTerm() {
+		td = td.Union(getSequenceDangerTerms(t))
+	}
+	return td
+}
+
+// checkForRecursion reports each cycle of rules that can invoke themselves
+// without consuming input, at the definition of the first rule on it.
+func checkForRecursion(tree GrammarNode) []validationError {
 	dangers := map[string]frozen.Set[string]{}
+	defs := map[string]parser.Scanner{}
 
 	// First get a map with every rules directly connected rules
+	var edits []ProdNode
 	WalkerOps{EnterProdNode: func(node ProdNode) Stopper {
-		td := frozen.NewSet[string]()
-		for _, t := range node.AllTerm() {
-			td = td.Union(getSequenceDangerTerms(t))
+		if prodOp(node) != defineOp {
+			edits = append(edits, node)
+			return NodeExiter
 		}
-		dangers[node.OneIdent().String()] = td
+		dangers[node.OneIdent().String()] = prodDangerTerms(node)
+		defs[node.OneIdent().String()] = node.OneIdent().Scanner()
 		return NodeExiter
 	}}.Walk(tree)
+	for _, node := range edits {
+		td := prodDangerTerms(node)
+		if prodOp(node) == extendOp {
+			td = td.Union(dangers[node.OneIdent().String()])
+		}
+		dangers[node.OneIdent().String()] = td
+	}
 
 	// walk every path
 	gn := &gnode{map[string]*gnode{}}
@@ -41,21 +66,144 @@ func checkForRecursion(tree GrammarNode) error {
 
 	// now determine the cycles
 
-	var badRoutes []string
-	paths := findPaths("", gn, frozen.NewSet[string](), nil)
-	for _, p := range paths {
+	productive := findProductiveRules(tree)
+	cycles := map[string][]string{}
+	for _, p := range findPaths("", gn, frozen.NewSet[string](), nil) {
 		if len(p) != frozen.NewSet(p...).Count() {
-			badRoutes = append(badRoutes, strings.Join(p, " > "))
+			cycle := cycleOf(p)
+			cycles[strings.Join(cycle, " > ")] = cycle
 		}
 	}
 
-	if len(badRoutes) > 0 {
-		return validationError{
-			msg:  fmt.Sprintf("Possible cycle(s) detected: \n\t%s", strings.Join(badRoutes, "\n\t")),
-			kind: PossibleCycleDetected,
+	routes := make([]string, 0, len(cycles))
+	for route := range cycles {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	var errs, infos []validationError
+	for _, route := range routes {
+		cycle := cycles[route]
+		if frozen.NewSet(cycle...).IsSubsetOf(productive) {
+			infos = append(infos, validationError{s: defs[cycle[0]],
+				msg:  "rule '%s' is left recursive, which the parser handles: %s",
+				kind: LeftRecursion, severity: SeverityInfo, args: []any{route}})
+		} else {
+			errs = append(errs, validationError{s: defs[cycle[0]],
+				msg: "rule '%s' is part of a possible cycle: %s", kind: PossibleCycleDetected, args: []any{route}})
+		}
+	}
+	return append(errs, infos...)
+}
+
+// cycleOf returns the cycle that ends a path, from and to the least rule on
+// it, so that a cycle is the same whichever rule it was entered by.
+func cycleOf(path []string) []string {
+	last := path[len(path)-1]
+	start := 0
+	for path[start] != last {
+		start++
+	}
+	loop := path[start : len(path)-1]
+	least := 0
+	for i, rule := range loop {
+		if rule < loop[least] {
+			least = i
+		}
+	}
+	cycle := append(append([]string{}, loop[least:]...), loop[:least]...)
+	return append(cycle, cycle[0])
+}
+
+// findProductiveRules returns the rules which can match some input without
+// invoking themselves again. Identifiers which aren't rules of tree, such as
+// macro args, are assumed to be productive.
+func findProductiveRules(tree GrammarNode) frozen.Set[string] {
+	// The alternatives of each rule, which an extension adds to.
+	prods := map[string][][]TermNode{}
+	var edits []ProdNode
+	WalkerOps{EnterProdNode: func(node ProdNode) Stopper {
+		if prodOp(node) != defineOp {
+			edits = append(edits, node)
+			return NodeExiter
+		}
+		prods[node.OneIdent().String()] = [][]TermNode{node.AllTerm()}
+		return NodeExiter
+	}}.Walk(tree)
+	for _, node := range edits {
+		ident := node.OneIdent().String()
+		if prodOp(node) == extendOp {
+			prods[ident] = append(prods[ident], node.AllTerm())
+		} else {
+			prods[ident] = [][]TermNode{node.AllTerm()}
 		}
 	}
-	return nil
+
+	productive := frozen.NewSet[string]()
+	isProductive := func(ident string) bool {
+		if _, has := prods[ident]; !has {
+			return true
+		}
+		return productive.Has(ident)
+	}
+	for changed := true; changed; {
+		changed = false
+		for ident, alts := range prods {
+			if productive.Has(ident) {
+				continue
+			}
+			for _, terms := range alts {
+				if allTermsProductive(terms, isProductive) {
+					productive = productive.With(ident)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return productive
+}
+
+func allTermsProductive(terms []TermNode, isProductive func(string) bool) bool {
+	for _, term := range terms {
+		if !isTermProductive(term, isProductive) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTermProductive(tree TermNode, isProductive func(string) bool) bool {
+	switch tree.OneOp() {
+	case "|", ">":
+		for _, term := range tree.AllTerm() {
+			if isTermProductive(term, isProductive) {
+				return true
+			}
+		}
+		return false
+	}
+	if childTerms := tree.AllTerm(); len(childTerms) > 0 {
+		return allTermsProductive(childTerms, isProductive)
+	}
+	for _, q := range tree.AllQuant() {
+		if q.OneOp() == "*" || q.OneOp() == "?" || (q.Choice() == 1 && (q.OneMin() == nil || q.OneMin().String() == "0")) {
+			return true // Zero repetitions always match
+		}
+	}
+	atom := tree.OneNamed().OneAtom()
+	if term := atom.OneTerm(); term != nil {
+		return isTermProductive(*term, isProductive)
+	}
+	if term := atom.OneLookahead(); term != nil {
+		return isTermProductive(*term, isProductive)
+	}
+	if term := atom.OneLookbehind(); term != nil {
+		return isTermProductive(*term, isProductive)
+	}
+	if ident := atom.OneIdent(); ident != nil {
+		return ident.String() == "@" || isProductive(ident.String())
+	}
+	return true
 }
 
 func findPaths(name string, node *gnode, seen frozen.Set[string], current []string) [][]string {
@@ -64,7 +64,9 @@ func TestCheckForRecursion(t *testing.T) {
 	for _, test := range []testData{
 		{"simple", "a -> 'a';", NoError},
 		{"simple", "a -> a;", PossibleCycleDetected},
-		{"harder", "a -> ('a'? | b); b -> c; c-> a;", PossibleCycleDetected},
+		{"harder", "a -> ('a'? | b); b -> c; c-> a;", LeftRecursion},
+		{"no base case", "a -> b 'x'; b -> a | c; c -> b;", PossibleCycleDetected},
+		{"left recursion", "a -> a '+' b | b; b -> /{\\d+};", LeftRecursion},
 	} {
 		test := test
 		t.Run("TestValidationErrors-"+test.name, func(t *testing.T) {
@@ -72,11 +74,11 @@ func TestCheckForRecursion(t *testing.T) {
 			assert.NoError(t, err)
 			assert.NotNil(t, node.Node)
 
-			err = checkForRecursion(node)
+			errs := checkForRecursion(node)
 			if test.ekind == NoError {
-				assert.NoError(t, err)
-			} else {
-				assert.Error(t, err)
+				assert.Empty(t, errs)
+			} else if assert.NotEmpty(t, errs) {
+				assert.Equal(t, test.ekind, errs[0].kind)
 			}
 		})
 	}
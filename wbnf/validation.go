@@ -4,68 +4,102 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/arr-ai/frozen"
 
 	"github.com/arr-ai/wbnf/parser"
 )
 
-func findDefinedRules(tree GrammarNode) (frozen.Set[string], map[string]PragmaMacrodefNode, error) {
-	var dupeRules []string
+// findDefinedRules returns the rules and macros defined by a grammar, reporting
+// those defined more than once.
+func findDefinedRules(tree GrammarNode) (frozen.Set[string], map[string]PragmaMacrodefNode, []validationError) {
+	var dupes []validationError
 	out := frozen.NewSet[string]()
 	macros := map[string]PragmaMacrodefNode{}
-	adder := func(ident string) {
-		if out.Has(ident) {
-			dupeRules = append(dupeRules, ident)
+	defs := map[string]parser.Scanner{}
+	adder := func(ident IdentNode) {
+		name, s := ident.String(), ident.Scanner()
+		if prev, has := defs[name]; has {
+			dupes = append(dupes, validationError{s: s,
+				msg: "rule '%s' is defined multiple times, first at %s", kind: DuplicatedRule,
+				args: []any{location(prev)}})
+			return
 		}
-		out = out.With(ident)
+		out = out.With(name)
+		defs[name] = s
 	}
 	ops := WalkerOps{
 		EnterProdNode: func(node ProdNode) Stopper {
-			adder(node.OneIdent().String())
+			// Overrides and extensions redefine a rule defined elsewhere.
+			if prodOp(node) == defineOp {
+				adder(*node.OneIdent())
+			}
 			return NodeExiter
 		},
 		EnterPragmaMacrodefNode: func(node PragmaMacrodefNode) Stopper {
-			ident := node.OneName().String()
-			adder(ident)
-			macros[ident] = node
+			adder(*node.OneName())
+			macros[node.OneName().String()] = node
 			return NodeExiter
 		},
 	}
 	ops.Walk(tree)
-	if len(dupeRules) == 0 {
-		return out, macros, nil
+	return out, macros, dupes
+}
+
+// location returns where a span starts, as file:line:col, or line:col for the
+// main grammar.
+func location(s parser.Scanner) string {
+	line, col := s.RunePosition()
+	if s.Filename() == "" {
+		return fmt.Sprintf("%d:%d", line, col)
 	}
-	return frozen.Set[string]{}, nil, validationError{
-		msg:  fmt.Sprintf("the following rule(s) are defined multiple times: %s", dupeRules),
-		kind: DuplicatedRule}
+	return fmt.Sprintf("%s:%d:%d", s.Filename(), line, col)
 }
 
-func validate(tree GrammarNode) error {
-	rules, macros, err := findDefinedRules(tree)
-	if err != nil {
-		return err
+func validate(tree GrammarNode, findings ...error) error {
+	v := check(tree, findings)
+	if len(v.err) == 0 {
+		return nil
 	}
-	v := validator{
+	return v
+}
+
+// Validate returns every problem found in a grammar, including warnings and
+// informational ones that don't stop it compiling, after the findings Load
+// returned for it. Each problem has Kind, Severity, Message and Scanner
+// methods; Scanner is the span of the offending node, in the file it came from.
+func Validate(tree GrammarNode, findings ...error) []error {
+	v := check(tree, findings)
+	return append(v.err, v.info...)
+}
+
+// check validates a grammar, collecting every finding in one pass.
+func check(tree GrammarNode, findings []error) *validator {
+	rules, macros, dupes := findDefinedRules(tree)
+	v := &validator{
 		knownRules: rules,
 		macros:     macros,
+		err:        append([]error{}, findings...),
+	}
+	for _, dupe := range dupes {
+		v.report(dupe)
 	}
 	v.walk(tree)
-
-	if cycles := checkForRecursion(tree); cycles != nil {
-		v.err = append(v.err, cycles)
+	if len(v.tokrefs) > 0 {
+		v.validateTokrefs(NewFromAst(tree.Node))
 	}
 
-	if len(v.err) == 0 {
-		return nil
+	for _, cycle := range checkForRecursion(tree) {
+		v.report(cycle)
 	}
-	return &v
+	return v
 }
 
-type validationErrorKind int
+type ValidationErrorKind int
 
 const (
-	NoError validationErrorKind = iota
+	NoError ValidationErrorKind = iota
 	UnknownRule
 	DuplicatedRule
 	InvalidRegex
@@ -75,37 +109,74 @@ const (
 	PossibleCycleDetected
 	NotAMacro
 	IncorrectMacroArgCount
+	LeftRecursion
+	NotATokenRule // the rule in rule::label isn't a choice of terminals
+	UnknownLabel
+	ShadowedAlternative // an earlier alternative always matches first
+	UnusedRule
+	UnreachableRule
+	EmptyLoop // a repetition whose body can match without consuming input
+	RedundantName
+	WrapREOverride
+)
+
+type ValidationSeverity int
+
+const (
+	SeverityError   ValidationSeverity = iota
+	SeverityWarning                    // likely a mistake, but doesn't prevent the grammar from compiling
+	SeverityInfo                       // doesn't prevent the grammar from compiling
 )
 
 type validationError struct {
-	s    parser.Scanner
-	msg  string
-	args []any
-	kind validationErrorKind
+	s        parser.Scanner
+	msg      string
+	args     []any
+	kind     ValidationErrorKind
+	severity ValidationSeverity
 }
 
+// Error returns the message, preceded by the location of the offending node.
 func (v validationError) Error() string {
-	var args []any
-	if v.s.String() != "" {
-		args = append(args, v.s.String())
-		if len(v.args) > 0 {
-			args = append(args, v.args...)
-		}
-		args = append(args, v.s.Offset())
+	if v.s.IsNil() {
+		return v.Message()
+	}
+	return location(v.s) + ": " + v.Message()
+}
 
-		return fmt.Sprintf(v.msg+"@ %d", args...)
+// Message returns the message without the location of the offending node.
+func (v validationError) Message() string {
+	args := v.args
+	if !v.s.IsNil() {
+		args = append([]any{v.s.String()}, args...)
 	}
 	return fmt.Sprintf(v.msg, args...)
 }
 
+func (v validationError) Kind() ValidationErrorKind    { return v.kind }
+func (v validationError) Severity() ValidationSeverity { return v.severity }
+func (v validationError) Scanner() parser.Scanner      { return v.s }
+
 type validator struct {
 	knownRules frozen.Set[string]
 	macros     map[string]PragmaMacrodefNode
+	tokrefs    []TokrefNode
 	err        []error
+	info       []error // warnings and information
+}
+
+func (v *validator) report(err validationError) {
+	if err.severity != SeverityError {
+		v.info = append(v.info, err)
+	} else {
+		v.err = append(v.err, err)
+	}
 }
 
 func (v *validator) walk(node IsWalkableType) {
 	ops := WalkerOps{
+		EnterProdNode:           v.validateProd,
+		EnterPragmaExportNode:   v.validateExport,
 		EnterAtomNode:           v.validateAtom,
 		EnterQuantNode:          v.validateQuant,
 		EnterNamedNode:          v.validateNamed,
@@ -117,12 +188,45 @@ func (v *validator) walk(node IsWalkableType) {
 }
 
 func (v *validator) Error() string {
-	return fmt.Sprint(v.err)
+	msgs := make([]string, 0, len(v.err))
+	for _, err := range v.err {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (v *validator) validateProd(tree ProdNode) Stopper {
+	if prodOp(tree) != defineOp && !v.knownRules.Has(tree.OneIdent().String()) {
+		v.err = append(v.err, validationError{s: tree.OneIdent().Scanner(),
+			msg: "identifier '%s' is not a defined rule, so it can't be overridden or extended", kind: UnknownRule})
+	}
+	return nil
+}
+
+func (v *validator) validateExport(tree PragmaExportNode) Stopper {
+	for _, ident := range tree.AllIdent() {
+		if !v.knownRules.Has(ident.String()) {
+			v.err = append(v.err, validationError{s: ident.Scanner(),
+				msg: "identifier '%s' is exported but is not a defined rule", kind: UnknownRule})
+		}
+	}
+	return nil
 }
 
 func (v *validator) validateTerm(tree TermNode) Stopper {
-	if len(tree.AllGrammar()) != 0 {
-		//fixme: This doesnt work for scoped grammars yet, abort!
+	if grammars := tree.AllGrammar(); len(grammars) != 0 {
+		// The rules of a scoped grammar are only known within it.
+		prevRules := v.knownRules
+		defer func() { v.knownRules = prevRules }()
+		for _, stmt := range grammars[0].AllStmt() {
+			if prod := stmt.OneProd(); prod != nil {
+				v.knownRules = v.knownRules.With(prod.OneIdent().String())
+			}
+		}
+		for _, term := range tree.AllTerm() {
+			v.walk(term)
+		}
+		v.walk(grammars[0])
 		return NodeExiter
 	}
 	if tree.OneOp() == "" {
@@ -156,10 +260,15 @@ func (v *validator) validateAtom(tree AtomNode) Stopper {
 	if ident := tree.OneIdent(); ident != nil {
 		if ident.String() != "@" {
 			if !v.knownRules.Has(ident.String()) {
-				v.err = append(v.err, validationError{s: tree.OneIdent().Scanner(),
-					msg: "identifier '%s' is not a defined rule", kind: UnknownRule})
+				v.unknownRule(*ident)
 			}
 		}
+	} else if tokref := tree.OneTokref(); tokref != nil {
+		if !v.knownRules.Has(tokref.OneIdent().String()) {
+			v.unknownRule(*tokref.OneIdent())
+		} else {
+			v.tokrefs = append(v.tokrefs, *tokref)
+		}
 	} else if x := tree.OneRe(); x != nil {
 		if _, err := regexp.Compile(x.String()); err != nil {
 			v.err = append(v.err, validationError{
@@ -173,6 +282,55 @@ func (v *validator) validateAtom(tree AtomNode) Stopper {
 	return nil
 }
 
+// unknownRule reports a reference to an undefined rule, saying so if it is
+// qualified by the namespace of an import that doesn't exist.
+func (v *validator) unknownRule(ident IdentNode) {
+	name := ident.String()
+	if i := strings.LastIndex(name, "."); i > 0 {
+		namespace := name[:i]
+		found := false
+		for r := v.knownRules.Range(); !found && r.Next(); {
+			found = strings.HasPrefix(r.Value(), namespace+".")
+		}
+		if !found {
+			v.err = append(v.err, validationError{s: ident.Scanner(),
+				msg: "identifier '%s' refers to namespace %s, which no import defines", kind: UnknownRule,
+				args: []any{namespace}})
+			return
+		}
+	}
+	v.err = append(v.err, validationError{s: ident.Scanner(),
+		msg: "identifier '%s' is not a defined rule", kind: UnknownRule})
+}
+
+// validateTokrefs checks the labels of rule::label terms against the rules
+// they refer to.
+func (v *validator) validateTokrefs(g parser.Grammar) {
+	for _, tokref := range v.tokrefs {
+		rule := tokref.OneIdent()
+		term, has := g[parser.Rule(rule.String())]
+		if !has {
+			continue
+		}
+		labels, err := parser.TokenLabels(term)
+		if err != nil {
+			v.err = append(v.err, validationError{s: rule.Scanner(),
+				msg: "'%s' can't be matched by label, %s", kind: NotATokenRule, args: []any{err}})
+			continue
+		}
+		label := tokref.OneLabel()
+		found := false
+		for _, l := range labels {
+			found = found || l == label.String()
+		}
+		if !found {
+			v.err = append(v.err, validationError{s: label.Scanner(),
+				msg: "label '%s' is not the name of an alternative of %s", kind: UnknownLabel,
+				args: []any{rule.String()}})
+		}
+	}
+}
+
 func (v *validator) validateQuant(tree QuantNode) Stopper {
 	switch tree.Choice() {
 	case 0:
@@ -195,8 +353,8 @@ func (v *validator) validateQuant(tree QuantNode) Stopper {
 		}
 		if min != 0 && max != 0 {
 			if max < min {
-				v.err = append(v.err, validationError{
-					msg: fmt.Sprintf("quant: min (%d) > max (%d)", min, max), kind: MinMaxQuantError})
+				v.err = append(v.err, validationError{s: tree.Scanner(),
+					msg: "quant %s: min (%d) > max (%d)", kind: MinMaxQuantError, args: []any{min, max}})
 			}
 		}
 	case 2:
@@ -227,9 +385,9 @@ func (v *validator) validateMacroCall(node MacrocallNode) Stopper {
 			msg: "Attempting to call %s which is not a macro", kind: NotAMacro})
 	} else {
 		if len(macro.AllArgs()) != len(node.AllTerm()) {
-			v.err = append(v.err, validationError{
-				msg: fmt.Sprintf("Macro %s expected %d args, given %d",
-					node.OneName().String(), len(macro.AllArgs()), len(node.AllTerm())), kind: IncorrectMacroArgCount})
+			v.err = append(v.err, validationError{s: node.OneName().Scanner(),
+				msg: "macro %s expected %d args, given %d", kind: IncorrectMacroArgCount,
+				args: []any{len(macro.AllArgs()), len(node.AllTerm())}})
 		}
 	}
 	return nil
@@ -3,6 +3,8 @@ package wbnf
 import (
 	"testing"
 
+	"github.com/arr-ai/wbnf/parser"
+
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
@@ -10,7 +12,7 @@ import (
 
 type testData struct {
 	name, grammar string
-	ekind         validationErrorKind
+	ekind         ValidationErrorKind
 }
 
 func TestValidationErrors(t *testing.T) {
@@ -31,6 +33,23 @@ func TestValidationErrors(t *testing.T) {
 		{"calling a rule", "a -> 'a'; x -> %!a('a');", NotAMacro},
 		{"macro arg count", "a -> %!Foo('a', 'b'); .macro Foo(b) { b };", IncorrectMacroArgCount},
 		{"macro arg count", "a -> %!Foo(); .macro Foo(b) { b };", IncorrectMacroArgCount},
+
+		{"token ref", "a -> t::x; t -> x='x' | 'y';", NoError},
+		{"token ref to undefined rule", "a -> t::x;", UnknownRule},
+		{"token ref to non-token rule", "a -> t::x; t -> x=a;", NotATokenRule},
+		{"token ref to unknown label", "a -> t::y; t -> x='x' | 'y';", UnknownLabel},
+		{"override of undefined rule", "a = 'x';", UnknownRule},
+		{"extension of undefined rule", "a -> 'x'; b |= 'y';", UnknownRule},
+		{"override", "a -> 'x'; a = 'y';", NoError},
+		{"extension", "a -> 'x'; a |= 'y';", NoError},
+		{"extension ending recursion", "a -> a 'x'; a |= 'y';", NoError},
+		{"export of undefined rule", ".export b; a -> 'x';", UnknownRule},
+		{"scoped grammar", "a -> (b { b -> 'x'; });", NoError},
+		{"undefined rule in scoped grammar", "a -> (c { b -> 'x'; });", UnknownRule},
+		{"scoped rule used outside", "a -> (b { b -> 'x'; }) b;", UnknownRule},
+
+		{"cycle", "a -> a;", PossibleCycleDetected},
+		{"left recursion", "a -> a 'x' | 'y';", NoError},
 		// Wish-list validity checks:
 
 		// Should fail because op would return different types
@@ -61,3 +80,57 @@ func TestValidationErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestCompileLeftRecursion(t *testing.T) {
+	p, err := Compile(`
+		expr -> expr op="-" term | term;
+		term -> term op="/" INT | INT;
+		INT  -> \d+;
+	`, nil)
+	require.NoError(t, err)
+
+	v, err := p.Parse("expr", parser.NewScanner("8-4/2-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", v.(parser.Node).GetString(0, 2, 0))
+	assert.Equal(t, "8", v.(parser.Node).GetString(0, 0, 0, 0, 0, 0))
+}
+
+func TestValidateFindings(t *testing.T) {
+	node, err := ParseString(`a -> b;
+a -> 'x'{3,2};
+c -> %!m('x', 'y');
+.macro m(p) { p }
+d -> d;
+e -> e 'x' | 'y';
+f -> t::z;
+t -> x='x' | 'y';
+`)
+	require.NoError(t, err)
+
+	// Every finding is reported at its node, in one pass.
+	type finding struct {
+		kind     ValidationErrorKind
+		severity ValidationSeverity
+		at       string
+		msg      string
+	}
+	var findings []finding
+	for _, err := range Validate(node) {
+		v := err.(validationError)
+		findings = append(findings, finding{v.Kind(), v.Severity(), location(v.Scanner()), v.Message()})
+	}
+	assert.ElementsMatch(t, []finding{
+		{DuplicatedRule, SeverityError, "2:1", "rule 'a' is defined multiple times, first at 1:1"},
+		{UnknownRule, SeverityError, "1:6", "identifier 'b' is not a defined rule"},
+		{MinMaxQuantError, SeverityError, "2:9", "quant {3,2}: min (3) > max (2)"},
+		{IncorrectMacroArgCount, SeverityError, "3:8", "macro m expected 1 args, given 2"},
+		{PossibleCycleDetected, SeverityError, "5:1", "rule 'd' is part of a possible cycle: d > d"},
+		{LeftRecursion, SeverityInfo, "6:1", "rule 'e' is left recursive, which the parser handles: e > e"},
+		{UnknownLabel, SeverityError, "7:9", "label 'z' is not the name of an alternative of t"},
+	}, findings)
+
+	err = validate(node)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2:1: rule 'a' is defined multiple times, first at 1:1\n")
+	assert.Contains(t, err.Error(), "3:8: macro m expected 1 args, given 2")
+}
@@ -10,34 +10,48 @@ import (
 func Grammar() parser.Parsers {
 	return parser.Grammar{".wrapRE": parser.RE(`\s*()\s*`),
 		"COMMENT": parser.RE(`//.*$|(?s:/\*(?:[^*]|\*+[^*/])\*/)`),
-		"IDENT":   parser.RE(`@|\.?[A-Za-z_]\w*`),
+		"IDENT":   parser.RE(`@\B|\.?[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*`),
 		"INT":     parser.RE(`\d+`),
 		"RE":      parser.RE(`/{(?:\\.|{(?:(?:\d+(?:,\d*)?|,\d+)\})?|\[(?:\\.|\[:^?[a-z]+:\]|[^\]])+]|[^\\{\}])*\}|(?:(?:\[(?:\\.|\[:^?[a-z]+:\]|[^\]])+]|\\[pP](?:[a-z]|\{[a-zA-Z_]+\})|\\[a-zA-Z]|[.^$])(?:(?:[+*?]|\{\d+,?\d?\})\??)?)+`),
-		"REF": parser.Seq{parser.CutPoint{parser.S(`%`)},
+		"REF": parser.Seq{parser.S(`%`),
 			parser.Rule(`IDENT`),
 			parser.Opt(parser.Seq{parser.S(`=`),
 				parser.Eq(`default`,
 					parser.Rule(`STR`))})},
-		"STR": parser.RE(`"(?:\\.|[^\\"])*"|'(?:\\.|[^\\'])*'|` + "`" + `(?:` + "`" + `` + "`" + `|[^` + "`" + `])*` + "`" + ``),
-		"atom": parser.Oneof{parser.Rule(`IDENT`),
-			parser.Rule(`STR`),
+		"STR": parser.RE(`i?(?:"(?:\\.|[^\\"])*"|'(?:\\.|[^\\'])*'|` + "`" + `(?:` + "`" + `` + "`" + `|[^` + "`" + `])*` + "`" + `)`),
+		"atom": parser.Oneof{parser.Rule(`STR`),
+			parser.Rule(`tokref`),
+			parser.Rule(`IDENT`),
 			parser.Rule(`RE`),
 			parser.Rule(`macrocall`),
 			parser.Eq(`ExtRef`,
-				parser.Seq{parser.CutPoint{parser.S(`%%`)},
+				parser.Seq{parser.S(`%%`),
 					parser.Rule(`IDENT`)}),
 			parser.Rule(`REF`),
-			parser.Seq{parser.CutPoint{parser.S(`(?=`)},
+			parser.Seq{parser.S(`(?=`),
 				parser.Eq(`lookahead`,
 					parser.Rule(`term`)),
 				parser.S(`)`)},
+			parser.Seq{parser.S(`(?!`),
+				parser.Eq(`notlookahead`,
+					parser.Rule(`term`)),
+				parser.S(`)`)},
+			parser.Seq{parser.S(`(?<=`),
+				parser.Eq(`lookbehind`,
+					parser.Rule(`term`)),
+				parser.S(`)`)},
+			parser.Seq{parser.S(`(?<!`),
+				parser.Eq(`notlookbehind`,
+					parser.Rule(`term`)),
+				parser.S(`)`)},
 			parser.Seq{parser.S(`(`),
 				parser.Rule(`term`),
 				parser.S(`)`)},
 			parser.Seq{parser.S(`(`),
-				parser.S(`)`)}},
+				parser.S(`)`)},
+			parser.Rule(`pos`)},
 		"grammar": parser.Some(parser.Rule(`stmt`)),
-		"macrocall": parser.Seq{parser.CutPoint{parser.S(`%!`)},
+		"macrocall": parser.Seq{parser.S(`%!`),
 			parser.Eq(`name`,
 				parser.Rule(`IDENT`)),
 			parser.S(`(`),
@@ -48,18 +62,52 @@ func Grammar() parser.Parsers {
 			parser.Eq(`op`,
 				parser.S(`=`))}),
 			parser.Rule(`atom`)},
+		"pos": parser.Seq{parser.Eq(`prop`,
+			parser.RE(`@(?:col|line|offset)\b`)),
+			parser.Opt(parser.Seq{parser.S(`(`),
+				parser.Eq(`op`,
+					parser.RE(`[!<>]=|[=<>]`)),
+				parser.Oneof{parser.Rule(`IDENT`),
+					parser.Rule(`INT`)},
+				parser.S(`)`)})},
 		"pragma": parser.ScopedGrammar{Term: parser.Oneof{parser.Rule(`import`),
+			parser.Rule(`export`),
 			parser.Rule(`macrodef`)},
 			Grammar: parser.Grammar{".wrapRE": parser.RE(`\s*()\s*`),
-				"import": parser.Seq{parser.CutPoint{parser.S(`.import`)},
+				"export": parser.Seq{parser.S(`.export`),
+					parser.Delim{Term: parser.Rule(`IDENT`),
+						Sep: parser.S(`,`)},
+					parser.Opt(parser.S(`;`))},
+				"import": parser.Seq{parser.S(`.import`),
 					parser.Eq(`path`,
-						parser.Delim{Term: parser.Oneof{parser.CutPoint{parser.S(`..`)},
-							parser.CutPoint{parser.S(`.`)},
+						parser.Delim{Term: parser.Oneof{parser.S(`..`),
+							parser.S(`.`),
 							parser.RE(`[a-zA-Z0-9.:]+`)},
 							Sep:             parser.S(`/`),
 							CanStartWithSep: true}),
-					parser.Opt(parser.CutPoint{parser.S(`;`)})},
-				"macrodef": parser.Seq{parser.CutPoint{parser.S(`.macro`)},
+					parser.Opt(parser.Seq{parser.S(`as`),
+						parser.Eq(`namespace`,
+							parser.Rule(`IDENT`))}),
+					parser.Opt(parser.Seq{parser.S(`(`),
+						parser.Delim{Term: parser.Eq(`rules`,
+							parser.Rule(`IDENT`)),
+							Sep: parser.S(`,`)},
+						parser.S(`)`)}),
+					parser.Opt(parser.Seq{parser.S(`prefix`),
+						parser.Eq(`prefix`,
+							parser.Rule(`IDENT`))}),
+					parser.Opt(parser.Seq{parser.S(`rename`),
+						parser.S(`(`),
+						parser.Delim{Term: parser.Eq(`rename`,
+							parser.Seq{parser.Eq(`from`,
+								parser.Rule(`IDENT`)),
+								parser.S(`->`),
+								parser.Eq(`to`,
+									parser.Rule(`IDENT`))}),
+							Sep: parser.S(`,`)},
+						parser.S(`)`)}),
+					parser.Opt(parser.S(`;`))},
+				"macrodef": parser.Seq{parser.S(`.macro`),
 					parser.Eq(`name`,
 						parser.Rule(`IDENT`)),
 					parser.S(`(`),
@@ -70,11 +118,14 @@ func Grammar() parser.Parsers {
 					parser.S(`{`),
 					parser.Rule(`term`),
 					parser.S(`}`),
-					parser.Opt(parser.CutPoint{parser.S(`;`)})}}},
+					parser.Opt(parser.S(`;`))}}},
 		"prod": parser.Seq{parser.Rule(`IDENT`),
-			parser.CutPoint{parser.S(`->`)},
+			parser.Eq(`op`,
+				parser.Oneof{parser.S(`->`),
+					parser.S(`|=`),
+					parser.S(`=`)}),
 			parser.Some(parser.Rule(`term`)),
-			parser.CutPoint{parser.S(`;`)}},
+			parser.S(`;`)},
 		"quant": parser.Oneof{parser.Eq(`op`,
 			parser.RE(`[?*+]`)),
 			parser.Seq{parser.S(`{`),
@@ -105,7 +156,11 @@ func Grammar() parser.Parsers {
 					parser.S(`|`))},
 			parser.Some(parser.At),
 			parser.Seq{parser.Rule(`named`),
-				parser.Any(parser.Rule(`quant`))}}}.Compile(nil)
+				parser.Any(parser.Rule(`quant`))}},
+		"tokref": parser.Seq{parser.Rule(`IDENT`),
+			parser.S(`::`),
+			parser.Eq(`label`,
+				parser.Rule(`IDENT`))}}.Compile(nil)
 }
 
 type Stopper interface {
@@ -180,6 +235,13 @@ func (c AtomNode) OneLookahead() *TermNode {
 	return nil
 }
 
+func (c AtomNode) OneLookbehind() *TermNode {
+	if child := ast.First(c.Node, "lookbehind"); child != nil {
+		return &TermNode{child}
+	}
+	return nil
+}
+
 func (c AtomNode) OneMacrocall() *MacrocallNode {
 	if child := ast.First(c.Node, "macrocall"); child != nil {
 		return &MacrocallNode{child}
@@ -187,6 +249,27 @@ func (c AtomNode) OneMacrocall() *MacrocallNode {
 	return nil
 }
 
+func (c AtomNode) OneNotlookahead() *TermNode {
+	if child := ast.First(c.Node, "notlookahead"); child != nil {
+		return &TermNode{child}
+	}
+	return nil
+}
+
+func (c AtomNode) OneNotlookbehind() *TermNode {
+	if child := ast.First(c.Node, "notlookbehind"); child != nil {
+		return &TermNode{child}
+	}
+	return nil
+}
+
+func (c AtomNode) OnePos() *PosNode {
+	if child := ast.First(c.Node, "pos"); child != nil {
+		return &PosNode{child}
+	}
+	return nil
+}
+
 func (c AtomNode) OneRe() *ReNode {
 	if child := ast.First(c.Node, "RE"); child != nil {
 		return &ReNode{child}
@@ -237,6 +320,13 @@ func (c AtomNode) AllToken() []string {
 	return out
 }
 
+func (c AtomNode) OneTokref() *TokrefNode {
+	if child := ast.First(c.Node, "tokref"); child != nil {
+		return &TokrefNode{child}
+	}
+	return nil
+}
+
 type CommentNode struct{ ast.Node }
 
 func (CommentNode) isWalkableType() {}
@@ -344,10 +434,97 @@ func (c NamedNode) OneOp() string {
 	return ""
 }
 
+type PosNode struct{ ast.Node }
+
+func (PosNode) isWalkableType() {}
+func (c PosNode) Choice() int   { return ast.Choice(c.Node) }
+
+func (c PosNode) OneIdent() *IdentNode {
+	if child := ast.First(c.Node, "IDENT"); child != nil {
+		return &IdentNode{child}
+	}
+	return nil
+}
+
+func (c PosNode) OneInt() *IntNode {
+	if child := ast.First(c.Node, "INT"); child != nil {
+		return &IntNode{child}
+	}
+	return nil
+}
+
+func (c PosNode) OneOp() string {
+	if child := ast.First(c.Node, "op"); child != nil {
+		return ast.First(child, "").Scanner().String()
+	}
+	return ""
+}
+
+func (c PosNode) OneProp() string {
+	if child := ast.First(c.Node, "prop"); child != nil {
+		return ast.First(child, "").Scanner().String()
+	}
+	return ""
+}
+
+func (c PosNode) OneToken() string {
+	if child := ast.First(c.Node, ""); child != nil {
+		return child.Scanner().String()
+	}
+	if b, ok := c.Node.(ast.Branch); ok && len(b) == 1 {
+		for _, c := range b {
+			if child := ast.First(c.(ast.One).Node, ""); child != nil {
+				return child.Scanner().String()
+			}
+		}
+	}
+	return ""
+}
+
+func (c PosNode) AllToken() []string {
+	var out []string
+	for _, child := range ast.All(c.Node, "") {
+		out = append(out, child.Scanner().String())
+	}
+	return out
+}
+
+type PragmaExportNode struct{ ast.Node }
+
+func (PragmaExportNode) isWalkableType() {}
+func (c PragmaExportNode) AllIdent() []IdentNode {
+	var out []IdentNode
+	for _, child := range ast.All(c.Node, "IDENT") {
+		out = append(out, IdentNode{child})
+	}
+	return out
+}
+
+func (c PragmaExportNode) OneToken() string {
+	if child := ast.First(c.Node, ""); child != nil {
+		return child.Scanner().String()
+	}
+	if b, ok := c.Node.(ast.Branch); ok && len(b) == 1 {
+		for _, c := range b {
+			if child := ast.First(c.(ast.One).Node, ""); child != nil {
+				return child.Scanner().String()
+			}
+		}
+	}
+	return ""
+}
+
 type PragmaImportNode struct{ ast.Node }
 
 func (PragmaImportNode) isWalkableType() {}
 
+func (c PragmaImportNode) OneNamespace() *IdentNode {
+	if child := ast.First(c.Node, "namespace"); child != nil {
+		return &IdentNode{child}
+	}
+	return nil
+}
+
 func (c PragmaImportNode) OnePath() *PragmaImportPathNode {
 	if child := ast.First(c.Node, "path"); child != nil {
 		return &PragmaImportPathNode{child}
@@ -355,6 +532,29 @@ func (c PragmaImportNode) OnePath() *PragmaImportPathNode {
 	return nil
 }
 
+func (c PragmaImportNode) OnePrefix() *IdentNode {
+	if child := ast.First(c.Node, "prefix"); child != nil {
+		return &IdentNode{child}
+	}
+	return nil
+}
+
+func (c PragmaImportNode) AllRename() []PragmaImportRenameNode {
+	var out []PragmaImportRenameNode
+	for _, child := range ast.All(c.Node, "rename") {
+		out = append(out, PragmaImportRenameNode{child})
+	}
+	return out
+}
+
+func (c PragmaImportNode) AllRules() []IdentNode {
+	var out []IdentNode
+	for _, child := range ast.All(c.Node, "rules") {
+		out = append(out, IdentNode{child})
+	}
+	return out
+}
+
 func (c PragmaImportNode) OneToken() string {
 	if child := ast.First(c.Node, ""); child != nil {
 		return child.Scanner().String()
@@ -369,6 +569,14 @@ func (c PragmaImportNode) OneToken() string {
 	return ""
 }
 
+func (c PragmaImportNode) AllToken() []string {
+	var out []string
+	for _, child := range ast.All(c.Node, "") {
+		out = append(out, child.Scanner().String())
+	}
+	return out
+}
+
 type PragmaImportPathNode struct{ ast.Node }
 
 func (PragmaImportPathNode) isWalkableType() {}
@@ -382,6 +590,33 @@ func (c PragmaImportPathNode) AllToken() []string {
 	return out
 }
 
+type PragmaImportRenameNode struct{ ast.Node }
+
+func (PragmaImportRenameNode) isWalkableType() {}
+func (c PragmaImportRenameNode) AllFrom() []IdentNode {
+	var out []IdentNode
+	for _, child := range ast.All(c.Node, "from") {
+		out = append(out, IdentNode{child})
+	}
+	return out
+}
+
+func (c PragmaImportRenameNode) AllTo() []IdentNode {
+	var out []IdentNode
+	for _, child := range ast.All(c.Node, "to") {
+		out = append(out, IdentNode{child})
+	}
+	return out
+}
+
+func (c PragmaImportRenameNode) AllToken() []string {
+	var out []string
+	for _, child := range ast.All(c.Node, "") {
+		out = append(out, child.Scanner().String())
+	}
+	return out
+}
+
 type PragmaMacrodefNode struct{ ast.Node }
 
 func (PragmaMacrodefNode) isWalkableType() {}
@@ -434,6 +669,13 @@ type PragmaNode struct{ ast.Node }
 func (PragmaNode) isWalkableType() {}
 func (c PragmaNode) Choice() int   { return ast.Choice(c.Node) }
 
+func (c PragmaNode) OneExport() *PragmaExportNode {
+	if child := ast.First(c.Node, "export"); child != nil {
+		return &PragmaExportNode{child}
+	}
+	return nil
+}
+
 func (c PragmaNode) OneImport() *PragmaImportNode {
 	if child := ast.First(c.Node, "import"); child != nil {
 		return &PragmaImportNode{child}
@@ -459,6 +701,13 @@ func (c ProdNode) OneIdent() *IdentNode {
 	return nil
 }
 
+func (c ProdNode) OneOp() *ProdOpNode {
+	if child := ast.First(c.Node, "op"); child != nil {
+		return &ProdOpNode{child}
+	}
+	return nil
+}
+
 func (c ProdNode) AllTerm() []TermNode {
 	var out []TermNode
 	for _, child := range ast.All(c.Node, "term") {
@@ -481,12 +730,23 @@ func (c ProdNode) OneToken() string {
 	return ""
 }
 
-func (c ProdNode) AllToken() []string {
-	var out []string
-	for _, child := range ast.All(c.Node, "") {
-		out = append(out, child.Scanner().String())
+type ProdOpNode struct{ ast.Node }
+
+func (ProdOpNode) isWalkableType() {}
+func (c ProdOpNode) Choice() int   { return ast.Choice(c.Node) }
+
+func (c ProdOpNode) OneToken() string {
+	if child := ast.First(c.Node, ""); child != nil {
+		return child.Scanner().String()
 	}
-	return out
+	if b, ok := c.Node.(ast.Branch); ok && len(b) == 1 {
+		for _, c := range b {
+			if child := ast.First(c.(ast.One).Node, ""); child != nil {
+				return child.Scanner().String()
+			}
+		}
+	}
+	return ""
 }
 
 type QuantNode struct{ ast.Node }
@@ -685,6 +945,38 @@ func (c TermNode) AllToken() []string {
 	return out
 }
 
+type TokrefNode struct{ ast.Node }
+
+func (TokrefNode) isWalkableType() {}
+
+func (c TokrefNode) OneIdent() *IdentNode {
+	if child := ast.First(c.Node, "IDENT"); child != nil {
+		return &IdentNode{child}
+	}
+	return nil
+}
+
+func (c TokrefNode) OneLabel() *IdentNode {
+	if child := ast.First(c.Node, "label"); child != nil {
+		return &IdentNode{child}
+	}
+	return nil
+}
+
+func (c TokrefNode) OneToken() string {
+	if child := ast.First(c.Node, ""); child != nil {
+		return child.Scanner().String()
+	}
+	if b, ok := c.Node.(ast.Branch); ok && len(b) == 1 {
+		for _, c := range b {
+			if child := ast.First(c.(ast.One).Node, ""); child != nil {
+				return child.Scanner().String()
+			}
+		}
+	}
+	return ""
+}
+
 type WrapReNode struct{ ast.Node }
 
 func (WrapReNode) isWalkableType() {}
@@ -696,46 +988,56 @@ func (c *WrapReNode) String() string {
 }
 
 type WalkerOps struct {
-	EnterAtomExtRefNode       func(AtomExtRefNode) Stopper
-	ExitAtomExtRefNode        func(AtomExtRefNode) Stopper
-	EnterAtomNode             func(AtomNode) Stopper
-	ExitAtomNode              func(AtomNode) Stopper
-	EnterCommentNode          func(CommentNode) Stopper
-	ExitCommentNode           func(CommentNode) Stopper
-	EnterGrammarNode          func(GrammarNode) Stopper
-	ExitGrammarNode           func(GrammarNode) Stopper
-	EnterIdentNode            func(IdentNode) Stopper
-	ExitIdentNode             func(IdentNode) Stopper
-	EnterIntNode              func(IntNode) Stopper
-	ExitIntNode               func(IntNode) Stopper
-	EnterMacrocallNode        func(MacrocallNode) Stopper
-	ExitMacrocallNode         func(MacrocallNode) Stopper
-	EnterNamedNode            func(NamedNode) Stopper
-	ExitNamedNode             func(NamedNode) Stopper
-	EnterPragmaImportNode     func(PragmaImportNode) Stopper
-	ExitPragmaImportNode      func(PragmaImportNode) Stopper
-	EnterPragmaImportPathNode func(PragmaImportPathNode) Stopper
-	ExitPragmaImportPathNode  func(PragmaImportPathNode) Stopper
-	EnterPragmaMacrodefNode   func(PragmaMacrodefNode) Stopper
-	ExitPragmaMacrodefNode    func(PragmaMacrodefNode) Stopper
-	EnterPragmaNode           func(PragmaNode) Stopper
-	ExitPragmaNode            func(PragmaNode) Stopper
-	EnterProdNode             func(ProdNode) Stopper
-	ExitProdNode              func(ProdNode) Stopper
-	EnterQuantNode            func(QuantNode) Stopper
-	ExitQuantNode             func(QuantNode) Stopper
-	EnterReNode               func(ReNode) Stopper
-	ExitReNode                func(ReNode) Stopper
-	EnterRefNode              func(RefNode) Stopper
-	ExitRefNode               func(RefNode) Stopper
-	EnterStmtNode             func(StmtNode) Stopper
-	ExitStmtNode              func(StmtNode) Stopper
-	EnterStrNode              func(StrNode) Stopper
-	ExitStrNode               func(StrNode) Stopper
-	EnterTermNode             func(TermNode) Stopper
-	ExitTermNode              func(TermNode) Stopper
-	EnterWrapReNode           func(WrapReNode) Stopper
-	ExitWrapReNode            func(WrapReNode) Stopper
+	EnterAtomExtRefNode         func(AtomExtRefNode) Stopper
+	ExitAtomExtRefNode          func(AtomExtRefNode) Stopper
+	EnterAtomNode               func(AtomNode) Stopper
+	ExitAtomNode                func(AtomNode) Stopper
+	EnterCommentNode            func(CommentNode) Stopper
+	ExitCommentNode             func(CommentNode) Stopper
+	EnterGrammarNode            func(GrammarNode) Stopper
+	ExitGrammarNode             func(GrammarNode) Stopper
+	EnterIdentNode              func(IdentNode) Stopper
+	ExitIdentNode               func(IdentNode) Stopper
+	EnterIntNode                func(IntNode) Stopper
+	ExitIntNode                 func(IntNode) Stopper
+	EnterMacrocallNode          func(MacrocallNode) Stopper
+	ExitMacrocallNode           func(MacrocallNode) Stopper
+	EnterNamedNode              func(NamedNode) Stopper
+	ExitNamedNode               func(NamedNode) Stopper
+	EnterPosNode                func(PosNode) Stopper
+	ExitPosNode                 func(PosNode) Stopper
+	EnterPragmaExportNode       func(PragmaExportNode) Stopper
+	ExitPragmaExportNode        func(PragmaExportNode) Stopper
+	EnterPragmaImportNode       func(PragmaImportNode) Stopper
+	ExitPragmaImportNode        func(PragmaImportNode) Stopper
+	EnterPragmaImportPathNode   func(PragmaImportPathNode) Stopper
+	ExitPragmaImportPathNode    func(PragmaImportPathNode) Stopper
+	EnterPragmaImportRenameNode func(PragmaImportRenameNode) Stopper
+	ExitPragmaImportRenameNode  func(PragmaImportRenameNode) Stopper
+	EnterPragmaMacrodefNode     func(PragmaMacrodefNode) Stopper
+	ExitPragmaMacrodefNode      func(PragmaMacrodefNode) Stopper
+	EnterPragmaNode             func(PragmaNode) Stopper
+	ExitPragmaNode              func(PragmaNode) Stopper
+	EnterProdNode               func(ProdNode) Stopper
+	ExitProdNode                func(ProdNode) Stopper
+	EnterProdOpNode             func(ProdOpNode) Stopper
+	ExitProdOpNode              func(ProdOpNode) Stopper
+	EnterQuantNode              func(QuantNode) Stopper
+	ExitQuantNode               func(QuantNode) Stopper
+	EnterReNode                 func(ReNode) Stopper
+	ExitReNode                  func(ReNode) Stopper
+	EnterRefNode                func(RefNode) Stopper
+	ExitRefNode                 func(RefNode) Stopper
+	EnterStmtNode               func(StmtNode) Stopper
+	ExitStmtNode                func(StmtNode) Stopper
+	EnterStrNode                func(StrNode) Stopper
+	ExitStrNode                 func(StrNode) Stopper
+	EnterTermNode               func(TermNode) Stopper
+	ExitTermNode                func(TermNode) Stopper
+	EnterTokrefNode             func(TokrefNode) Stopper
+	ExitTokrefNode              func(TokrefNode) Stopper
+	EnterWrapReNode             func(WrapReNode) Stopper
+	ExitWrapReNode              func(WrapReNode) Stopper
 }
 
 func (w WalkerOps) Walk(tree IsWalkableType) Stopper {
@@ -770,12 +1072,21 @@ func (w WalkerOps) Walk(tree IsWalkableType) Stopper {
 	case NamedNode:
 		return w.WalkNamedNode(node)
 
+	case PosNode:
+		return w.WalkPosNode(node)
+
+	case PragmaExportNode:
+		return w.WalkPragmaExportNode(node)
+
 	case PragmaImportNode:
 		return w.WalkPragmaImportNode(node)
 
 	case PragmaImportPathNode:
 		return w.WalkPragmaImportPathNode(node)
 
+	case PragmaImportRenameNode:
+		return w.WalkPragmaImportRenameNode(node)
+
 	case PragmaMacrodefNode:
 		return w.WalkPragmaMacrodefNode(node)
 
@@ -785,6 +1096,9 @@ func (w WalkerOps) Walk(tree IsWalkableType) Stopper {
 	case ProdNode:
 		return w.WalkProdNode(node)
 
+	case ProdOpNode:
+		return w.WalkProdOpNode(node)
+
 	case QuantNode:
 		return w.WalkQuantNode(node)
 
@@ -807,6 +1121,9 @@ func (w WalkerOps) Walk(tree IsWalkableType) Stopper {
 	case TermNode:
 		return w.WalkTermNode(node)
 
+	case TokrefNode:
+		return w.WalkTokrefNode(node)
+
 	case WrapReNode:
 		if fn := w.EnterWrapReNode; fn != nil {
 			return fn(node)
@@ -888,6 +1205,16 @@ func (w WalkerOps) WalkAtomNode(node AtomNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneLookbehind(); child != nil {
+		child := *child
+		if s := w.WalkTermNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
 	if child := node.OneMacrocall(); child != nil {
 		child := *child
 		if s := w.WalkMacrocallNode(child); s != nil {
@@ -898,6 +1225,36 @@ func (w WalkerOps) WalkAtomNode(node AtomNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneNotlookahead(); child != nil {
+		child := *child
+		if s := w.WalkTermNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	if child := node.OneNotlookbehind(); child != nil {
+		child := *child
+		if s := w.WalkTermNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	if child := node.OnePos(); child != nil {
+		child := *child
+		if s := w.WalkPosNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
 	if child := node.OneRe(); child != nil {
 		child := *child
 		if fn := w.EnterReNode; fn != nil {
@@ -942,6 +1299,16 @@ func (w WalkerOps) WalkAtomNode(node AtomNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneTokref(); child != nil {
+		child := *child
+		if s := w.WalkTokrefNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
 
 	if fn := w.ExitAtomNode; fn != nil {
 		if s := fn(node); s != nil && s.Abort() {
@@ -1060,6 +1427,79 @@ func (w WalkerOps) WalkNamedNode(node NamedNode) Stopper {
 	return nil
 }
 
+func (w WalkerOps) WalkPosNode(node PosNode) Stopper {
+	if fn := w.EnterPosNode; fn != nil {
+		if s := fn(node); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	if child := node.OneIdent(); child != nil {
+		child := *child
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+	if child := node.OneInt(); child != nil {
+		child := *child
+		if fn := w.EnterIntNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+
+	if fn := w.ExitPosNode; fn != nil {
+		if s := fn(node); s != nil && s.Abort() {
+			return s
+		}
+	}
+	return nil
+}
+
+func (w WalkerOps) WalkPragmaExportNode(node PragmaExportNode) Stopper {
+	if fn := w.EnterPragmaExportNode; fn != nil {
+		if s := fn(node); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	for _, child := range node.AllIdent() {
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+
+	if fn := w.ExitPragmaExportNode; fn != nil {
+		if s := fn(node); s != nil && s.Abort() {
+			return s
+		}
+	}
+	return nil
+}
+
 func (w WalkerOps) WalkPragmaImportNode(node PragmaImportNode) Stopper {
 	if fn := w.EnterPragmaImportNode; fn != nil {
 		if s := fn(node); s != nil {
@@ -1070,6 +1510,18 @@ func (w WalkerOps) WalkPragmaImportNode(node PragmaImportNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneNamespace(); child != nil {
+		child := *child
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
 	if child := node.OnePath(); child != nil {
 		child := *child
 		if s := w.WalkPragmaImportPathNode(child); s != nil {
@@ -1080,6 +1532,38 @@ func (w WalkerOps) WalkPragmaImportNode(node PragmaImportNode) Stopper {
 			}
 		}
 	}
+	if child := node.OnePrefix(); child != nil {
+		child := *child
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+	for _, child := range node.AllRename() {
+		if s := w.WalkPragmaImportRenameNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	for _, child := range node.AllRules() {
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
 
 	if fn := w.ExitPragmaImportNode; fn != nil {
 		if s := fn(node); s != nil && s.Abort() {
@@ -1108,6 +1592,47 @@ func (w WalkerOps) WalkPragmaImportPathNode(node PragmaImportPathNode) Stopper {
 	return nil
 }
 
+func (w WalkerOps) WalkPragmaImportRenameNode(node PragmaImportRenameNode) Stopper {
+	if fn := w.EnterPragmaImportRenameNode; fn != nil {
+		if s := fn(node); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	for _, child := range node.AllFrom() {
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+	for _, child := range node.AllTo() {
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+
+	if fn := w.ExitPragmaImportRenameNode; fn != nil {
+		if s := fn(node); s != nil && s.Abort() {
+			return s
+		}
+	}
+	return nil
+}
+
 func (w WalkerOps) WalkPragmaMacrodefNode(node PragmaMacrodefNode) Stopper {
 	if fn := w.EnterPragmaMacrodefNode; fn != nil {
 		if s := fn(node); s != nil {
@@ -1170,6 +1695,16 @@ func (w WalkerOps) WalkPragmaNode(node PragmaNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneExport(); child != nil {
+		child := *child
+		if s := w.WalkPragmaExportNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
 	if child := node.OneImport(); child != nil {
 		child := *child
 		if s := w.WalkPragmaImportNode(child); s != nil {
@@ -1221,6 +1756,16 @@ func (w WalkerOps) WalkProdNode(node ProdNode) Stopper {
 			}
 		}
 	}
+	if child := node.OneOp(); child != nil {
+		child := *child
+		if s := w.WalkProdOpNode(child); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
 	for _, child := range node.AllTerm() {
 		if s := w.WalkTermNode(child); s != nil {
 			if s.ExitNode() {
@@ -1239,6 +1784,25 @@ func (w WalkerOps) WalkProdNode(node ProdNode) Stopper {
 	return nil
 }
 
+func (w WalkerOps) WalkProdOpNode(node ProdOpNode) Stopper {
+	if fn := w.EnterProdOpNode; fn != nil {
+		if s := fn(node); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+
+	if fn := w.ExitProdOpNode; fn != nil {
+		if s := fn(node); s != nil && s.Abort() {
+			return s
+		}
+	}
+	return nil
+}
+
 func (w WalkerOps) WalkQuantNode(node QuantNode) Stopper {
 	if fn := w.EnterQuantNode; fn != nil {
 		if s := fn(node); s != nil {
@@ -1442,6 +2006,49 @@ func (w WalkerOps) WalkTermNode(node TermNode) Stopper {
 	return nil
 }
 
+func (w WalkerOps) WalkTokrefNode(node TokrefNode) Stopper {
+	if fn := w.EnterTokrefNode; fn != nil {
+		if s := fn(node); s != nil {
+			if s.ExitNode() {
+				return nil
+			} else if s.Abort() {
+				return s
+			}
+		}
+	}
+	if child := node.OneIdent(); child != nil {
+		child := *child
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+	if child := node.OneLabel(); child != nil {
+		child := *child
+		if fn := w.EnterIdentNode; fn != nil {
+			if s := fn(child); s != nil {
+				if s.ExitNode() {
+					return nil
+				} else if s.Abort() {
+					return s
+				}
+			}
+		}
+	}
+
+	if fn := w.ExitTokrefNode; fn != nil {
+		if s := fn(node); s != nil && s.Abort() {
+			return s
+		}
+	}
+	return nil
+}
+
 func (c GrammarNode) GetAstNode() ast.Node { return c.Node }
 
 func NewGrammarNode(from ast.Node) GrammarNode { return GrammarNode{from} }
@@ -1463,7 +2070,7 @@ var grammarGrammarSrc = unfakeBackquote(`
 // Non-terminals
 grammar -> stmt+;
 stmt    -> COMMENT | prod | pragma;
-prod    -> IDENT "->" term+ ";";
+prod    -> IDENT op=("->" | "|=" | "=") term+ ";";
 term    -> (@ ("{" grammar "}")? ):op=">"
          > @:op="|"
          > @+
@@ -1472,28 +2079,37 @@ named   -> (IDENT op="=")? atom;
 quant   -> op=[?*+]
          | "{" min=INT? "," max=INT? "}"
          | op=/{<:|:>?} opt_leading=","? named opt_trailing=","?;
-atom    -> IDENT
-         | STR
+atom    -> STR
+         | tokref
+         | IDENT
          | RE
          | macrocall
          | ExtRef=("%%" IDENT)
          | REF
          | "(?=" lookahead=term ")"
+         | "(?!" notlookahead=term ")"
+         | "(?<=" lookbehind=term ")"
+         | "(?<!" notlookbehind=term ")"
          | "(" term ")"
-         | "(" ")";
+         | "(" ")"
+         | pos;
 
 macrocall   -> "%!" name=IDENT "(" term:","? ")";
 REF         -> "%" IDENT ("=" default=STR)?;
+tokref      -> IDENT "::" label=IDENT;
+pos         -> prop=/{@(?:col|line|offset)\b} ("(" op=/{[!<>]=|[=<>]} (IDENT | INT) ")")?;
 
 // Terminals
 COMMENT -> /{ //.*$
             | (?s: /\* (?: [^*] | \*+[^*/] ) \*/ )
             };
-IDENT   -> /{@|\.?[A-Za-z_]\w*};
+IDENT   -> /{@\B|\.?[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)*};
 INT     -> \d+;
-STR     -> /{ " (?: \\. | [^\\"] )* "
-            | ' (?: \\. | [^\\'] )* '
-            | ‵ (?: ‵‵  | [^‵]   )* ‵
+STR     -> /{ i?
+              (?: " (?: \\. | [^\\"] )* "
+                | ' (?: \\. | [^\\'] )* '
+                | ‵ (?: ‵‵  | [^‵]   )* ‵
+              )
             };
 RE      -> /{
              /{
@@ -1515,8 +2131,13 @@ RE      -> /{
            };
 
 // Special
-pragma  -> import | macrodef {
-                import   -> ".import" path=((".."|"."|[a-zA-Z0-9.:]+):,"/") ";"?;
+pragma  -> import | export | macrodef {
+                import   -> ".import" path=((".."|"."|[a-zA-Z0-9.:]+):,"/")
+                            ("as" namespace=IDENT)?
+                            ("(" rules=IDENT:"," ")")?
+                            ("prefix" prefix=IDENT)?
+                            ("rename" "(" rename=(from=IDENT "->" to=IDENT):"," ")")? ";"?;
+                export   -> ".export" IDENT:"," ";"?;
                 macrodef -> ".macro" name=IDENT "(" args=IDENT:","? ")" "{" term "}" ";"?;
             };
 